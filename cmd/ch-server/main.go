@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -42,12 +44,9 @@ func main() {
 	if err != nil {
 		stderr.Fatalf("Unable to create handler: %s", err)
 	}
-	syrAuth, err := infrastructure.NewSYRAuth(
-		config.SYR.URL_auth,
-		config.SYR.Token_field,
-		config.SYR.Token_header,
-		config.SYR_login,
-		config.SYR_password)
+	defer dbHandler.Close()
+	// Create a new authorization on SYR by strategy from config
+	syrAuth, err := infrastructure.NewAuthStrategy(authConfig(config, config.SYR.Auth))
 	if err != nil {
 		stderr.Fatalf("Unable to create SYR authorize: %s", err)
 	}
@@ -61,16 +60,107 @@ func main() {
 	if err != nil {
 		stderr.Fatalf("Unable to create SYR config: %s", err)
 	}
+	if err := syrConfig.UseTus(config.SYR.Tus_destinations...); err != nil {
+		stderr.Fatalf("Unable to set tus destinations of SYR: %s", err)
+	}
+	if err := syrConfig.SetTusChunk(config.SYR.Tus_chunk_size); err != nil {
+		stderr.Fatalf("Unable to set tus chunk of SYR: %s", err)
+	}
+	if err := syrConfig.SetRemoteID(config.SYR.Remote_id); err != nil {
+		stderr.Fatalf("Unable to set remote id of SYR: %s", err)
+	}
+	if err := syrConfig.SetAttributes(config.SYR.Forward_attributes, config.SYR.Attribute_prefix); err != nil {
+		stderr.Fatalf("Unable to set attributes of SYR: %s", err)
+	}
+	// Templates of outbound requests, route without template uses default one
+	if tmpl, err := outboundTemplate(config.SYR.Template); err != nil {
+		stderr.Fatalf("Unable to create template of SYR: %s", err)
+	} else if tmpl != nil {
+		syrConfig.SetTemplate("", tmpl)
+	}
+	// Routes to destinations of SYR chosen by metadata, routes of one
+	// auth profile share its authorization
+	routes := make([]usecases.Route, 0, len(config.SYR.Routes))
+	for _, r := range config.SYR.Routes {
+		routes = append(routes, usecases.Route{
+			Name:         r.Name,
+			SystemType:   r.System_type,
+			Service:      r.Service,
+			LogLevel:     r.Log_level,
+			Originator:   r.Originator,
+			SerialPrefix: r.Serial_prefix,
+		})
+		if tmpl, err := outboundTemplate(r.Template); err != nil {
+			stderr.Fatalf("Unable to create template of route %s: %s", r.Name, err)
+		} else if tmpl != nil {
+			syrConfig.SetTemplate(r.Name, tmpl)
+		}
+		if r.Auth_profile == "" {
+			if err := syrConfig.AddRoute(r.Name, r.URL_path, r.Field_form, r.File_ext, nil); err != nil {
+				stderr.Fatalf("Unable to add route %s: %s", r.Name, err)
+			}
+		} else if _, ok := config.SYR.Auth_profiles[r.Auth_profile]; !ok {
+			stderr.Fatalf("Unable to add route %s: unknown auth profile %s", r.Name, r.Auth_profile)
+		}
+	}
+	for name, profile := range config.SYR.Auth_profiles {
+		profileAuth, err := infrastructure.NewAuthStrategy(authConfig(config, profile))
+		if err != nil {
+			stderr.Fatalf("Unable to create auth profile %s: %s", name, err)
+		}
+		for _, r := range config.SYR.Routes {
+			if r.Auth_profile != name {
+				continue
+			}
+			if err := syrConfig.AddRoute(r.Name, r.URL_path, r.Field_form, r.File_ext, profileAuth); err != nil {
+				stderr.Fatalf("Unable to add route %s: %s", r.Name, err)
+			}
+		}
+	}
+	// Create a new retry policy of uploads to SYR
+	retryPolicy, err := infrastructure.NewRetryPolicy(
+		config.SYR.Retry.Max_attempts,
+		config.SYR.Retry.Backoff_base,
+		config.SYR.Retry.Backoff_cap,
+		config.SYR.Retry.Jitter,
+		config.SYR.Retry.Status_codes)
+	if err != nil {
+		stderr.Fatalf("Unable to create SYR retry policy: %s", err)
+	}
+	// Create a new pool of workers to forward uploads concurrently
+	workerPool, err := infrastructure.NewWorkerPool(
+		config.SYR.Pool.Workers,
+		config.SYR.Pool.Per_destination,
+		config.SYR.Pool.Destinations)
+	if err != nil {
+		stderr.Fatalf("Unable to create SYR worker pool: %s", err)
+	}
+	// Create a new persisted outbox to keep uploads until they are forwarded
+	outbox, err := interfaces.NewDbQueue(dbHandler, "outbox")
+	if err != nil {
+		stderr.Fatalf("Unable to create outbox: %s", err)
+	}
+	// Create a new dead-letter queue to keep uploads which are failed
+	deadletter, err := interfaces.NewDbQueue(dbHandler, "deadletter")
+	if err != nil {
+		stderr.Fatalf("Unable to create dead-letter queue: %s", err)
+	}
 	// Create a new SYR handler to upload files
 	syrHandler, err := infrastructure.NewSYRHandler(
 		syrConfig,
+		retryPolicy,
 		syrAuth,
+		outbox,
+		deadletter,
 		stderr)
 	if err != nil {
 		stderr.Fatalf("Unable to create SYR config: %s", err)
 	}
 	// Create a new handler to invoke tusd functions
-	invokeHandler, err := infrastructure.NewTusdInvoke(composer)
+	invokeHandler, err := infrastructure.NewTusdInvoke(
+		composer,
+		config.Tusd.File_path,
+		config.Reconcile.Quarantine_path)
 	if err != nil {
 		stderr.Fatalf("Unable to create handler: %s", err)
 	}
@@ -79,7 +169,108 @@ func main() {
 	if err != nil {
 		stderr.Fatalf("Unable to create handler: %s", err)
 	}
-	httpClientHandler, err := interfaces.NewHTTPClient(syrHandler, stdout)
+	// Build indexes of metadata stored by previous versions
+	count, err := repositoryHandler.Reindex()
+	if err != nil {
+		stderr.Fatalf("Unable to build indexes: %s", err)
+	}
+	stdout.Printf("Indexes of %d uploads are built\n", count)
+	if err := syrHandler.SetWorkerPool(workerPool); err != nil {
+		stderr.Fatalf("Unable to set worker pool of SYR handler: %s", err)
+	}
+	// Health reports state of circuit breakers of SYR and sinks
+	healthHandler, err := interfaces.NewHealthHandler()
+	if err != nil {
+		stderr.Fatalf("Unable to create health handler: %s", err)
+	}
+	if !config.SYR.Breaker.Disabled {
+		breaker, err := infrastructure.NewBreaker(
+			config.SYR.Breaker.Threshold,
+			config.SYR.Breaker.Probe_interval,
+			config.SYR.Breaker.Health_url)
+		if err != nil {
+			stderr.Fatalf("Unable to create breaker of SYR: %s", err)
+		}
+		if err := syrHandler.SetBreaker(breaker); err != nil {
+			stderr.Fatalf("Unable to set breaker of SYR handler: %s", err)
+		}
+		if err := healthHandler.AddBreaker(infrastructure.DefaultSink, breaker); err != nil {
+			stderr.Fatalf("Unable to report breaker of SYR: %s", err)
+		}
+	}
+	// Create a new fan-out to forward uploads to SYR and other sinks
+	fanoutHandler, err := infrastructure.NewFanoutHandler(stderr)
+	if err != nil {
+		stderr.Fatalf("Unable to create fan-out handler: %s", err)
+	}
+	if err := fanoutHandler.AddSink(infrastructure.DefaultSink, true, syrHandler); err != nil {
+		stderr.Fatalf("Unable to add SYR sink: %s", err)
+	}
+	// Sinks share authorization, retry policy and pool of workers of SYR,
+	// each of them has its own outbox and dead-letter queue
+	for _, sink := range config.SYR.Sinks {
+		sinkConfig, err := infrastructure.NewSYRConfig(
+			sink.URL_path,
+			config.SYR.File_path,
+			config.SYR.Field_form,
+			config.SYR.File_ext)
+		if err != nil {
+			stderr.Fatalf("Unable to create config of sink %s: %s", sink.Name, err)
+		}
+		if err := sinkConfig.UseTus(config.SYR.Tus_destinations...); err != nil {
+			stderr.Fatalf("Unable to set tus destinations of sink %s: %s", sink.Name, err)
+		}
+		if err := sinkConfig.SetTusChunk(config.SYR.Tus_chunk_size); err != nil {
+			stderr.Fatalf("Unable to set tus chunk of sink %s: %s", sink.Name, err)
+		}
+		if err := sinkConfig.SetRemoteID(config.SYR.Remote_id); err != nil {
+			stderr.Fatalf("Unable to set remote id of sink %s: %s", sink.Name, err)
+		}
+		if err := sinkConfig.SetAttributes(config.SYR.Forward_attributes, config.SYR.Attribute_prefix); err != nil {
+			stderr.Fatalf("Unable to set attributes of sink %s: %s", sink.Name, err)
+		}
+		sinkOutbox, err := interfaces.NewDbQueue(dbHandler, "outbox-"+sink.Name)
+		if err != nil {
+			stderr.Fatalf("Unable to create outbox of sink %s: %s", sink.Name, err)
+		}
+		sinkDeadletter, err := interfaces.NewDbQueue(dbHandler, "deadletter-"+sink.Name)
+		if err != nil {
+			stderr.Fatalf("Unable to create dead-letter queue of sink %s: %s", sink.Name, err)
+		}
+		sinkHandler, err := infrastructure.NewSYRHandler(
+			sinkConfig,
+			retryPolicy,
+			syrAuth,
+			sinkOutbox,
+			sinkDeadletter,
+			stderr)
+		if err != nil {
+			stderr.Fatalf("Unable to create handler of sink %s: %s", sink.Name, err)
+		}
+		if err := sinkHandler.SetWorkerPool(workerPool); err != nil {
+			stderr.Fatalf("Unable to set worker pool of sink %s: %s", sink.Name, err)
+		}
+		// Sink is probed by HEAD of its url, local archive isn't probed
+		if !config.SYR.Breaker.Disabled && !strings.HasPrefix(sink.URL_path, "file:") {
+			breaker, err := infrastructure.NewBreaker(
+				config.SYR.Breaker.Threshold,
+				config.SYR.Breaker.Probe_interval,
+				"")
+			if err != nil {
+				stderr.Fatalf("Unable to create breaker of sink %s: %s", sink.Name, err)
+			}
+			if err := sinkHandler.SetBreaker(breaker); err != nil {
+				stderr.Fatalf("Unable to set breaker of sink %s: %s", sink.Name, err)
+			}
+			if err := healthHandler.AddBreaker(sink.Name, breaker); err != nil {
+				stderr.Fatalf("Unable to report breaker of sink %s: %s", sink.Name, err)
+			}
+		}
+		if err := fanoutHandler.AddSink(sink.Name, sink.Required, sinkHandler); err != nil {
+			stderr.Fatalf("Unable to add sink %s: %s", sink.Name, err)
+		}
+	}
+	httpClientHandler, err := interfaces.NewHTTPClient(fanoutHandler, stdout)
 	if err != nil {
 		stderr.Fatalf("Unable to create handler: %s", err)
 	}
@@ -89,11 +280,79 @@ func main() {
 	if err != nil {
 		stderr.Fatalf("Unable to create dataAgent: %s", err)
 	}
+	if err := dataAgent.SetRoutes(routes, config.SYR.Reject_unmatched); err != nil {
+		stderr.Fatalf("Unable to set routes: %s", err)
+	}
+	if err := dataAgent.SetOrphanGrace(config.Reconcile.Orphan_grace); err != nil {
+		stderr.Fatalf("Unable to set grace of orphans: %s", err)
+	}
 	// Create hooksHandler to invoke hooks
-	hooksHandler, err := interfaces.NewHooksHandler(dataAgent, syrHandler, stdout)
+	hooksHandler, err := interfaces.NewHooksHandler(dataAgent, fanoutHandler, stdout)
 	if err != nil {
 		stderr.Fatalf("Unable to create dataAgent: %s", err)
 	}
+	if err := hooksHandler.SetAttributeLimits(
+		config.Metadata.Attributes.Max_count,
+		config.Metadata.Attributes.Max_name_length,
+		config.Metadata.Attributes.Max_value_length); err != nil {
+		stderr.Fatalf("Unable to set limits of attributes: %s", err)
+	}
+	// Validate metadata of uploads by schema from file
+	if config.Metadata.Schema_path != "" {
+		schema, err := metadataSchema(config.Metadata.Schema_path)
+		if err != nil {
+			stderr.Fatalf("Unable to load schema of metadata: %s", err)
+		}
+		if err := hooksHandler.SetSchema(schema); err != nil {
+			stderr.Fatalf("Unable to set schema of metadata: %s", err)
+		}
+	}
+	// Save results of forwarding to sinks in metadata
+	if err := fanoutHandler.SetHooksHandler(hooksHandler); err != nil {
+		stderr.Fatalf("Unable to set hooks of fan-out handler: %s", err)
+	}
+	// Forward uploads to sinks while they are arriving
+	if config.SYR.Stream.Enabled {
+		if err := fanoutHandler.EnableStream(config.SYR.Stream.Stall_timeout); err != nil {
+			stderr.Fatalf("Unable to enable stream of fan-out handler: %s", err)
+		}
+		if err := hooksHandler.SetStreamHandler(fanoutHandler); err != nil {
+			stderr.Fatalf("Unable to set stream of hooks handler: %s", err)
+		}
+	}
+
+	// Notify webhooks about events of uploads
+	var webhookHandler *infrastructure.WebhookHandler
+	if len(config.Webhooks.Endpoints) > 0 {
+		webhookRetry, err := infrastructure.NewRetryPolicy(
+			config.Webhooks.Max_attempts,
+			config.Webhooks.Backoff_base,
+			config.Webhooks.Backoff_cap,
+			config.Webhooks.Jitter,
+			config.Webhooks.Status_codes)
+		if err != nil {
+			stderr.Fatalf("Unable to create retry policy of webhooks: %s", err)
+		}
+		webhookHandler, err = infrastructure.NewWebhookHandler(
+			config.Tusd.File_path,
+			webhookRetry,
+			config.Webhooks.Timeout,
+			stderr)
+		if err != nil {
+			stderr.Fatalf("Unable to create webhook handler: %s", err)
+		}
+		if err := webhookHandler.SetWorkers(config.Webhooks.Workers); err != nil {
+			stderr.Fatalf("Unable to set workers of webhook handler: %s", err)
+		}
+		for _, e := range config.Webhooks.Endpoints {
+			if err := webhookHandler.AddWebhook(e.URL, e.Secret, e.Events); err != nil {
+				stderr.Fatalf("Unable to add webhook %s: %s", e.URL, err)
+			}
+		}
+		if err := hooksHandler.SetNotifyHandler(webhookHandler); err != nil {
+			stderr.Fatalf("Unable to set notify handler of hooks handler: %s", err)
+		}
+	}
 
 	// Create a new hooks handler to manage notice from tusd
 	hooksTusdHandler, err := infrastructure.NewHooksTusdHandler(
@@ -103,28 +362,40 @@ func main() {
 	if err != nil {
 		stderr.Fatalf("Unable to create hooksTusdHandler: %s", err)
 	}
+	if err := hooksTusdHandler.SetCompleteWorkers(config.Tusd.Complete_workers); err != nil {
+		stderr.Fatalf("Unable to set workers of hooksTusdHandler: %s", err)
+	}
+	for _, h := range config.Hooks {
+		if err := addHook(hooksTusdHandler, h.Types, h.Command, h.URL, h.Timeout); err != nil {
+			stderr.Fatalf("Unable to add hook %s%s: %s", h.Command, h.URL, err)
+		}
+	}
 
 	// tusd service will start listening on and accept request at
 	http.Handle(
 		config.Tusd.URL_path,
-		http.StripPrefix(config.Tusd.URL_path, tusdHandler))
+		http.StripPrefix(config.Tusd.URL_path,
+			infrastructure.ChecksumMiddleware(infrastructure.ErrorMiddleware(tusdHandler), fanoutHandler)))
+	// health and metrics of server
+	http.Handle(config.Health.URL_path, healthHandler)
+	http.HandleFunc(config.Health.Metrics_path, healthHandler.Metrics)
+	// admin API will start listening on, if token is set
+	if config.Admin.Token != "" {
+		adminHandler, err := interfaces.NewAdminHandler(dataAgent, config.Admin.Token, stdout)
+		if err != nil {
+			stderr.Fatalf("Unable to create adminHandler: %s", err)
+		}
+		http.Handle(
+			config.Admin.URL_path,
+			http.StripPrefix(strings.TrimSuffix(config.Admin.URL_path, "/"), adminHandler))
+	}
 	srv := &http.Server{Addr: config.Tusd.URL_addr, Handler: nil}
 
 	// Create wait group variable for goroutines
 	var wg sync.WaitGroup
 	// Channel for exit app.
-	exit := make(chan bool, 4)
+	exit := make(chan bool, 5)
 	ctx, cancel := context.WithCancel(context.Background())
-	// Tusd server goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		// Start tusd service to listen
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			stderr.Printf("[tusd] Unable to listen: %s", err)
-		}
-		exit <- true
-	}()
 	// Tusd Hooks goroutine
 	wg.Add(1)
 	go func() {
@@ -141,12 +412,48 @@ func main() {
 	go func() {
 		defer wg.Done()
 		// Start hooks from tusd to usecases
-		err := syrHandler.Run(ctx, nil)
+		err := fanoutHandler.Run(ctx, nil)
 		if err != nil {
 			stderr.Printf("[syr] Unable to run: %s", err)
 		}
 		exit <- true
 	}()
+	// Webhooks goroutine
+	if webhookHandler != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := webhookHandler.Run(ctx); err != nil {
+				stderr.Printf("[webhook] Unable to run: %s", err)
+			}
+			exit <- true
+		}()
+	}
+	// Reconcile metadata with files of tusd left by previous run before
+	// uploads are accepted, resent uploads are taken by running clients
+	if config.Reconcile.On_start {
+		report, err := dataAgent.Reconcile(config.Reconcile.Orphans)
+		if err != nil {
+			stderr.Printf("[reconcile] Unable to run: %s", err)
+		} else {
+			stdout.Printf("[reconcile]: uploads = %d; migrated = %d; missing = %d; orphans = %d; resent = %d; errors = %d\n",
+				report.Uploads, len(report.Migrated), len(report.Missing), len(report.Orphans), len(report.Resent),
+				len(report.Errors))
+			for _, e := range report.Errors {
+				stderr.Printf("[reconcile] %s", e)
+			}
+		}
+	}
+	// Tusd server goroutine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Start tusd service to listen
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			stderr.Printf("[tusd] Unable to listen: %s", err)
+		}
+		exit <- true
+	}()
 	// Handle exit of program
 	wg.Add(1)
 	go func() {
@@ -170,3 +477,88 @@ func main() {
 	cancel()
 	wg.Wait()
 }
+
+// outboundTemplate - template of outbound request from config, nil if
+// template isn't set
+func outboundTemplate(t config.Template) (*infrastructure.Template, error) {
+	if t.Path == "" && len(t.Query) == 0 && len(t.Headers) == 0 && len(t.Fields) == 0 {
+		return nil, nil
+	}
+	return infrastructure.NewTemplate(t.Path, t.Query, t.Headers, t.Fields)
+}
+
+// metadataSchema - versioned schema of metadata from YAML or JSON file
+func metadataSchema(path string) (*interfaces.MetadataSchema, error) {
+	cfg, err := config.LoadSchema(path)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := interfaces.NewMetadataSchema(cfg.Version_field, cfg.Default)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range cfg.Versions {
+		fields := make([]interfaces.FieldSchema, 0, len(v.Fields))
+		for _, f := range v.Fields {
+			fields = append(fields, interfaces.FieldSchema{
+				Name:      f.Name,
+				Type:      f.Type,
+				Required:  f.Required,
+				Format:    f.Format,
+				Pattern:   f.Pattern,
+				MaxLength: f.Max_length,
+				Enum:      f.Enum,
+			})
+		}
+		if err := schema.AddVersion(v.Version, fields); err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+// addHook - invoke command or http endpoint on hooks of tusd by types
+func addHook(handler *infrastructure.HooksTusdHandler, types []string, command, url string, timeout time.Duration) error {
+	if (command == "") == (url == "") {
+		return errors.New("either command or url of hook must be set")
+	}
+	for _, typ := range types {
+		var err error
+		if command != "" {
+			var hook *infrastructure.CommandHook
+			if hook, err = infrastructure.NewCommandHook(command, timeout); err == nil {
+				err = handler.AddHook(typ, hook)
+			}
+		} else {
+			var hook *infrastructure.HTTPHook
+			if hook, err = infrastructure.NewHTTPHook(url, timeout); err == nil {
+				err = handler.AddHook(typ, hook)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authConfig - settings of authorization on SYR by auth from config,
+// login strategy uses common url and credentials of SYR
+func authConfig(cfg *config.Config, auth config.Auth) infrastructure.AuthConfig {
+	return infrastructure.AuthConfig{
+		Strategy:     auth.Strategy,
+		URLAuth:      cfg.SYR.URL_auth,
+		TokenField:   cfg.SYR.Token_field,
+		TokenHeader:  cfg.SYR.Token_header,
+		Login:        cfg.SYR_login,
+		Password:     cfg.SYR_password,
+		Token:        auth.Token,
+		TokenURL:     auth.Token_url,
+		ClientID:     auth.Client_id,
+		ClientSecret: auth.Client_secret,
+		Scopes:       auth.Scopes,
+		CertFile:     auth.Cert_file,
+		KeyFile:      auth.Key_file,
+		CAFile:       auth.CA_file,
+	}
+}
@@ -38,6 +38,23 @@ ZXIiICAgOiIiLAogICAgIkNhbmNlbCIgICAgICAgICAgICAgICAgOiAiTm8i
 LAogICAgIlN0YXJ0VGltZXN0YW1wIiAgICAgICAgOiAiIiwKICAgICJGaW5p
 c2hUaW1lc3RhbXAiICAgICAgIDogIiIKICAgIH0=`
 
+// verified - metadata with checksum of body of upload "hello world!"
+const verified = `
+ewogICAgIlNlcnZpY2UiICAgICAgICAgICAgICAgOiAidHJ1ZSIsCiAgICAi
+U2VyaWFsTnVtYmVyIiAgICAgICAgICA6ICIwMTIzNDU2Nzg5IiwKICAgICJM
+b2dDb2xsZWN0aW9uVGltZXN0YW1wIjogIlRodSBEZWMgMjMgMTQ6MDA6MTMg
+TVNLIDIwMTkiLAogICAgIkNsaWVudFN0YXJ0VGltZXN0YW1wIiAgOiAiVGh1
+IE5vdiAwNiAxNDowMDowNiBNU0sgMjAxOSIsCiAgICAiU3lzdGVtVHlwZSIg
+ICAgICAgICAgICA6ICJ0YXRsaW4iLAogICAgIkxvZ0xldmVsIiAgICAgICAg
+ICAgICAgOiAiU3lzdGVtIiwKICAgICJPcmlnaW5hdG9yIiAgICAgICAgICAg
+IDogInN5c3RlbSIsCiAgICAiU2Vzc2lvbklEIiAgICAgICAgICAgICA6ICIi
+LAogICAgIkNoZWNrc3VtIiAgICAgICAgICAgICAgOiAiZmMzZmY5OGU4YzZh
+MGQzMDg3ZDUxNWMwNDczZjg2NzciLAogICAgIkhvc3RuYW1lIiAgICAgICAg
+ICAgICAgOiAiZXhhbXBsZS5jb20iLAogICAgIk5vdGlmaWNhdGlvbk1hbmFn
+ZXIiICAgOiIiLAogICAgIkNhbmNlbCIgICAgICAgICAgICAgICAgOiAiTm8i
+LAogICAgIlN0YXJ0VGltZXN0YW1wIiAgICAgICAgOiAiIiwKICAgICJGaW5p
+c2hUaW1lc3RhbXAiICAgICAgIDogIiIKICAgIH0=`
+
 func TestMain(t *testing.T) {
 	// New composer of tus
 	composer := infrastructure.NewStoreComposer()
@@ -54,13 +71,14 @@ func TestMain(t *testing.T) {
 	// New boltHandler for store metadata
 	var testbuffer bytes.Buffer
 	logger := log.New(&testbuffer, "[test] ", log.LstdFlags)
-	dbfile := "/tmp/test.db"
+	dbfile := "/tmp/test-main.db"
 	dbHandler, err := repository.NewBoltHandler(
 		dbfile,
 		logger)
 	assert.Nil(t, err)
 	assert.NotNil(t, dbHandler)
 	defer os.Remove(dbfile)
+	defer dbHandler.Close()
 	// Emulate response of authorization from SYR server
 	tokenfield := "message"
 	token := "0123456789"
@@ -93,10 +111,21 @@ func TestMain(t *testing.T) {
 	var errorbuffer bytes.Buffer
 	logerr := log.New(&errorbuffer, "[error] ", log.LstdFlags)
 	// New SYR handler for handle upload files to SYR server
-	syrHandler, _ := infrastructure.NewSYRHandler(syrConfig, syrAuth, logerr)
+	// New outbox to persist jobs of forwarding
+	outbox, err := interfaces.NewDbQueue(dbHandler, "outbox")
+	assert.Nil(t, err)
+	assert.NotNil(t, outbox)
+	deadletter, err := interfaces.NewDbQueue(dbHandler, "deadletter")
+	assert.Nil(t, err)
+	assert.NotNil(t, deadletter)
+	// New retry policy of uploads to SYR
+	retryPolicy, err := infrastructure.NewRetryPolicy(3, time.Millisecond, time.Second, 0, []int{503})
+	assert.Nil(t, err)
+	assert.NotNil(t, retryPolicy)
+	syrHandler, _ := infrastructure.NewSYRHandler(syrConfig, retryPolicy, syrAuth, outbox, deadletter, logerr)
 	assert.NotNil(t, syrHandler)
 	// Create a new handler to invoke tusd functions
-	invokeHandler, err := infrastructure.NewTusdInvoke(composer)
+	invokeHandler, err := infrastructure.NewTusdInvoke(composer, filepath, filepath+"/quarantine")
 	assert.Nil(t, err)
 	assert.NotNil(t, invokeHandler)
 	// New repository handler to save metadata
@@ -115,6 +144,8 @@ func TestMain(t *testing.T) {
 	hooksHandler, err := interfaces.NewHooksHandler(dataAgent, syrHandler, logger)
 	assert.Nil(t, err)
 	assert.NotNil(t, hooksHandler)
+	err = syrHandler.SetHooksHandler(hooksHandler)
+	assert.Nil(t, err)
 	// New hooks handler to manage notice from tusd
 	hooksTusdHandler, err := infrastructure.NewHooksTusdHandler(composer, hooksHandler, logerr)
 	assert.Nil(t, err)
@@ -152,7 +183,7 @@ func TestMain(t *testing.T) {
 				"Upload-Offset":   "0",
 				"Upload-Length":   strconv.Itoa(len(body)),
 				"Content-Type":    "application/offset+octet-stream",
-				"Upload-Metadata": "data " + data + ", filename d29ybGQ=",
+				"Upload-Metadata": "data " + verified + ", filename d29ybGQ=",
 			},
 			// check response
 			ReqBody: strings.NewReader(body),
@@ -167,7 +198,43 @@ func TestMain(t *testing.T) {
 		wg.Wait()
 		assert.NotNil(t, res)
 		// check log output
-		assert.Containsf(t, testbuffer.String(), string("[hooks] [terminate]"), "log: %s", testbuffer.String())
+		assert.Containsf(t, testbuffer.String(), string("[hooks] [forwarded]"), "log: %s", testbuffer.String())
+		assert.Containsf(t, testbuffer.String(), string("[hooks] [purge]"), "log: %s", testbuffer.String())
+	})
+	// Test with checksum which doesn't match body
+	t.Run("invalid checksum", func(t *testing.T) {
+		testbuffer.Reset()
+		errorbuffer.Reset()
+		var wg sync.WaitGroup
+		ctx, cancel := context.WithCancel(context.Background())
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, hooksTusdHandler.RunHooks(ctx, tusdHandler))
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, syrHandler.Run(ctx, nil))
+		}()
+		const body = "hello world!"
+		(&httpTest{
+			Method: http.MethodPost,
+			ReqHeader: map[string]string{
+				"Tus-Resumable":   "1.0.0",
+				"Upload-Offset":   "0",
+				"Upload-Length":   strconv.Itoa(len(body)),
+				"Content-Type":    "application/offset+octet-stream",
+				"Upload-Metadata": "data " + data + ", filename d29ybGQ=",
+			},
+			ReqBody: strings.NewReader(body),
+			Code:    http.StatusCreated,
+		}).Run(tusdHandler, t)
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		wg.Wait()
+		// Corrupted upload isn't forwarded
+		assert.Containsf(t, errorbuffer.String(), "is corrupted", "log: %s", errorbuffer.String())
+		assert.NotContainsf(t, testbuffer.String(), "[hooks] [forwarded]", "log: %s", testbuffer.String())
 	})
 }
 
@@ -1,6 +1,10 @@
 package config
 
-import "github.com/jinzhu/configor"
+import (
+	"time"
+
+	"github.com/jinzhu/configor"
+)
 
 // Config - struct for wrap configure
 type Config struct {
@@ -8,6 +12,21 @@ type Config struct {
 		File_path string
 		URL_path  string
 		URL_addr  string
+		// Complete_workers - complete uploads which are verified and sent
+		// concurrently, checksum of large file doesn't hold other hooks
+		Complete_workers int `default:"1"`
+	}
+
+	// Metadata - schema of metadata of uploads, see Schema. Fields which are
+	// unknown to server are kept as attributes of upload within limits,
+	// fields declared by schema are limited by schema only
+	Metadata struct {
+		Schema_path string
+		Attributes  struct {
+			Max_count        int `default:"32"`
+			Max_name_length  int `default:"64"`
+			Max_value_length int `default:"1024"`
+		}
 	}
 
 	DB struct {
@@ -25,12 +44,167 @@ type Config struct {
 		URL_auth     string
 		Token_field  string
 		Token_header string
+		// Tus_destinations - hosts which are forwarded by tus protocol,
+		// others get multipart form
+		Tus_destinations []string
+		// Tus_chunk_size - bytes of one PATCH request of tus upload, each
+		// request is limited by timeout of client
+		Tus_chunk_size int64 `default:"8388608"`
+		// Remote_id - path of id of upload in JSON response of SYR, keys
+		// are separated by dots, e.g. "data.attachment.id"
+		Remote_id string
+		// Forward_attributes - attributes of uploads are sent as fields of
+		// form or tus metadata, names of fields are prefixed by Attribute_prefix
+		Forward_attributes bool
+		Attribute_prefix   string
+
+		Auth Auth
+		// Auth_profiles - authorizations by name which are used by routes
+		Auth_profiles map[string]Auth
+
+		// Routes - rules to choose destination of SYR by metadata, the
+		// first matched rule is used and empty condition matches any value.
+		// Unmatched upload goes to url_path or is rejected on pre-create
+		// if Reject_unmatched is set
+		Routes []struct {
+			Name          string
+			System_type   string
+			Service       string
+			Log_level     string
+			Originator    string
+			Serial_prefix string
+			URL_path      string
+			Field_form    string
+			File_ext      string
+			Auth_profile  string
+			Template      Template
+		}
+		Reject_unmatched bool
+
+		// Template - outbound request built from metadata of upload, it is
+		// overridden by template of route
+		Template Template
+
+		Retry struct {
+			Max_attempts int           `default:"5"`
+			Backoff_base time.Duration `default:"1s"`
+			Backoff_cap  time.Duration `default:"5m"`
+			Jitter       float64       `default:"0.2"`
+			Status_codes []int         `default:"[408, 429, 500, 502, 503, 504]"`
+		}
+
+		// Pool - concurrent uploads, workers and limits by host are shared
+		// by SYR and sinks
+		Pool struct {
+			Workers         int `default:"4"`
+			Per_destination int `default:"2"`
+			Destinations    map[string]int
+		}
+
+		// Sinks - destinations of uploads besides SYR, url with scheme file
+		// is a local archive. File of upload is deleted after SYR and all
+		// required sinks have it
+		Sinks []struct {
+			Name     string
+			URL_path string
+			Required bool
+		}
+
+		// Breaker - pause forwarding to SYR or sink after Threshold
+		// consecutive failures and probe it every Probe_interval by
+		// Health_url, url of SYR or sink is probed by HEAD without it
+		Breaker struct {
+			Disabled       bool
+			Threshold      int           `default:"5"`
+			Probe_interval time.Duration `default:"30s"`
+			Health_url     string
+		}
+
+		// Stream - forward upload while it is arriving, result of forwarding
+		// is saved after upload is complete and its checksum is verified
+		Stream struct {
+			Enabled       bool
+			Stall_timeout time.Duration `default:"5m"`
+		}
+	}
+
+	// Webhooks - endpoints which are notified about events of uploads:
+	// created, complete, forwarded, failed and terminated. Endpoint without
+	// events gets all of them, request is signed by secret of endpoint.
+	// Events are delivered by Workers with own retry policy
+	Webhooks struct {
+		Workers      int           `default:"4"`
+		Max_attempts int           `default:"5"`
+		Backoff_base time.Duration `default:"1s"`
+		Backoff_cap  time.Duration `default:"1m"`
+		Jitter       float64       `default:"0.2"`
+		Status_codes []int         `default:"[408, 429, 500, 502, 503, 504]"`
+		Timeout      time.Duration `default:"10s"`
+		Endpoints    []struct {
+			URL    string
+			Secret string
+			Events []string
+		}
+	}
+
+	// Hooks - commands and http endpoints which are invoked on hooks of tusd:
+	// pre-create, post-create, post-receive, post-finish, post-terminate and
+	// post-purge (file is deleted after forwarding). Only pre-create is
+	// blocking and may reject upload, other hooks are run in background and
+	// skipped while 16 of them are running
+	Hooks []struct {
+		Types   []string
+		Command string
+		URL     string
+		Timeout time.Duration `default:"10s"`
+	}
+
+	Health struct {
+		URL_path     string `default:"/health"`
+		Metrics_path string `default:"/metrics"`
+	}
+
+	Admin struct {
+		URL_path string `default:"/admin/"`
+		Token    string `env:"ADMIN_TOKEN"`
+	}
+
+	Reconcile struct {
+		On_start        bool   `default:"true"`
+		Orphans         string `default:"keep"`
+		Quarantine_path string `default:"./uploads/quarantine"`
+		// Orphan_grace - file without metadata is orphaned after this age,
+		// metadata of new upload is stored after its file is created
+		Orphan_grace time.Duration `default:"1h"`
 	}
 
 	SYR_login    string
 	SYR_password string
 }
 
+// Auth - strategy of authorization: login (by url_auth, syr_login
+// and syr_password), bearer, basic, oauth2 or mtls
+type Auth struct {
+	Strategy      string `default:"login"`
+	Token         string `env:"SYR_TOKEN"`
+	Token_url     string
+	Client_id     string
+	Client_secret string `env:"SYR_CLIENT_SECRET"`
+	Scopes        []string
+	Cert_file     string
+	Key_file      string
+	CA_file       string
+}
+
+// Template - Go templates on metadata of upload, e.g. "{{.SystemType}}".
+// Path is joined to url of destination instead of serial number
+type Template struct {
+	Path    string
+	Query   map[string]string
+	Headers map[string]string
+	Fields  map[string]string
+}
+
 // NewConfig - create access to config
 func NewConfig() (*Config, error) {
 	cfg := Config{}
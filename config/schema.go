@@ -0,0 +1,42 @@
+package config
+
+import (
+	"github.com/jinzhu/configor"
+)
+
+// Schema - versioned schemas of metadata of upload, version of metadata is
+// read from Version_field, metadata without it uses Default version.
+// Format of schema is own format of server, it isn't JSON Schema: each
+// version is a flat list of top-level fields with their rules
+type Schema struct {
+	Version_field string `default:"SchemaVersion"`
+	Default       string `default:"1"`
+	Versions      []struct {
+		Version string `required:"true"`
+		Fields  []SchemaField
+	}
+}
+
+// SchemaField - rules of field of metadata: type (string, number, integer
+// or boolean), format of time (unixdate, rfc3339 or rfc1123), regular
+// expression, max length and allowed values
+type SchemaField struct {
+	Name       string `required:"true"`
+	Type       string
+	Required   bool
+	Format     string
+	Pattern    string
+	Max_length int
+	Enum       []string
+}
+
+// LoadSchema - read schema of metadata in format of Schema from YAML or
+// JSON file, unknown keys are rejected, so JSON Schema isn't loaded
+func LoadSchema(path string) (*Schema, error) {
+	schema := Schema{}
+	err := configor.New(&configor.Config{ErrorOnUnmatchedKeys: true}).Load(&schema, path)
+	if err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
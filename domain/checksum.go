@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Algorithms of checksum, checksum without prefix of algorithm is md5
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+)
+
+// Results of checksum verification of uploaded file
+const (
+	// ChecksumMatch - checksum of file is equal to checksum from metadata
+	ChecksumMatch = "match"
+	// ChecksumMismatch - file is corrupted
+	ChecksumMismatch = "mismatch"
+	// ChecksumMissing - metadata has no checksum to verify file
+	ChecksumMissing = "missing"
+)
+
+// digestLengths - length of hex encoded digest of algorithm
+var digestLengths = map[string]int{
+	ChecksumMD5:    32,
+	ChecksumSHA1:   40,
+	ChecksumSHA256: 64,
+}
+
+// ParseChecksum - split checksum "<algorithm>:<hex digest>" to algorithm
+// and lower case digest
+func ParseChecksum(checksum string) (string, string, error) {
+	algorithm, digest := ChecksumMD5, checksum
+	if i := strings.IndexByte(checksum, ':'); i >= 0 {
+		algorithm, digest = strings.ToLower(checksum[:i]), checksum[i+1:]
+	}
+	length, ok := digestLengths[algorithm]
+	if !ok {
+		return "", "", errors.Errorf("[data] [checksum] unknown algorithm %q", algorithm)
+	}
+	digest = strings.ToLower(digest)
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != length {
+		return "", "", errors.Errorf("[data] [checksum] bad %s digest %q", algorithm, digest)
+	}
+	return algorithm, digest, nil
+}
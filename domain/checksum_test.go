@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseChecksum(t *testing.T) {
+	t.Run("valid without prefix", func(t *testing.T) {
+		algorithm, digest, err := ParseChecksum("D41D8CD98F00B204E9800998ECF8427E")
+		assert.Nil(t, err)
+		assert.Equal(t, ChecksumMD5, algorithm)
+		assert.Equal(t, "d41d8cd98f00b204e9800998ecf8427e", digest)
+	})
+	t.Run("valid with prefix", func(t *testing.T) {
+		algorithm, digest, err := ParseChecksum("SHA1:da39a3ee5e6b4b0d3255bfef95601890afd80709")
+		assert.Nil(t, err)
+		assert.Equal(t, ChecksumSHA1, algorithm)
+		assert.Equal(t, "da39a3ee5e6b4b0d3255bfef95601890afd80709", digest)
+		algorithm, _, err = ParseChecksum("sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+		assert.Nil(t, err)
+		assert.Equal(t, ChecksumSHA256, algorithm)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, checksum := range []string{
+			"crc32:00000000",
+			"md5:da39a3ee5e6b4b0d3255bfef95601890afd80709",
+			"sha1:not hex",
+			"",
+		} {
+			_, _, err := ParseChecksum(checksum)
+			assert.NotNil(t, err, checksum)
+		}
+	})
+}
@@ -1,6 +1,10 @@
 package domain
 
-import "github.com/pkg/errors"
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
 
 // DataRepository - interface for save Data, implemented in interfaces/repositories
 type DataRepository interface {
@@ -8,6 +12,12 @@ type DataRepository interface {
 	FindById(id string) (Data, error)
 	Remove(id string) error
 	ReadAll() ([]string, error)
+	Purge(id string) error
+	Query(query Query) ([]Data, string, error)
+	Checksum(id string, algorithm string) (string, error)
+	Files() ([]string, error)
+	Modified(id string) (time.Time, error)
+	Quarantine(id string) error
 }
 
 // Data - basic data to identify uploaded log file
@@ -22,12 +32,25 @@ type Data struct {
 	Originator             string
 	SessionID              string
 	Checksum               string
+	ChecksumResult         string
+	ComputedChecksum       string
 	Hostname               string
 	NotificationManager    string
 	Cancel                 string
 	StartTimestamp         string
 	FinishTimestamp        string
 	FileName               string
+	Attempts               int
+	LastError              string
+	State                  State
+	History                []Transition
+	// Deliveries - forwarding of upload to each sink by its name
+	Deliveries map[string]Delivery
+	// RemoteID - id of upload returned by sink, e.g. ticket of SYR
+	RemoteID string
+	// Attributes - extra fields of metadata of upload which are unknown
+	// to Data, they are kept as is
+	Attributes map[string]string
 }
 
 // Validate - test Data on correctness
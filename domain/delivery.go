@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Delivery - forwarding of upload to one sink. State is complete while
+// upload is forwarded, then it is forwarded or failed. RemoteID is id of
+// upload returned by sink, Timestamp is time of delivery
+type Delivery struct {
+	Required  bool
+	State     State
+	Attempts  int
+	LastError string
+	RemoteID  string
+	Timestamp string
+}
+
+// Dispatch - save sinks of upload, failed delivery is repeated.
+// Return sorted names of sinks which upload isn't forwarded to yet
+func (data *Data) Dispatch(sinks map[string]bool) []string {
+	if data.Deliveries == nil {
+		data.Deliveries = make(map[string]Delivery, len(sinks))
+	}
+	pending := make([]string, 0, len(sinks))
+	for sink, required := range sinks {
+		d := data.Deliveries[sink]
+		d.Required = required
+		if d.State != StateForwarded {
+			d.State = StateComplete
+			pending = append(pending, sink)
+		}
+		data.Deliveries[sink] = d
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// Deliver - save result of forwarding to sink, attempts are kept if they
+// are zero. Sink which isn't dispatched is required. Remote id of the first
+// required sink which returns it is id of upload on remote side
+func (data *Data) Deliver(sink string, state State, attempts int, reason string, remote string) error {
+	switch state {
+	case StateComplete, StateForwarded, StateFailed:
+	default:
+		return errors.Errorf("[data] [deliver] unknown state of delivery %q", state)
+	}
+	if sink == "" {
+		return errors.New("[data] [deliver] a sink may not be empty")
+	}
+	if data.Deliveries == nil {
+		data.Deliveries = make(map[string]Delivery)
+	}
+	d, ok := data.Deliveries[sink]
+	if !ok {
+		d.Required = true
+	}
+	d.State = state
+	if attempts > 0 {
+		d.Attempts = attempts
+	}
+	d.LastError = reason
+	if state == StateForwarded {
+		d.RemoteID = remote
+		d.Timestamp = time.Now().Format(time.UnixDate)
+		if d.Required && data.RemoteID == "" {
+			data.RemoteID = remote
+		}
+	}
+	data.Deliveries[sink] = d
+	return nil
+}
+
+// Delivered - return true if upload is forwarded to all required sinks
+func (data Data) Delivered() bool {
+	for _, d := range data.Deliveries {
+		if d.Required && d.State != StateForwarded {
+			return false
+		}
+	}
+	return len(data.Deliveries) > 0
+}
+
+// Undelivered - return true if forwarding to any required sink is failed
+func (data Data) Undelivered() bool {
+	for _, d := range data.Deliveries {
+		if d.Required && d.State == StateFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Settled - return true if no sink is forwarded anymore
+func (data Data) Settled() bool {
+	for _, d := range data.Deliveries {
+		if d.State == StateComplete {
+			return false
+		}
+	}
+	return true
+}
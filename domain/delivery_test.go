@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatch(t *testing.T) {
+	d := Data{}
+	pending := d.Dispatch(map[string]bool{"syr": true, "archive": false})
+	assert.Equal(t, []string{"archive", "syr"}, pending)
+	assert.Equal(t, Delivery{Required: true, State: StateComplete}, d.Deliveries["syr"])
+	assert.False(t, d.Delivered())
+	assert.False(t, d.Settled())
+	// failed delivery is repeated, forwarded one isn't
+	assert.Nil(t, d.Deliver("syr", StateForwarded, 1, "", ""))
+	assert.Nil(t, d.Deliver("archive", StateFailed, 3, "disk is full", ""))
+	pending = d.Dispatch(map[string]bool{"syr": true, "archive": false})
+	assert.Equal(t, []string{"archive"}, pending)
+	assert.Equal(t, StateComplete, d.Deliveries["archive"].State)
+	assert.Equal(t, 3, d.Deliveries["archive"].Attempts)
+}
+
+func TestDeliverValid(t *testing.T) {
+	d := Data{}
+	d.Dispatch(map[string]bool{"syr": true, "dc2": true, "archive": false})
+	assert.Nil(t, d.Deliver("syr", StateForwarded, 1, "", ""))
+	assert.Nil(t, d.Deliver("archive", StateComplete, 1, "disk is full", ""))
+	assert.False(t, d.Delivered())
+	assert.Nil(t, d.Deliver("dc2", StateForwarded, 2, "", ""))
+	assert.True(t, d.Delivered())
+	assert.False(t, d.Undelivered())
+	// optional sink is still forwarded
+	assert.False(t, d.Settled())
+	assert.Nil(t, d.Deliver("archive", StateFailed, 3, "disk is full", ""))
+	assert.True(t, d.Settled())
+	assert.True(t, d.Delivered())
+	assert.Equal(t, Delivery{false, StateFailed, 3, "disk is full", "", ""}, d.Deliveries["archive"])
+}
+
+func TestDeliverRemoteID(t *testing.T) {
+	d := Data{}
+	d.Dispatch(map[string]bool{"syr": true, "dc2": true, "archive": false})
+	// optional sink doesn't define id of upload
+	assert.Nil(t, d.Deliver("archive", StateForwarded, 1, "", "archive-1"))
+	assert.Empty(t, d.RemoteID)
+	assert.Nil(t, d.Deliver("syr", StateFailed, 1, "status code = 500", "ignored"))
+	assert.Empty(t, d.Deliveries["syr"].RemoteID)
+	assert.Nil(t, d.Deliver("syr", StateForwarded, 2, "", "SYR-42"))
+	assert.Nil(t, d.Deliver("dc2", StateForwarded, 1, "", "DC2-7"))
+	assert.Equal(t, "SYR-42", d.RemoteID)
+	assert.Equal(t, "DC2-7", d.Deliveries["dc2"].RemoteID)
+	assert.Equal(t, "archive-1", d.Deliveries["archive"].RemoteID)
+	assert.NotEmpty(t, d.Deliveries["syr"].Timestamp)
+}
+
+func TestDeliverUndispatched(t *testing.T) {
+	d := Data{}
+	assert.False(t, d.Delivered())
+	assert.Nil(t, d.Deliver("syr", StateFailed, 5, "status code = 400", ""))
+	assert.True(t, d.Deliveries["syr"].Required)
+	assert.True(t, d.Undelivered())
+	assert.Nil(t, d.Deliver("syr", StateForwarded, 6, "", ""))
+	assert.True(t, d.Delivered())
+}
+
+func TestDeliverInvalid(t *testing.T) {
+	d := Data{}
+	assert.Error(t, d.Deliver("syr", StateExpired, 1, "", ""))
+	assert.Error(t, d.Deliver("", StateForwarded, 1, "", ""))
+	assert.Empty(t, d.Deliveries)
+}
@@ -0,0 +1,21 @@
+package domain
+
+import "time"
+
+// Query - conditions to find Data in DataRepository, empty field matches
+// any Data. From and To bound LogCollectionTimestamp, Data is sorted by
+// LogCollectionTimestamp. Cursor is returned by previous Query to read the
+// next page. Attributes match Data with all of them equal.
+type Query struct {
+	SerialNumber string
+	Hostname     string
+	SystemType   string
+	State        State
+	RemoteID     string
+	Attributes   map[string]string
+	From         time.Time
+	To           time.Time
+	Descending   bool
+	Cursor       string
+	Limit        int
+}
@@ -0,0 +1,37 @@
+package domain
+
+import "strings"
+
+// Route - rule to choose destination of upload by metadata, empty
+// condition matches any value
+type Route struct {
+	Name         string
+	SystemType   string
+	Service      string
+	LogLevel     string
+	Originator   string
+	SerialPrefix string
+}
+
+// Match - test Data on all conditions of Route
+func (route Route) Match(data Data) bool {
+	return matchValue(route.SystemType, data.SystemType) &&
+		matchValue(route.Service, data.Service) &&
+		matchValue(route.LogLevel, data.LogLevel) &&
+		matchValue(route.Originator, data.Originator) &&
+		strings.HasPrefix(data.SerialNumber, route.SerialPrefix)
+}
+
+// MatchRoute - return name of the first route which matches Data
+func MatchRoute(routes []Route, data Data) (string, bool) {
+	for _, route := range routes {
+		if route.Match(data) {
+			return route.Name, true
+		}
+	}
+	return "", false
+}
+
+func matchValue(condition string, value string) bool {
+	return condition == "" || condition == value
+}
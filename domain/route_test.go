@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteMatch(t *testing.T) {
+	d := Data{SerialNumber: "YD0123456789", SystemType: "storage", Service: "logs", LogLevel: "debug", Originator: "bmc"}
+	assert.True(t, Route{Name: "any"}.Match(d))
+	assert.True(t, Route{SystemType: "storage", SerialPrefix: "YD"}.Match(d))
+	assert.True(t, Route{Service: "logs", LogLevel: "debug", Originator: "bmc"}.Match(d))
+	assert.False(t, Route{SystemType: "server"}.Match(d))
+	assert.False(t, Route{SystemType: "storage", SerialPrefix: "XX"}.Match(d))
+	assert.False(t, Route{Originator: "host"}.Match(d))
+}
+
+func TestMatchRoute(t *testing.T) {
+	routes := []Route{
+		{Name: "storage", SystemType: "storage"},
+		{Name: "yadro", SerialPrefix: "YD"},
+	}
+	name, ok := MatchRoute(routes, Data{SerialNumber: "YD01", SystemType: "storage"})
+	assert.True(t, ok)
+	assert.Equal(t, "storage", name)
+	name, ok = MatchRoute(routes, Data{SerialNumber: "YD01", SystemType: "server"})
+	assert.True(t, ok)
+	assert.Equal(t, "yadro", name)
+	_, ok = MatchRoute(routes, Data{SerialNumber: "XX01"})
+	assert.False(t, ok)
+}
@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// State - stage of lifecycle of upload
+type State string
+
+const (
+	// StateCreated - upload is in progress
+	StateCreated State = "created"
+	// StateComplete - upload is finished, but isn't forwarded yet
+	StateComplete State = "complete"
+	// StateForwarded - upload is delivered to SYR
+	StateForwarded State = "forwarded"
+	// StateFailed - upload isn't delivered to SYR after all attempts
+	StateFailed State = "failed"
+	// StateExpired - file of upload is missing in filestore, it is found by
+	// reconcile
+	StateExpired State = "expired"
+	// StateCorrupted - checksum of uploaded file doesn't match metadata
+	StateCorrupted State = "corrupted"
+)
+
+// transitions - allowed changes of state, failed upload may be complete
+// again to repeat forwarding
+var transitions = map[State][]State{
+	"":             {StateCreated},
+	StateCreated:   {StateComplete, StateFailed, StateExpired},
+	StateComplete:  {StateForwarded, StateFailed, StateExpired, StateCorrupted},
+	StateFailed:    {StateComplete, StateExpired},
+	StateForwarded: {StateExpired},
+	StateExpired:   {},
+	StateCorrupted: {StateExpired},
+}
+
+// Transition - record of change of state
+type Transition struct {
+	State     State
+	Timestamp string
+	Reason    string
+}
+
+// Valid - test State on existence
+func (state State) Valid() bool {
+	_, ok := transitions[state]
+	return ok && state != ""
+}
+
+// CanTransit - test that state may be changed to the next one
+func (state State) CanTransit(next State) bool {
+	for _, s := range transitions[state] {
+		if s == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Transit - change state of Data and save transition in history
+func (data *Data) Transit(next State, reason string) error {
+	if !next.Valid() {
+		return errors.Errorf("[data] [transit] unknown state %q", next)
+	}
+	if !data.State.CanTransit(next) {
+		return errors.Errorf("[data] [transit] %q can't be changed to %q", data.State, next)
+	}
+	data.State = next
+	data.History = append(data.History, Transition{
+		State:     next,
+		Timestamp: time.Now().Format(time.UnixDate),
+		Reason:    reason,
+	})
+	return nil
+}
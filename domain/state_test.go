@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateValid(t *testing.T) {
+	assert.True(t, StateCreated.Valid())
+	assert.True(t, StateExpired.Valid())
+	assert.False(t, State("").Valid())
+	assert.False(t, State("unknown").Valid())
+}
+
+func TestTransitValid(t *testing.T) {
+	d := Data{}
+	assert.Nil(t, d.Transit(StateCreated, ""))
+	assert.Nil(t, d.Transit(StateComplete, ""))
+	assert.Nil(t, d.Transit(StateFailed, "status code = 400"))
+	assert.Nil(t, d.Transit(StateComplete, "retry"))
+	assert.Nil(t, d.Transit(StateForwarded, ""))
+	assert.Equal(t, StateForwarded, d.State)
+	assert.Len(t, d.History, 5)
+	assert.Equal(t, StateFailed, d.History[2].State)
+	assert.Equal(t, "status code = 400", d.History[2].Reason)
+	assert.NotEmpty(t, d.History[2].Timestamp)
+}
+
+func TestTransitInvalid(t *testing.T) {
+	d := Data{}
+	assert.Error(t, d.Transit(StateComplete, ""))
+	assert.Nil(t, d.Transit(StateCreated, ""))
+	assert.Error(t, d.Transit(StateForwarded, ""))
+	assert.Error(t, d.Transit(State("unknown"), ""))
+	assert.Equal(t, StateCreated, d.State)
+	assert.Len(t, d.History, 1)
+	assert.Nil(t, d.Transit(StateExpired, ""))
+	assert.Error(t, d.Transit(StateComplete, ""))
+}
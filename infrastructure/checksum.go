@@ -0,0 +1,25 @@
+package infrastructure
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+
+	"github.com/pkg/errors"
+)
+
+// hashes - supported algorithms of checksum by name
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	h, ok := hashes[algorithm]
+	if !ok {
+		return nil, errors.Errorf("[checksum] unsupported algorithm %q", algorithm)
+	}
+	return h(), nil
+}
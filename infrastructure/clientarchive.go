@@ -0,0 +1,55 @@
+package infrastructure
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// usesArchive - return true if job is copied to local directory instead of
+// forwarding, url of destination has scheme file
+func usesArchive(data *data) bool {
+	u, err := url.Parse(data.urlpath)
+	return err == nil && u.Scheme == "file"
+}
+
+// archive - copy file of upload to directory from url as <id>-<filename>,
+// file gets its name when it is written entirely. Copied file is reported
+// as 201 Created like multipart upload
+func archive(data *data) (*http.Response, error) {
+	u, err := url.Parse(data.urlpath)
+	if err != nil {
+		return nil, errors.Wrap(err, "[client] [archive]")
+	}
+	dir := filepath.FromSlash(u.Path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "[client] [archive]")
+	}
+	in, err := os.Open(data.filepath)
+	if err != nil {
+		return nil, errors.Wrap(err, "[client] [archive]")
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(dir, "."+data.id+"-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "[client] [archive]")
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return nil, errors.Wrap(err, "[client] [archive]")
+	}
+	if err := out.Close(); err != nil {
+		return nil, errors.Wrap(err, "[client] [archive]")
+	}
+	target := filepath.Join(dir, data.id+"-"+filepath.Base(data.filename))
+	if err := os.Rename(out.Name(), target); err != nil {
+		return nil, errors.Wrap(err, "[client] [archive]")
+	}
+	return &http.Response{StatusCode: http.StatusCreated, Status: "201 Created", Body: http.NoBody}, nil
+}
@@ -2,10 +2,12 @@ package infrastructure
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -23,13 +25,92 @@ type User struct {
 	LdapGroups   []string `json:"ldap_groups" bson:"ldap_groups"`
 }
 
+// refreshBefore - token is refreshed in background before it expires
+const refreshBefore = time.Minute
+
+// tokenCache - token which is safe for concurrent use. Token is requested
+// once for simultaneous callers and is refreshed in advance if its expiry is known
+type tokenCache struct {
+	fetch   func(ctx context.Context) (string, time.Time, error)
+	timeout time.Duration
+	mu      sync.Mutex
+	_token  string
+	expires time.Time
+	pending *authCall
+}
+
+// authCall - request of token shared by simultaneous callers of authorize
+type authCall struct {
+	done chan struct{}
+	err  error
+}
+
+// authorize - request new token, caller waits for request which is
+// in progress already instead of starting another one
+func (cache *tokenCache) authorize(ctx context.Context) error {
+	cache.mu.Lock()
+	call := cache.pending
+	if call == nil {
+		call = &authCall{done: make(chan struct{})}
+		cache.pending = call
+		// request isn't bound to ctx of the first caller, others wait for it too
+		go cache.refresh(call)
+	}
+	cache.mu.Unlock()
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "[client] [authorize]")
+	}
+}
+
+// refresh - request token and share result with callers of authorize
+func (cache *tokenCache) refresh(call *authCall) {
+	ctx, cancel := context.WithTimeout(context.Background(), cache.timeout)
+	defer cancel()
+	token, expires, err := cache.fetch(ctx)
+	cache.mu.Lock()
+	if err == nil {
+		cache._token = token
+		cache.expires = expires
+	}
+	cache.pending = nil
+	cache.mu.Unlock()
+	call.err = err
+	close(call.done)
+}
+
+// token - return current token, it is empty if token is expired.
+// Token which expires soon is refreshed in background
+func (cache *tokenCache) token() string {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.expires.IsZero() {
+		return cache._token
+	}
+	now := time.Now()
+	if now.After(cache.expires.Add(-refreshBefore)) && cache.pending == nil {
+		call := &authCall{done: make(chan struct{})}
+		cache.pending = call
+		go cache.refresh(call)
+	}
+	if !now.Before(cache.expires) {
+		return ""
+	}
+	return cache._token
+}
+
+// SYRAuth - authorization on SYR by login and password, token is read
+// from field of response. It is safe for concurrent use, expiry of token
+// is known from JWT claim "exp"
 type SYRAuth struct {
+	tokenCache
 	urlauth     string
 	tokenfield  string
 	tokenheader string
 	login       string
 	password    string
-	_token      string
 	_client     *http.Client
 }
 
@@ -48,26 +129,28 @@ func NewSYRAuth(
 		password == "" {
 		return nil, errors.New("[clientauth] [new] bad argument")
 	}
-	return &SYRAuth{
-		urlauth,
-		tokenfield,
-		tokenheader,
-		login,
-		password,
-		"",
-		&http.Client{Timeout: time.Second * 30},
-	}, nil
+	auth := &SYRAuth{
+		urlauth:     urlauth,
+		tokenfield:  tokenfield,
+		tokenheader: tokenheader,
+		login:       login,
+		password:    password,
+		_client:     &http.Client{Timeout: time.Second * 30},
+	}
+	auth.fetch = auth.signin
+	auth.timeout = auth._client.Timeout
+	return auth, nil
 }
 
-func (client *SYRAuth) authorize(ctx context.Context) error {
-
+// signin - request token from SYR, expiry is zero if it isn't known
+func (client *SYRAuth) signin(ctx context.Context) (string, time.Time, error) {
 	user := User{
 		Login:    client.login,
 		Password: client.password,
 	}
 	body, err := json.Marshal(user)
 	if err != nil {
-		return errors.Wrap(err, "[client] [authorize] user bad json format")
+		return "", time.Time{}, errors.Wrap(err, "[client] [authorize] user bad json format")
 	}
 	url := client.urlauth
 	payload := strings.NewReader(string(body))
@@ -76,31 +159,46 @@ func (client *SYRAuth) authorize(ctx context.Context) error {
 	req.Header.Add("cache-control", "no-cache")
 	res, err := client._client.Do(req.WithContext(ctx))
 	if err != nil {
-		return errors.Wrap(err, "[client] [authorize] request")
+		return "", time.Time{}, errors.Wrap(err, "[client] [authorize] request")
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return errors.Wrapf(err, "[client] [authorize] [syr] status code = %s ", res.Status)
+		return "", time.Time{}, errors.Errorf("[client] [authorize] [syr] status code = %s", res.Status)
 	}
 	body, err = ioutil.ReadAll(res.Body)
 	if err != nil {
-		return errors.Wrap(err, "[client] [authorize] read res.Body")
+		return "", time.Time{}, errors.Wrap(err, "[client] [authorize] read res.Body")
 	}
 	var tokenmap map[string]string
 	if err := json.Unmarshal(body, &tokenmap); err != nil {
-		return errors.Wrapf(err, "[client] [authorize] unmarshal res.Body: %s", string(body))
+		return "", time.Time{}, errors.Wrapf(err, "[client] [authorize] unmarshal res.Body: %s", string(body))
 	}
 	token, ok := tokenmap[client.tokenfield]
-	if !ok {
-		return errors.New("[client] [authorize] bad token")
+	if !ok || token == "" {
+		return "", time.Time{}, errors.New("[client] [authorize] bad token")
 	}
-	client._token = client.tokenheader + token
-	return nil
+	return client.tokenheader + token, expiry(token), nil
 }
 
-func (client *SYRAuth) token() string {
-	return client._token
+// expiry - return time of claim "exp" if token is JWT, otherwise zero time
+func expiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(claims.Exp), 0)
 }
+
 func (client *SYRAuth) client() *http.Client {
 	return client._client
 }
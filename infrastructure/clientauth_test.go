@@ -2,10 +2,14 @@ package infrastructure
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -101,3 +105,79 @@ func TestAuthorize(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, tokenheader+token, a.token())
 }
+
+func TestAuthorizeInvalidStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+	a, _ := NewSYRAuth(ts.URL, "message", "yadro", "test", "test")
+	err := a.authorize(context.Background())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "403")
+	assert.Equal(t, "", a.token())
+}
+
+func TestAuthorizeSingleFlight(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, "{\"message\": \"0123456789\"}\n")
+	}))
+	defer ts.Close()
+	a, _ := NewSYRAuth(ts.URL, "message", "yadro", "test", "test")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, a.authorize(context.Background()))
+			assert.Equal(t, "yadro0123456789", a.token())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestAuthorizeExpiry(t *testing.T) {
+	jwt := func(exp time.Time) string {
+		claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("{\"exp\": %d}", exp.Unix())))
+		return "eyJhbGciOiJIUzI1NiJ9." + claims + ".c2lnbmF0dXJl"
+	}
+	run := func(tokens ...string) (*SYRAuth, *int32, func()) {
+		var calls int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&calls, 1)
+			fmt.Fprintf(w, "{\"message\": \"%s\"}\n", tokens[int(n-1)%len(tokens)])
+		}))
+		a, _ := NewSYRAuth(ts.URL, "message", "yadro ", "test", "test")
+		return a, &calls, ts.Close
+	}
+	t.Run("valid expiry", func(t *testing.T) {
+		exp := time.Now().Add(time.Hour)
+		assert.Equal(t, exp.Unix(), expiry(jwt(exp)).Unix())
+		assert.True(t, expiry("0123456789").IsZero())
+		assert.True(t, expiry("a.bad.c").IsZero())
+	})
+	t.Run("valid proactive refresh", func(t *testing.T) {
+		soon, later := jwt(time.Now().Add(30*time.Second)), jwt(time.Now().Add(time.Hour))
+		a, calls, stop := run(soon, later)
+		defer stop()
+		assert.Nil(t, a.authorize(context.Background()))
+		// token is still valid, but it is refreshed in background
+		assert.Equal(t, "yadro "+soon, a.token())
+		for i := 0; i < 100 && a.token() != "yadro "+later; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.Equal(t, "yadro "+later, a.token())
+		assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+	})
+	t.Run("invalid expired token", func(t *testing.T) {
+		expired := jwt(time.Now().Add(-time.Second))
+		a, _, stop := run(expired)
+		defer stop()
+		assert.Nil(t, a.authorize(context.Background()))
+		assert.Equal(t, "", a.token())
+	})
+}
@@ -0,0 +1,255 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Strategies of authorization on SYR
+const (
+	// AuthLogin - token is requested by login and password, see SYRAuth
+	AuthLogin = "login"
+	// AuthBearer - static token is sent in header "Authorization: Bearer"
+	AuthBearer = "bearer"
+	// AuthBasic - login and password are sent by HTTP basic authentication
+	AuthBasic = "basic"
+	// AuthOAuth2 - token is requested by OAuth2 client credentials grant
+	AuthOAuth2 = "oauth2"
+	// AuthMTLS - client is authorized by its TLS certificate
+	AuthMTLS = "mtls"
+)
+
+const authTimeout = time.Second * 30
+
+// AuthConfig - settings of authorization on SYR, strategy chooses
+// which of them are used
+type AuthConfig struct {
+	Strategy string
+	// login
+	URLAuth     string
+	TokenField  string
+	TokenHeader string
+	// login and basic
+	Login    string
+	Password string
+	// bearer
+	Token string
+	// oauth2
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// mtls
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// NewAuthStrategy - create authorization on SYR by strategy from config
+func NewAuthStrategy(cfg AuthConfig) (authHandler, error) {
+	// error is checked in each case, so failed constructor doesn't return
+	// interface with nil pointer
+	switch cfg.Strategy {
+	case AuthLogin, "":
+		auth, err := NewSYRAuth(cfg.URLAuth, cfg.TokenField, cfg.TokenHeader, cfg.Login, cfg.Password)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	case AuthBearer:
+		auth, err := NewBearerAuth(cfg.Token)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	case AuthBasic:
+		auth, err := NewBasicAuth(cfg.Login, cfg.Password)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	case AuthOAuth2:
+		auth, err := NewOAuth2Auth(cfg.TokenURL, cfg.ClientID, cfg.ClientSecret, cfg.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	case AuthMTLS:
+		auth, err := NewTLSAuth(cfg.CertFile, cfg.KeyFile, cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	}
+	return nil, errors.Errorf("[clientauth] [new] unknown strategy %q", cfg.Strategy)
+}
+
+// StaticAuth - authorization by header which doesn't change
+type StaticAuth struct {
+	header  string
+	_client *http.Client
+}
+
+// NewBearerAuth - create authorization by static bearer token
+func NewBearerAuth(token string) (*StaticAuth, error) {
+	if token == "" {
+		return nil, errors.New("[clientauth] [new bearer] bad argument")
+	}
+	return &StaticAuth{"Bearer " + token, &http.Client{Timeout: authTimeout}}, nil
+}
+
+// NewBasicAuth - create HTTP basic authorization
+func NewBasicAuth(login string, password string) (*StaticAuth, error) {
+	if login == "" || password == "" {
+		return nil, errors.New("[clientauth] [new basic] bad argument")
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(login + ":" + password))
+	return &StaticAuth{"Basic " + credentials, &http.Client{Timeout: authTimeout}}, nil
+}
+
+// authorize - nothing to request, header is the same
+func (auth *StaticAuth) authorize(ctx context.Context) error {
+	return nil
+}
+
+func (auth *StaticAuth) token() string {
+	return auth.header
+}
+
+func (auth *StaticAuth) client() *http.Client {
+	return auth._client
+}
+
+// OAuth2Auth - authorization by OAuth2 client credentials grant,
+// access token is cached until expires_in of response
+type OAuth2Auth struct {
+	tokenCache
+	tokenurl     string
+	clientid     string
+	clientsecret string
+	scopes       []string
+	_client      *http.Client
+}
+
+// NewOAuth2Auth - create authorization by OAuth2 client credentials
+func NewOAuth2Auth(tokenurl string, clientid string, clientsecret string, scopes []string) (*OAuth2Auth, error) {
+	if tokenurl == "" || clientid == "" || clientsecret == "" {
+		return nil, errors.New("[clientauth] [new oauth2] bad argument")
+	}
+	auth := &OAuth2Auth{
+		tokenurl:     tokenurl,
+		clientid:     clientid,
+		clientsecret: clientsecret,
+		scopes:       scopes,
+		_client:      &http.Client{Timeout: authTimeout},
+	}
+	auth.fetch = auth.grant
+	auth.timeout = authTimeout
+	return auth, nil
+}
+
+// grant - request access token from token endpoint
+func (auth *OAuth2Auth) grant(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(auth.scopes) > 0 {
+		form.Set("scope", strings.Join(auth.scopes, " "))
+	}
+	req, err := http.NewRequest(http.MethodPost, auth.tokenurl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "[client] [oauth2] request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(auth.clientid), url.QueryEscape(auth.clientsecret))
+	res, err := auth._client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "[client] [oauth2] request")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", time.Time{}, errors.Errorf("[client] [oauth2] status code = %s", res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "[client] [oauth2] read res.Body")
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", time.Time{}, errors.Wrapf(err, "[client] [oauth2] unmarshal res.Body: %s", string(body))
+	}
+	if token.AccessToken == "" {
+		return "", time.Time{}, errors.New("[client] [oauth2] bad token")
+	}
+	expires := expiry(token.AccessToken)
+	if token.ExpiresIn > 0 {
+		expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	// token type is case insensitive, but some servers accept "Bearer" only
+	if token.TokenType == "" || strings.EqualFold(token.TokenType, "bearer") {
+		token.TokenType = "Bearer"
+	}
+	return token.TokenType + " " + token.AccessToken, expires, nil
+}
+
+func (auth *OAuth2Auth) client() *http.Client {
+	return auth._client
+}
+
+// TLSAuth - authorization by client certificate, no header is sent
+type TLSAuth struct {
+	_client *http.Client
+}
+
+// NewTLSAuth - create authorization by client certificate and key in PEM files,
+// server certificate is verified by CA from cafile or by system CA if it's empty
+func NewTLSAuth(certfile string, keyfile string, cafile string) (*TLSAuth, error) {
+	if certfile == "" || keyfile == "" {
+		return nil, errors.New("[clientauth] [new mtls] bad argument")
+	}
+	cert, err := tls.LoadX509KeyPair(certfile, keyfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "[clientauth] [new mtls]")
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if cafile != "" {
+		pem, err := ioutil.ReadFile(cafile)
+		if err != nil {
+			return nil, errors.Wrap(err, "[clientauth] [new mtls]")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("[clientauth] [new mtls] no certificate in CA file")
+		}
+		config.RootCAs = pool
+	}
+	return &TLSAuth{&http.Client{
+		Timeout:   authTimeout,
+		Transport: &http.Transport{TLSClientConfig: config},
+	}}, nil
+}
+
+// authorize - nothing to request, client is authorized by handshake
+func (auth *TLSAuth) authorize(ctx context.Context) error {
+	return nil
+}
+
+func (auth *TLSAuth) token() string {
+	return ""
+}
+
+func (auth *TLSAuth) client() *http.Client {
+	return auth._client
+}
@@ -0,0 +1,177 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// authorized - request server which answers 201 if header Authorization
+// is expected and 401 otherwise
+func authorized(t *testing.T, auth authHandler, expected string) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != expected {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	assert.Nil(t, auth.authorize(context.Background()))
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, nil)
+	req.Header.Set("Authorization", auth.token())
+	res, err := auth.client().Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	res.Body.Close()
+}
+
+func TestNewAuthStrategy(t *testing.T) {
+	t.Run("valid strategies", func(t *testing.T) {
+		cases := map[string]AuthConfig{
+			AuthLogin:  {URLAuth: "http://syr", TokenField: "message", TokenHeader: "yadro", Login: "test", Password: "test"},
+			AuthBearer: {Token: "0123456789"},
+			AuthBasic:  {Login: "test", Password: "test"},
+			AuthOAuth2: {TokenURL: "http://syr/token", ClientID: "id", ClientSecret: "secret"},
+		}
+		for strategy, cfg := range cases {
+			cfg.Strategy = strategy
+			auth, err := NewAuthStrategy(cfg)
+			assert.Nil(t, err, strategy)
+			assert.NotNil(t, auth, strategy)
+		}
+	})
+	t.Run("invalid strategy", func(t *testing.T) {
+		auth, err := NewAuthStrategy(AuthConfig{Strategy: "kerberos"})
+		assert.Nil(t, auth)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid arguments", func(t *testing.T) {
+		for _, strategy := range []string{AuthLogin, AuthBearer, AuthBasic, AuthOAuth2, AuthMTLS} {
+			auth, err := NewAuthStrategy(AuthConfig{Strategy: strategy})
+			assert.True(t, auth == nil, strategy)
+			assert.NotNil(t, err, strategy)
+		}
+	})
+}
+
+func TestStaticAuth(t *testing.T) {
+	t.Run("valid bearer", func(t *testing.T) {
+		auth, err := NewBearerAuth("0123456789")
+		assert.Nil(t, err)
+		authorized(t, auth, "Bearer 0123456789")
+	})
+	t.Run("valid basic", func(t *testing.T) {
+		auth, err := NewBasicAuth("test", "secret")
+		assert.Nil(t, err)
+		authorized(t, auth, "Basic dGVzdDpzZWNyZXQ=")
+	})
+}
+
+func TestOAuth2Auth(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "id" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "upload read", r.PostForm.Get("scope"))
+		fmt.Fprint(w, `{"access_token": "0123456789", "token_type": "bearer", "expires_in": 3600}`)
+	}))
+	defer ts.Close()
+	t.Run("valid client credentials", func(t *testing.T) {
+		auth, err := NewOAuth2Auth(ts.URL, "id", "secret", []string{"upload", "read"})
+		assert.Nil(t, err)
+		authorized(t, auth, "Bearer 0123456789")
+		assert.WithinDuration(t, time.Now().Add(time.Hour), auth.expires, time.Minute)
+		assert.Equal(t, 1, calls)
+	})
+	t.Run("invalid client secret", func(t *testing.T) {
+		auth, _ := NewOAuth2Auth(ts.URL, "id", "wrong", nil)
+		err := auth.authorize(context.Background())
+		assert.NotNil(t, err)
+		assert.Equal(t, "", auth.token())
+	})
+}
+
+// writeCert - create self-signed certificate, write it and its key to dir
+func writeCert(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ch-server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, _ := x509.ParseCertificate(der)
+	b, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	certfile, keyfile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	assert.Nil(t, ioutil.WriteFile(certfile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyfile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600))
+	return certfile, keyfile, cert
+}
+
+func TestTLSAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-mtls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certfile, keyfile, cert := writeCert(t, dir)
+	clients := x509.NewCertPool()
+	clients.AddCert(cert)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clients}
+	ts.StartTLS()
+	defer ts.Close()
+	cafile := filepath.Join(dir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(cafile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+
+	t.Run("valid client certificate", func(t *testing.T) {
+		auth, err := NewTLSAuth(certfile, keyfile, cafile)
+		assert.Nil(t, err)
+		assert.Nil(t, auth.authorize(context.Background()))
+		assert.Equal(t, "", auth.token())
+		res, err := auth.client().Get(ts.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+		res.Body.Close()
+	})
+	t.Run("invalid without client certificate", func(t *testing.T) {
+		auth, _ := NewTLSAuth(certfile, keyfile, cafile)
+		auth._client.Transport.(*http.Transport).TLSClientConfig.Certificates = nil
+		_, err := auth.client().Get(ts.URL)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid CA file", func(t *testing.T) {
+		auth, err := NewTLSAuth(certfile, keyfile, keyfile)
+		assert.Nil(t, auth)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,203 @@
+package infrastructure
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// States of Breaker
+const (
+	BreakerClosed = "closed"
+	BreakerOpen   = "open"
+)
+
+// Breaker - circuit breaker of SYR. It is opened after threshold of
+// consecutive failures of SYR, then dispatch of uploads is paused and SYR
+// is probed every interval by health url or by url of SYR itself if health
+// url is empty. Breaker is closed by successful probe or upload
+type Breaker struct {
+	threshold int
+	interval  time.Duration
+	healthurl string
+	mu        sync.Mutex
+	failures  int
+	opened    time.Time
+	trips     int
+	probes    int
+	// resume - closed when breaker is closed, nil while it is closed
+	resume chan struct{}
+}
+
+// NewBreaker - create new instance of Breaker for SYRHandler
+func NewBreaker(threshold int, interval time.Duration, healthurl string) (*Breaker, error) {
+	if threshold < 1 || interval <= 0 {
+		return nil, errors.New("[clientbreaker] [new] bad argument")
+	}
+	return &Breaker{threshold: threshold, interval: interval, healthurl: healthurl}, nil
+}
+
+// State - return closed or open
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.resume != nil {
+		return BreakerOpen
+	}
+	return BreakerClosed
+}
+
+// Failures - return number of consecutive failures of SYR
+func (b *Breaker) Failures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures
+}
+
+// Trips - return how many times breaker is opened
+func (b *Breaker) Trips() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.trips
+}
+
+// Probes - return how many times SYR is probed while breaker is open
+func (b *Breaker) Probes() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.probes
+}
+
+// Since - return time when breaker is opened, zero while it is closed
+func (b *Breaker) Since() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.resume == nil {
+		return time.Time{}
+	}
+	return b.opened
+}
+
+// allow - return true if uploads may be dispatched, nil breaker allows all
+func (b *Breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.resume == nil
+}
+
+// resumed - return channel which is closed when open breaker is closed,
+// it is nil and blocks forever while breaker is closed
+func (b *Breaker) resumed() <-chan struct{} {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.resume
+}
+
+// failure - count failure of SYR, return true if breaker is opened by it
+func (b *Breaker) failure() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.resume != nil || b.failures < b.threshold {
+		return false
+	}
+	b.resume = make(chan struct{})
+	b.opened = time.Now()
+	b.trips++
+	return true
+}
+
+// success - reset failures, return true if breaker is closed by it
+func (b *Breaker) success() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.resume == nil {
+		return false
+	}
+	close(b.resume)
+	b.resume = nil
+	return true
+}
+
+// watch - probe SYR while breaker is open until ctx is done
+func (client *SYRHandler) watch(ctx context.Context) {
+	b := client.breaker
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if b.allow() {
+			continue
+		}
+		b.mu.Lock()
+		b.probes++
+		b.mu.Unlock()
+		if err := client.probe(ctx); err != nil {
+			continue
+		}
+		if b.success() {
+			client.stderr.Printf("[client] [breaker]: sink = %s is closed, SYR is available\n", client.name)
+		}
+	}
+}
+
+// probe - request health url of SYR, any status below 500 means SYR is
+// available. Without health url url of SYR is requested by HEAD, its
+// status doesn't matter while it is below 500
+func (client *SYRHandler) probe(ctx context.Context) error {
+	method, url := http.MethodGet, client.breaker.healthurl
+	if url == "" {
+		method, url = http.MethodHead, client.cfg.url
+	}
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.auth.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return errors.Errorf("[client] [probe]: [syr] status code = %s", res.Status)
+	}
+	return nil
+}
+
+// outage - count result of request to SYR in breaker, request which is
+// interrupted by shutdown isn't counted
+func (client *SYRHandler) outage(ctx context.Context, failed bool) {
+	if ctx.Err() != nil {
+		return
+	}
+	if !failed {
+		if client.breaker.success() {
+			client.stderr.Printf("[client] [breaker]: sink = %s is closed, SYR is available\n", client.name)
+		}
+		return
+	}
+	if client.breaker.failure() {
+		client.stderr.Printf("[client] [breaker]: sink = %s is open after %d failures, dispatch is paused\n",
+			client.name, client.breaker.threshold)
+	}
+}
@@ -0,0 +1,163 @@
+package infrastructure
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Run("valid open and close", func(t *testing.T) {
+		b, err := NewBreaker(2, time.Second, "")
+		assert.Nil(t, err)
+		assert.False(t, b.failure())
+		// success resets consecutive failures
+		assert.False(t, b.success())
+		assert.Equal(t, 0, b.Failures())
+		assert.False(t, b.failure())
+		assert.True(t, b.failure())
+		assert.Equal(t, BreakerOpen, b.State())
+		assert.False(t, b.allow())
+		assert.False(t, b.Since().IsZero())
+		resumed := b.resumed()
+		// breaker is opened once
+		assert.False(t, b.failure())
+		assert.Equal(t, 1, b.Trips())
+		assert.True(t, b.success())
+		assert.Equal(t, BreakerClosed, b.State())
+		assert.True(t, b.allow())
+		assert.True(t, b.Since().IsZero())
+		select {
+		case <-resumed:
+		default:
+			assert.Fail(t, "breaker: dispatch isn't resumed")
+		}
+	})
+	t.Run("valid nil breaker", func(t *testing.T) {
+		var b *Breaker
+		assert.True(t, b.allow())
+		assert.False(t, b.failure())
+		assert.Nil(t, b.resumed())
+	})
+	t.Run("valid probe of url without health url", func(t *testing.T) {
+		var mu sync.Mutex
+		down := true
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, http.MethodHead, r.Method)
+			if down {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}))
+		defer ts.Close()
+		cfg, _ := NewSYRConfig(ts.URL, "/tmp", "attachment", "")
+		retry, _ := NewRetryPolicy(1, time.Millisecond, time.Millisecond, 0, nil)
+		auth := new(SYRAuthMock)
+		auth.On("client").Return(ts.Client())
+		client, _ := NewSYRHandler(cfg, retry, auth, new(QueueHandlerMock), new(QueueHandlerMock),
+			log.New(os.Stdout, "[test] ", log.LstdFlags))
+		b, _ := NewBreaker(1, time.Second, "")
+		assert.Nil(t, client.SetBreaker(b))
+		assert.Error(t, client.probe(context.Background()))
+		mu.Lock()
+		down = false
+		mu.Unlock()
+		assert.Nil(t, client.probe(context.Background()))
+		auth.AssertNotCalled(t, "authorize", mock.Anything)
+	})
+	t.Run("invalid argument", func(t *testing.T) {
+		_, err := NewBreaker(0, time.Second, "")
+		assert.Error(t, err)
+		_, err = NewBreaker(1, 0, "")
+		assert.Error(t, err)
+	})
+}
+
+func TestBreakerOutage(t *testing.T) {
+	id := "0123456789breaker"
+	dir, err := ioutil.TempDir("", "test-breaker")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, id), []byte("hello world"), 0644))
+	var mu sync.Mutex
+	down := true
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/health" {
+			if down {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		calls++
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	cfg, _ := NewSYRConfig(ts.URL, dir, "attachment", "")
+	retry, _ := NewRetryPolicy(10, time.Millisecond, time.Millisecond, 0, []int{http.StatusServiceUnavailable})
+	auth := new(SYRAuthMock)
+	auth.On("token").Return("yadro0123456789")
+	auth.On("client").Return(ts.Client())
+	outbox := new(QueueHandlerMock)
+	outbox.On("ReadAll").Return([][]byte{}, nil)
+	outbox.On("Push", id, mock.Anything).Return(nil)
+	outbox.On("Remove", id).Return(nil)
+	deadletter := new(QueueHandlerMock)
+	deadletter.On("Remove", id).Return(nil)
+	hooks := new(HooksClientHandlerMock)
+	hooks.On("Retried", id, DefaultSink, mock.Anything, mock.Anything).Return(nil)
+	hooks.On("Forwarded", id, DefaultSink, "").Return(true, nil)
+	client, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, log.New(os.Stdout, "[test] ", log.LstdFlags))
+	assert.Nil(t, client.SetHooksHandler(hooks))
+	breaker, _ := NewBreaker(2, 20*time.Millisecond, ts.URL+"/health")
+	assert.Error(t, client.SetBreaker(nil))
+	assert.Nil(t, client.SetBreaker(breaker))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, client.Run(ctx, &multipartfile{}))
+	}()
+	assert.Nil(t, client.Send(id, "log.tar", "0123456789", "", nil))
+	assert.Eventually(t, func() bool { return breaker.State() == BreakerOpen }, time.Second, 5*time.Millisecond)
+	// upload isn't dispatched while SYR is down, but SYR is probed
+	mu.Lock()
+	paused := calls
+	mu.Unlock()
+	assert.Eventually(t, func() bool { return breaker.Probes() >= 2 }, time.Second, 5*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, paused, calls)
+	down = false
+	mu.Unlock()
+	select {
+	case d := <-client.GetChanTerm():
+		assert.Equal(t, id, d)
+	case <-time.After(time.Second):
+		assert.Fail(t, "breaker: upload isn't dispatched after recovery")
+	}
+	cancel()
+	wg.Wait()
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Equal(t, 1, breaker.Trips())
+	hooks.AssertCalled(t, "Forwarded", id, DefaultSink, "")
+}
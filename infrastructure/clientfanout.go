@@ -0,0 +1,187 @@
+package infrastructure
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fanoutHooksHandler - implemented in hooksHandler from hooks(interfaces)
+// to save sinks of upload in metadata
+type fanoutHooksHandler interface {
+	clientHooksHandler
+	Dispatch(id string, sinks map[string]bool) ([]string, error)
+}
+
+// sink - destination of uploads with its own outbox and retries
+type sink struct {
+	name     string
+	required bool
+	handler  *SYRHandler
+}
+
+// FanoutHandler - implements interface clientHandler from client(interfaces),
+// upload is forwarded to each sink independently and its file is deleted
+// after all required sinks have it
+type FanoutHandler struct {
+	sinks    []*sink
+	chanterm chan string
+	hooks    fanoutHooksHandler
+	stderr   logger
+}
+
+// NewFanoutHandler - create new instance of FanoutHandler for HTTPclient
+func NewFanoutHandler(errlog logger) (*FanoutHandler, error) {
+	if errlog == nil {
+		return nil, errors.New("[clientfanout] [new handler] bad argument")
+	}
+	return &FanoutHandler{chanterm: make(chan string, 1), stderr: errlog}, nil
+}
+
+// AddSink - forward uploads by handler, result is reported to hooks with
+// name of sink. It has to be called before Run
+func (fanout *FanoutHandler) AddSink(name string, required bool, handler *SYRHandler) error {
+	if name == "" || handler == nil {
+		return errors.New("[clientfanout] [add sink] bad argument")
+	}
+	for _, s := range fanout.sinks {
+		if s.name == name {
+			return errors.Errorf("[clientfanout] [add sink] sink %q is added already", name)
+		}
+	}
+	handler.name = name
+	// File is deleted by decision of hooks, see delivered
+	handler.chanterm = fanout.chanterm
+	if fanout.hooks != nil {
+		handler.hooks = fanout.hooks
+	}
+	fanout.sinks = append(fanout.sinks, &sink{name, required, handler})
+	return nil
+}
+
+// SetHooksHandler - set handler to save results of forwarding in metadata
+func (fanout *FanoutHandler) SetHooksHandler(hooks fanoutHooksHandler) error {
+	if hooks == nil {
+		return errors.New("[clientfanout] [set hooks] bad argument")
+	}
+	fanout.hooks = hooks
+	for _, s := range fanout.sinks {
+		s.handler.hooks = hooks
+	}
+	return nil
+}
+
+// EnableStream - forward uploads to sinks while they are arriving
+func (fanout *FanoutHandler) EnableStream(stall time.Duration) error {
+	for _, s := range fanout.sinks {
+		if err := s.handler.EnableStream(stall); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send - send file to sinks which don't have it yet, route is chosen by
+// each sink
+func (fanout *FanoutHandler) Send(id string, name string, system string, route string, meta map[string]string) error {
+	if len(fanout.sinks) == 0 {
+		return errors.New("[clientfanout] [send] no sinks")
+	}
+	targets := fanout.sinks
+	if fanout.hooks != nil {
+		required := make(map[string]bool, len(fanout.sinks))
+		for _, s := range fanout.sinks {
+			required[s.name] = s.required
+		}
+		pending, err := fanout.hooks.Dispatch(id, required)
+		if err != nil {
+			return errors.Wrap(err, "[clientfanout] [send]")
+		}
+		targets = fanout.pick(pending)
+	}
+	var failed []string
+	for _, s := range targets {
+		if err := s.handler.Send(id, name, system, route, meta); err != nil {
+			failed = append(failed, s.name+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("[clientfanout] [send]: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Stream - start forwarding of created upload to sinks
+func (fanout *FanoutHandler) Stream(id string, name string, system string, route string, meta map[string]string) error {
+	var failed []string
+	for _, s := range fanout.sinks {
+		if err := s.handler.Stream(id, name, system, route, meta); err != nil {
+			failed = append(failed, s.name+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("[clientfanout] [stream]: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Progress - wake streams of upload up
+func (fanout *FanoutHandler) Progress(id string) {
+	for _, s := range fanout.sinks {
+		s.handler.Progress(id)
+	}
+}
+
+// Abort - stop streams of terminated upload
+func (fanout *FanoutHandler) Abort(id string) {
+	for _, s := range fanout.sinks {
+		s.handler.Abort(id)
+	}
+}
+
+// Unstream - stop streams of upload with checksum, it is sent after it is complete
+func (fanout *FanoutHandler) Unstream(id string) {
+	for _, s := range fanout.sinks {
+		s.handler.Unstream(id)
+	}
+}
+
+// Run - run handlers of all sinks, Run returns when all of them are over
+func (fanout *FanoutHandler) Run(ctx context.Context, multi imultipartfile) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(fanout.sinks))
+	for _, s := range fanout.sinks {
+		wg.Add(1)
+		go func(s *sink) {
+			defer wg.Done()
+			if err := s.handler.Run(ctx, multi); err != nil {
+				errs <- errors.Wrapf(err, "[clientfanout] [run] sink %s", s.name)
+			}
+		}(s)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// GetChanTerm - return channel of id(string) to delete file with that id
+func (fanout *FanoutHandler) GetChanTerm() chan string {
+	return fanout.chanterm
+}
+
+// pick - return sinks by names
+func (fanout *FanoutHandler) pick(names []string) []*sink {
+	picked := make([]*sink, 0, len(names))
+	for _, s := range fanout.sinks {
+		for _, name := range names {
+			if s.name == name {
+				picked = append(picked, s)
+				break
+			}
+		}
+	}
+	return picked
+}
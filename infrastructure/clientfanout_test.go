@@ -0,0 +1,116 @@
+package infrastructure
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestFanout(t *testing.T) {
+	const body = "hello world, it is a dump of logs"
+	id := "0123456789fanout"
+	dir, err := ioutil.TempDir("", "test-fanout")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, id), []byte(body), 0644))
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Millisecond, 0, nil)
+	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	newSink := func(url string, httpclient *http.Client) *SYRHandler {
+		cfg, _ := NewSYRConfig(url, dir, "attachment", "")
+		auth := new(SYRAuthMock)
+		auth.On("token").Return("yadro0123456789")
+		auth.On("client").Return(httpclient)
+		outbox := new(QueueHandlerMock)
+		outbox.On("ReadAll").Return([][]byte{}, nil)
+		outbox.On("Push", id, mock.Anything).Return(nil)
+		outbox.On("Remove", id).Return(nil)
+		deadletter := new(QueueHandlerMock)
+		deadletter.On("Remove", id).Return(nil)
+		client, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, logerr)
+		return client
+	}
+	// run - send upload to SYR and archive, pending sinks are returned by hooks
+	run := func(pending []string) (*HooksClientHandlerMock, int, string) {
+		var mu sync.Mutex
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer ts.Close()
+		archivedir := filepath.Join(dir, "archive")
+		defer os.RemoveAll(archivedir)
+		fanout, err := NewFanoutHandler(logerr)
+		assert.Nil(t, err)
+		assert.Nil(t, fanout.AddSink("syr", true, newSink(ts.URL, ts.Client())))
+		assert.Nil(t, fanout.AddSink("archive", false, newSink("file://"+archivedir, nil)))
+		hooks := new(HooksClientHandlerMock)
+		hooks.On("Dispatch", id, map[string]bool{"syr": true, "archive": false}).Return(pending, nil)
+		forwarded := make(chan string, 2)
+		report := func(args mock.Arguments) { forwarded <- args.String(1) }
+		hooks.On("Forwarded", id, "syr", "").Return(false, nil).Run(report)
+		hooks.On("Forwarded", id, "archive", "").Return(true, nil).Run(report)
+		assert.Nil(t, fanout.SetHooksHandler(hooks))
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, fanout.Run(ctx, nil))
+		}()
+		assert.Nil(t, fanout.Send(id, "log.tar", "0123456789", "", nil))
+		select {
+		case d := <-fanout.GetChanTerm():
+			assert.Equal(t, id, d)
+		case <-time.After(time.Second):
+			assert.Fail(t, "fanout: empty channel, expect id")
+		}
+		for range pending {
+			select {
+			case <-forwarded:
+			case <-time.After(time.Second):
+				assert.Fail(t, "fanout: upload isn't forwarded to all sinks")
+			}
+		}
+		cancel()
+		wg.Wait()
+		archived, _ := ioutil.ReadFile(filepath.Join(archivedir, "0123456789", id+"-log.tar"))
+		mu.Lock()
+		defer mu.Unlock()
+		return hooks, calls, string(archived)
+	}
+
+	t.Run("valid all sinks", func(t *testing.T) {
+		hooks, calls, archived := run([]string{"archive", "syr"})
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, body, archived)
+		hooks.AssertCalled(t, "Forwarded", id, "syr", "")
+		hooks.AssertCalled(t, "Forwarded", id, "archive", "")
+	})
+	t.Run("valid only pending sink", func(t *testing.T) {
+		hooks, calls, archived := run([]string{"archive"})
+		assert.Equal(t, 0, calls)
+		assert.Equal(t, body, archived)
+		hooks.AssertNotCalled(t, "Forwarded", id, "syr", "")
+	})
+	t.Run("invalid sink", func(t *testing.T) {
+		fanout, _ := NewFanoutHandler(logerr)
+		assert.Error(t, fanout.Send(id, "log.tar", "0123456789", "", nil))
+		assert.Error(t, fanout.AddSink("", true, newSink("http://syr.com", nil)))
+		assert.Error(t, fanout.AddSink("syr", true, nil))
+		assert.Nil(t, fanout.AddSink("syr", true, newSink("http://syr.com", nil)))
+		assert.Error(t, fanout.AddSink("syr", false, newSink("http://syr2.com", nil)))
+	})
+}
@@ -2,6 +2,7 @@ package infrastructure
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -9,7 +10,11 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"b.yadro.com/sys/ch-server/usecases"
 	"github.com/pkg/errors"
 )
 
@@ -19,13 +24,88 @@ type authHandler interface {
 	client() *http.Client
 }
 
+// queueHandler - implemented in struct DbQueue from queue(interfaces)
+// to persist jobs of forwarding
+type queueHandler interface {
+	Push(id string, job []byte) error
+	Remove(id string) error
+	ReadAll() ([][]byte, error)
+}
+
+// clientHooksHandler - implemented in hooksHandler from hooks(interfaces)
+// to save results of forwarding to sink in metadata, file of upload is
+// deleted if Failed or Forwarded return true
+type clientHooksHandler interface {
+	Retried(id string, sink string, attempts int, reason string) error
+	Failed(id string, sink string, attempts int, reason string) (bool, error)
+	Forwarded(id string, sink string, remote string) (bool, error)
+}
+
+// DefaultSink - name of SYRHandler which isn't added to FanoutHandler
+const DefaultSink = "syr"
+
 type data struct {
 	id        string
-	token     string
+	serial    string
 	filename  string
 	urlpath   string
 	filepath  string
 	fieldform string
+	attempts  int
+	lasterr   string
+	// location - url of upload on upstream which speaks tus
+	location string
+	// route - name of route which has chosen destination
+	route string
+	// form - headers and fields of request rendered by template
+	form form
+	// reauthorized - previous attempt was answered 401 and is repeated
+	// with new token without counting it
+	reauthorized bool
+}
+
+// job - persisted form of data in the outbox
+type job struct {
+	ID        string            `json:"id"`
+	Serial    string            `json:"serial,omitempty"`
+	Filename  string            `json:"filename"`
+	URLPath   string            `json:"urlpath"`
+	Filepath  string            `json:"filepath"`
+	Fieldform string            `json:"fieldform"`
+	Attempts  int               `json:"attempts,omitempty"`
+	LastError string            `json:"last_error,omitempty"`
+	Location  string            `json:"location,omitempty"`
+	Route     string            `json:"route,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+func (d *data) marshal() ([]byte, error) {
+	return json.Marshal(job{d.id, d.serial, d.filename, d.urlpath, d.filepath, d.fieldform, d.attempts, d.lasterr, d.location, d.route,
+		d.form.headers, d.form.fields})
+}
+
+func unmarshalData(b []byte) (*data, error) {
+	j := job{}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, err
+	}
+	if j.ID == "" {
+		return nil, errors.New("job without id")
+	}
+	return &data{
+		id:        j.ID,
+		serial:    j.Serial,
+		filename:  j.Filename,
+		urlpath:   j.URLPath,
+		filepath:  j.Filepath,
+		fieldform: j.Fieldform,
+		attempts:  j.Attempts,
+		lasterr:   j.LastError,
+		location:  j.Location,
+		route:     j.Route,
+		form:      form{j.Headers, j.Fields},
+	}, nil
 }
 
 type SYRConfig struct {
@@ -33,6 +113,30 @@ type SYRConfig struct {
 	pathfile  string
 	fieldform string
 	fileext   string
+	// tus - destinations (host of url) which are forwarded by tus protocol
+	tus map[string]bool
+	// tuschunk - size of PATCH request of tus upload
+	tuschunk int64
+	// routes - destinations chosen by routing rules
+	routes map[string]route
+	// templates - requests built from metadata by route, "" is default
+	templates map[string]*Template
+	// remoteid - path of id of upload in response of SYR
+	remoteid []string
+	// attributes - attributes of upload are forwarded as fields of form
+	// with prefix
+	attributes bool
+	attrprefix string
+}
+
+// route - destination of uploads by name of route, empty fields are
+// taken from SYRConfig and SYRHandler
+type route struct {
+	url       string
+	fieldform string
+	// fileext - extension which is added to name of file on destination
+	fileext string
+	auth    authHandler
 }
 
 // NewSYRConfig - create new instance of SYR configuration
@@ -51,125 +155,629 @@ func NewSYRConfig(
 		url,
 		path,
 		fieldform,
-		fileext}, nil
+		fileext,
+		map[string]bool{},
+		DefaultTusChunk,
+		map[string]route{},
+		map[string]*Template{},
+		nil,
+		false,
+		""}, nil
+}
+
+// SetTemplate - build requests of route by template, template of empty
+// route is used by routes without their own one. Without template upload
+// is posted to url joined with serial number
+func (cfg *SYRConfig) SetTemplate(route string, t *Template) error {
+	if t == nil {
+		return errors.New("[clienthandler] [set template] bad argument")
+	}
+	cfg.templates[route] = t
+	return nil
+}
+
+// SetAttributes - forward attributes of upload, which are given in metadata
+// with prefix "Attributes.", as fields of multipart form or tus metadata.
+// Name of field is name of attribute with prefix, field of template isn't
+// replaced by attribute
+func (cfg *SYRConfig) SetAttributes(forward bool, prefix string) error {
+	cfg.attributes = forward
+	cfg.attrprefix = prefix
+	return nil
+}
+
+// AddRoute - forward uploads of route to url, empty fieldform and nil auth
+// are taken by default. Upload of unknown route is forwarded to url of config
+func (cfg *SYRConfig) AddRoute(name, url, fieldform, fileext string, auth authHandler) error {
+	if name == "" || url == "" {
+		return errors.New("[clienthandler] [add route] bad argument")
+	}
+	cfg.routes[name] = route{url, fieldform, fileext, auth}
+	return nil
+}
+
+// UseTus - forward uploads to destinations by tus protocol instead of
+// multipart form, destination is host of url
+func (cfg *SYRConfig) UseTus(hosts ...string) error {
+	for _, host := range hosts {
+		if host == "" {
+			return errors.New("[clienthandler] [use tus] bad argument")
+		}
+		cfg.tus[host] = true
+	}
+	return nil
 }
 
 // SYRHandler - implements interface clientHandler from client(interfaces)
 type SYRHandler struct {
-	cfg      *SYRConfig
-	chandata chan *data
-	chanterm chan string
-	stderr   logger
-	auth     authHandler
+	// name - sink which is reported to hooks
+	name       string
+	cfg        *SYRConfig
+	retry      *RetryPolicy
+	pool       *WorkerPool
+	chandata   chan *data
+	chanterm   chan string
+	stderr     logger
+	auth       authHandler
+	outbox     queueHandler
+	deadletter queueHandler
+	hooks      clientHooksHandler
+	mu         sync.Mutex
+	inflight   map[string]struct{}
+	// restored - the outbox is restored once by Run or by the first job
+	restored sync.Once
+	// streams - uploads which are forwarded while they are arriving
+	streams map[string]*stream
+	stall   time.Duration
+	ctx     context.Context
+	// breaker - pause dispatch of uploads while SYR is down
+	breaker *Breaker
 }
 
 // NewSYRHandler - create new instance of SYRHandler for HTTPclient
-func NewSYRHandler(cfg *SYRConfig, auth authHandler, errlog logger) (*SYRHandler, error) {
-	if cfg == nil || errlog == nil || auth == nil {
+func NewSYRHandler(
+	cfg *SYRConfig,
+	retry *RetryPolicy,
+	auth authHandler,
+	outbox queueHandler,
+	deadletter queueHandler,
+	errlog logger) (*SYRHandler, error) {
+
+	if cfg == nil ||
+		retry == nil ||
+		errlog == nil ||
+		auth == nil ||
+		outbox == nil ||
+		deadletter == nil {
 		return nil, errors.New("[clienthandler] [new handler] bad argument")
 	}
+	// Uploads are forwarded one at a time until pool is set
+	pool, _ := NewWorkerPool(1, 1, nil)
 	return &SYRHandler{
-		cfg,
-		make(chan *data, 10),
-		make(chan string, 1),
-		errlog,
-		auth,
+		name:       DefaultSink,
+		cfg:        cfg,
+		retry:      retry,
+		pool:       pool,
+		chandata:   make(chan *data, 10),
+		chanterm:   make(chan string, 1),
+		stderr:     errlog,
+		auth:       auth,
+		outbox:     outbox,
+		deadletter: deadletter,
+		inflight:   make(map[string]struct{}),
+		streams:    make(map[string]*stream),
 	}, nil
 }
 
-// Send - implement func to send file to SYR server
-func (client *SYRHandler) Send(id string, name string, system string) error {
+// SetBreaker - pause dispatch of uploads while SYR is down, it has to be
+// called before Run
+func (client *SYRHandler) SetBreaker(breaker *Breaker) error {
+	if breaker == nil {
+		return errors.New("[clienthandler] [set breaker] bad argument")
+	}
+	client.breaker = breaker
+	return nil
+}
 
+// SetHooksHandler - set handler to save results of forwarding in metadata
+func (client *SYRHandler) SetHooksHandler(hooks clientHooksHandler) error {
+	if hooks == nil {
+		return errors.New("[clienthandler] [set hooks] bad argument")
+	}
+	client.hooks = hooks
+	return nil
+}
+
+// SetWorkerPool - set pool of workers to forward uploads concurrently,
+// it has to be set before Run
+func (client *SYRHandler) SetWorkerPool(pool *WorkerPool) error {
+	if pool == nil {
+		return errors.New("[clienthandler] [set pool] bad argument")
+	}
+	client.pool = pool
+	return nil
+}
+
+// newData - create job to forward file of upload to destination of route,
+// request is built from metadata by template
+func (client *SYRHandler) newData(id string, name string, system string, routename string, meta map[string]string) (*data, error) {
 	var fieldform = client.cfg.fieldform
-	u, err := url.Parse(client.cfg.url)
+	var urlpath = client.cfg.url
+	r, routed := client.cfg.routes[routename]
+	if routed {
+		urlpath = r.url
+		if r.fieldform != "" {
+			fieldform = r.fieldform
+		}
+	}
+	u, err := url.Parse(urlpath)
 	if err != nil {
-		return errors.Wrap(err, "[client] [send]")
+		return nil, err
+	}
+	var extra form
+	if t := client.template(routename, routed); t == nil {
+		u.Path = path.Join(u.Path, system)
+	} else {
+		p, query, rendered, err := t.render(templateData(id, name, system, meta))
+		if err != nil {
+			return nil, err
+		}
+		u.Path = path.Join(u.Path, p)
+		values := u.Query()
+		for k, v := range query {
+			values[k] = v
+		}
+		u.RawQuery = values.Encode()
+		extra = rendered
+	}
+	if client.cfg.attributes {
+		extra.fields = attributeFields(extra.fields, client.cfg.attrprefix, meta)
 	}
-	u.Path = path.Join(u.Path, system)
 	pathfile := filepath.Join(client.cfg.pathfile, id+client.cfg.fileext)
 	if _, err := os.Stat(pathfile); os.IsNotExist(err) {
-		return errors.Wrap(err, "[client] [send]")
+		return nil, err
 	}
 	var namefile = name
 	if namefile == "" {
-		return errors.New("[client] [send] filename is empty")
+		return nil, errors.New("filename is empty")
+	}
+	if routed && !strings.HasSuffix(namefile, r.fileext) {
+		namefile += r.fileext
+	}
+	if !routed {
+		routename = ""
+	}
+	return &data{id: id, serial: system, filename: namefile, urlpath: u.String(), filepath: pathfile,
+		fieldform: fieldform, route: routename, form: extra}, nil
+}
+
+// template - return template of route, default one is used if route
+// doesn't have it
+func (client *SYRHandler) template(routename string, routed bool) *Template {
+	if t, ok := client.cfg.templates[routename]; ok && routed {
+		return t
+	}
+	return client.cfg.templates[""]
+}
+
+// templateData - metadata of upload for templates, id, name and serial
+// number are known without it
+func templateData(id string, name string, system string, meta map[string]string) map[string]string {
+	values := make(map[string]string, len(meta)+3)
+	for k, v := range meta {
+		values[k] = v
+	}
+	values["SessionID"] = id
+	values["FileName"] = name
+	values["SerialNumber"] = system
+	return values
+}
+
+// attributeFields - add attributes from metadata to fields with prefix,
+// fields which are set already are kept
+func attributeFields(fields map[string]string, prefix string, meta map[string]string) map[string]string {
+	for k, v := range meta {
+		if !strings.HasPrefix(k, usecases.AttributePrefix) {
+			continue
+		}
+		name := prefix + strings.TrimPrefix(k, usecases.AttributePrefix)
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		if _, ok := fields[name]; !ok {
+			fields[name] = v
+		}
+	}
+	return fields
+}
+
+// authorization - return authorization of route of job, default one is
+// used if route doesn't set it
+func (client *SYRHandler) authorization(data *data) authHandler {
+	if r, ok := client.cfg.routes[data.route]; ok && r.auth != nil {
+		return r.auth
+	}
+	return client.auth
+}
+
+// Send - implement func to send file to SYR server by route, metadata of
+// upload is used by templates
+func (client *SYRHandler) Send(id string, name string, system string, route string, meta map[string]string) error {
+	// Upload which is streamed already isn't sent again
+	if client.streamed(id) {
+		return nil
 	}
-	client.chandata <- &data{id, client.auth.token(), namefile, u.String(), pathfile, fieldform}
+	d, err := client.newData(id, name, system, route, meta)
+	if err != nil {
+		return errors.Wrap(err, "[client] [send]")
+	}
+	return client.enqueue(d)
+}
+
+// enqueue - save job in the outbox and queue it for workers
+func (client *SYRHandler) enqueue(d *data) error {
+	id := d.id
+	b, err := d.marshal()
+	if err != nil {
+		return errors.Wrap(err, "[client] [send]")
+	}
+	// Job of the outbox is restored before it is replaced, so upload which
+	// is sent again before Run, e.g. by reconcile, keeps its attempts
+	client.mu.Lock()
+	ctx := client.ctx
+	client.mu.Unlock()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	client.load(ctx)
+	// Upload which is queued already isn't sent twice
+	if !client.begin(id) {
+		return nil
+	}
+	// The job is saved before queuing, so it isn't lost if the process dies
+	if err := client.outbox.Push(id, b); err != nil {
+		client.mu.Lock()
+		delete(client.inflight, id)
+		client.mu.Unlock()
+		return errors.Wrap(err, "[client] [send]")
+	}
+	// Upload which is sent again isn't dead anymore
+	if err := client.deadletter.Remove(id); err != nil {
+		client.stderr.Printf("[client] [send]: %s\n", err)
+	}
+	client.chandata <- d
 	// client.errlog.Printf("[client] [send]: id = %s; url = %s\n", id, u.String())
 	return nil
 }
 
-// Run - goroutine to handle connect to SYR
+// Run - goroutine to handle connect to SYR, jobs are uploaded by workers
+// of the pool. When ctx is done no job is started anymore and Run returns
+// after running uploads are finished, unfinished jobs stay in the outbox
 func (client *SYRHandler) Run(ctx context.Context, multi imultipartfile) error {
 	if multi == nil {
 		multi = &multipartfile{}
 	}
+	client.mu.Lock()
+	client.ctx = ctx
+	client.mu.Unlock()
+	client.load(ctx)
+	if client.breaker != nil {
+		go client.watch(ctx)
+	}
+	queue := newScheduler(client.pool)
+	finished := make(chan *data, client.pool.workers)
 	for {
+		// Jobs wait in the queue while breaker is open or the pool, which
+		// is shared by sinks, is busy
+		free := client.pool.released()
+		for d := client.next(queue); d != nil; d = client.next(queue) {
+			go func(d *data) {
+				if err := client.upload(ctx, d, multi); err != nil {
+					client.stderr.Printf("[client] [run]: %s\n", err)
+				}
+				finished <- d
+			}(d)
+		}
 		select {
-		case data := <-client.chandata:
-			err := client.upload(ctx, data, multi)
-			if err != nil {
-				client.stderr.Printf("[client] [run]: %s\n", err)
-			}
+		case d := <-client.chandata:
+			queue.push(d)
+		case d := <-finished:
+			queue.done(d)
+		case <-client.breaker.resumed():
+		case <-free:
 		case <-ctx.Done():
+			for queue.busy > 0 {
+				queue.done(<-finished)
+			}
 			return nil
 		}
 	}
 }
 
+// next - take job from queue if breaker allows dispatch
+func (client *SYRHandler) next(queue *scheduler) *data {
+	if !client.breaker.allow() {
+		return nil
+	}
+	return queue.pop()
+}
+
+// load - restore the outbox once
+func (client *SYRHandler) load(ctx context.Context) {
+	client.restored.Do(func() {
+		if err := client.restore(ctx); err != nil {
+			client.stderr.Printf("[client] [run]: %s\n", err)
+		}
+	})
+}
+
+// restore - re-enqueue jobs which were saved in the outbox before restart
+func (client *SYRHandler) restore(ctx context.Context) error {
+	jobs, err := client.outbox.ReadAll()
+	if err != nil {
+		return errors.Wrap(err, "[client] [restore]")
+	}
+	restored := make([]*data, 0, len(jobs))
+	for _, b := range jobs {
+		d, err := unmarshalData(b)
+		if err != nil {
+			client.stderr.Printf("[client] [restore]: skip job %s: %s\n", string(b), err)
+			continue
+		}
+		if !client.begin(d.id) {
+			continue
+		}
+		restored = append(restored, d)
+	}
+	if len(restored) == 0 {
+		return nil
+	}
+	client.stderr.Printf("[client] [restore]: %d jobs are re-enqueued\n", len(restored))
+	go func() {
+		for _, d := range restored {
+			select {
+			case client.chandata <- d:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// begin - mark job as in flight, return false if it is already in flight
+func (client *SYRHandler) begin(id string) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if _, ok := client.inflight[id]; ok {
+		return false
+	}
+	client.inflight[id] = struct{}{}
+	return true
+}
+
+// done - remove job from the outbox after it is delivered
+func (client *SYRHandler) done(id string) error {
+	client.mu.Lock()
+	delete(client.inflight, id)
+	client.mu.Unlock()
+	return client.outbox.Remove(id)
+}
+
+// again - handle failed attempt of upload, the job is enqueued again after
+// backoff or is moved to the dead-letter queue if it can't be repeated
+func (client *SYRHandler) again(ctx context.Context, data *data, retryable bool, reason error) error {
+	// Upload which is interrupted by shutdown is sent again after restart
+	if ctx.Err() != nil {
+		return reason
+	}
+	data.attempts++
+	data.lasterr = reason.Error()
+	if !retryable || client.retry.exhausted(data.attempts) {
+		return client.fail(data)
+	}
+	b, err := data.marshal()
+	if err != nil {
+		return errors.Wrap(err, "[client] [retry]")
+	}
+	if err := client.outbox.Push(data.id, b); err != nil {
+		return errors.Wrap(err, "[client] [retry]")
+	}
+	if client.hooks != nil {
+		if err := client.hooks.Retried(data.id, client.name, data.attempts, data.lasterr); err != nil {
+			client.stderr.Printf("[client] [retry]: %s\n", err)
+		}
+	}
+	delay := client.retry.backoff(data.attempts)
+	client.stderr.Printf("[client] [retry]: id = %s; attempt = %d; next in %s: %s\n",
+		data.id, data.attempts, delay, data.lasterr)
+	client.requeue(ctx, data, delay)
+	return nil
+}
+
+// requeue - enqueue job which is in flight again after delay
+func (client *SYRHandler) requeue(ctx context.Context, data *data, delay time.Duration) {
+	go func() {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case client.chandata <- data:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// fail - move job from the outbox to the dead-letter queue
+func (client *SYRHandler) fail(data *data) error {
+	// Upload which is sent again later is created from the start
+	if err := client.terminateTus(data); err != nil {
+		client.stderr.Printf("[client] [fail]: %s\n", err)
+	}
+	b, err := data.marshal()
+	if err != nil {
+		return errors.Wrap(err, "[client] [fail]")
+	}
+	if err := client.deadletter.Push(data.id, b); err != nil {
+		return errors.Wrap(err, "[client] [fail]")
+	}
+	if err := client.done(data.id); err != nil {
+		return errors.Wrap(err, "[client] [fail]")
+	}
+	if client.hooks != nil {
+		// File may be needed by other sinks or to send it again
+		purge, err := client.hooks.Failed(data.id, client.name, data.attempts, data.lasterr)
+		if err != nil {
+			client.stderr.Printf("[client] [fail]: %s\n", err)
+		}
+		if purge {
+			if err := client.purge(data.id); err != nil {
+				client.stderr.Printf("[client] [fail]: %s\n", err)
+			}
+		}
+	}
+	return errors.Errorf("[client] [fail]: id = %s is dead after %d attempts: %s",
+		data.id, data.attempts, data.lasterr)
+}
+
 // GetChanTerm - return channel of id(string) to delete file with that id
 func (client *SYRHandler) GetChanTerm() chan string {
 	return client.chanterm
 }
 
 type imultipartfile interface {
-	uploadMultipartFile(ctx context.Context, client *http.Client, url, token, key, path string, name string) (*http.Response, error)
+	uploadMultipartFile(ctx context.Context, client *http.Client, url, token, key, path string, name string, extra form) (*http.Response, error)
 }
 
 func (client *SYRHandler) upload(ctx context.Context, data *data, multi imultipartfile) error {
-	if data.token == "" {
-		if err := client.auth.authorize(ctx); err != nil {
-			return errors.Wrap(err, "[client] [upload]: ")
-		}
-		data.token = client.auth.token()
-	}
-	res, err := multi.uploadMultipartFile(
-		ctx,
-		client.auth.client(),
-		data.urlpath,
-		data.token,
-		data.fieldform,
-		data.filepath,
-		data.filename,
-	)
+	// Token is taken before each attempt, it may be refreshed meanwhile.
+	// Local archive doesn't need it
+	auth := client.authorization(data)
+	token := auth.token()
+	if token == "" && !usesArchive(data) {
+		if err := auth.authorize(ctx); err != nil {
+			client.outage(ctx, true)
+			return client.again(ctx, data, true, errors.Wrap(err, "[client] [upload]: "))
+		}
+		token = auth.token()
+	}
+	var res *http.Response
+	var err error
+	switch {
+	case usesArchive(data):
+		res, err = archive(data)
+	case client.usesTus(data):
+		res, err = client.forwardTus(ctx, token, data)
+	default:
+		res, err = multi.uploadMultipartFile(
+			ctx,
+			auth.client(),
+			data.urlpath,
+			token,
+			data.fieldform,
+			data.filepath,
+			data.filename,
+			data.form,
+		)
+	}
 	if err != nil {
-		return errors.Wrap(err, "[client] [upload]: ")
+		client.outage(ctx, true)
+		return client.again(ctx, data, true, errors.Wrap(err, "[client] [upload]: "))
+	}
+	// SYR is down if request is repeated by retry policy, other answers
+	// show that it is available
+	client.outage(ctx, res.StatusCode != 201 && res.StatusCode != 401 && client.retry.retryable(res.StatusCode))
+	var remote string
+	switch {
+	case res.StatusCode != 201:
+	case client.usesTus(data) && res.ContentLength == 0:
+		// Upload of tus upstream without response is known by its url
+		remote = data.location
+	default:
+		remote = client.remoteID(data.id, res)
+	}
+	if res.Body != nil {
+		res.Body.Close()
 	}
 	if res.StatusCode == 401 {
-		if err := client.auth.authorize(ctx); err != nil {
-			return errors.Wrap(err, "[client] [upload]: ")
+		if err := auth.authorize(ctx); err != nil {
+			client.outage(ctx, true)
+			return client.again(ctx, data, true, errors.Wrap(err, "[client] [upload]: "))
 		}
-		data.token = client.auth.token()
-		client.chandata <- data
-		return nil
+		// Upload is repeated at once with new token, new token which is
+		// rejected again counts the attempt
+		if !data.reauthorized {
+			data.reauthorized = true
+			client.requeue(ctx, data, 0)
+			return nil
+		}
+		data.reauthorized = false
+		return client.again(ctx, data, true, errors.New("[client] [upload]: [syr] token is expired"))
 	}
 	if res.StatusCode != 201 {
-		return errors.Errorf("[client] [upload]: [syr] status code = %s; request %v ", res.Status, res.Request)
+		return client.again(ctx, data, client.retry.retryable(res.StatusCode),
+			errors.Errorf("[client] [upload]: [syr] status code = %s", res.Status))
 	}
 	if res.StatusCode == 201 {
-		select {
-		case client.chanterm <- data.id:
-		default:
-			return errors.Errorf("[client] [upload]: file isn't deleted: %s ", data.id)
+		if err := client.done(data.id); err != nil {
+			client.stderr.Printf("[client] [upload]: %s\n", err)
+		}
+		return client.delivered(data.id, remote)
+	}
+	return nil
+}
+
+// delivered - save in metadata that upload is forwarded with id returned
+// by SYR and delete its file if other sinks don't need it
+func (client *SYRHandler) delivered(id string, remote string) error {
+	if client.hooks != nil {
+		purge, err := client.hooks.Forwarded(id, client.name, remote)
+		if err != nil {
+			client.stderr.Printf("[client] [upload]: %s\n", err)
+		}
+		if !purge {
+			return nil
 		}
 	}
+	return client.purge(id)
+}
+
+// purge - request to delete file of upload, it waits for the channel which
+// is shared by sinks until Run is over
+func (client *SYRHandler) purge(id string) error {
+	client.mu.Lock()
+	ctx := client.ctx
+	client.mu.Unlock()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case client.chanterm <- id:
+	case <-ctx.Done():
+		return errors.Errorf("[client] [upload]: file isn't deleted: %s ", id)
+	}
 	return nil
 }
 
 type multipartfile struct{}
 
 // TODO: implement test with http-server, test when authorize is fail
-func (m *multipartfile) uploadMultipartFile(ctx context.Context, client *http.Client, url, token, key, path string, name string) (*http.Response, error) {
+func (m *multipartfile) uploadMultipartFile(ctx context.Context, client *http.Client, url, token, key, path string, name string, extra form) (*http.Response, error) {
+	return sendMultipart(ctx, client, url, token, key, name, extra, func() (io.ReadCloser, error) {
+		return os.Open(path)
+	})
+}
+
+// sendMultipart - send content of file as field key of multipart form with
+// extra fields and headers, file is opened while request body is written
+func sendMultipart(
+	ctx context.Context,
+	client *http.Client,
+	url, token, key, name string,
+	extra form,
+	open func() (io.ReadCloser, error)) (*http.Response, error) {
 	body, writer := io.Pipe()
 	req, err := http.NewRequest(http.MethodPost, url, body)
 	if err != nil {
@@ -178,31 +786,18 @@ func (m *multipartfile) uploadMultipartFile(ctx context.Context, client *http.Cl
 	defer req.Body.Close()
 	multiwriter := multipart.NewWriter(writer)
 	req.Header.Add("Content-Type", multiwriter.FormDataContentType())
-	req.Header.Add("Authorization", token)
-	errchan := make(chan error)
+	for k, v := range extra.headers {
+		req.Header.Set(k, v)
+	}
+	if token != "" {
+		req.Header.Add("Authorization", token)
+	}
+	errchan := make(chan error, 1)
 	go func() {
-		defer close(errchan)
-		defer writer.Close()
-		defer multiwriter.Close()
-		w, err := multiwriter.CreateFormFile(key, name)
-		if err != nil {
-			errchan <- err
-			return
-		}
-		in, err := os.Open(path)
-		if err != nil {
-			errchan <- err
-			return
-		}
-		defer in.Close()
-		if written, err := io.Copy(w, in); err != nil {
-			errchan <- errors.Wrapf(err, "(%d bytes written)", written)
-			return
-		}
-		if err := multiwriter.Close(); err != nil {
-			errchan <- err
-			return
-		}
+		err := writeMultipart(multiwriter, key, name, extra, open)
+		// request fails with error of body, incomplete form isn't sent
+		writer.CloseWithError(err)
+		errchan <- err
 	}()
 	resp, err := client.Do(req.WithContext(ctx))
 	multierr := <-errchan
@@ -211,3 +806,25 @@ func (m *multipartfile) uploadMultipartFile(ctx context.Context, client *http.Cl
 	}
 	return resp, nil
 }
+
+// writeMultipart - write fields and file of form
+func writeMultipart(multiwriter *multipart.Writer, key string, name string, extra form, open func() (io.ReadCloser, error)) error {
+	for k, v := range extra.fields {
+		if err := multiwriter.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+	w, err := multiwriter.CreateFormFile(key, name)
+	if err != nil {
+		return err
+	}
+	in, err := open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if written, err := io.Copy(w, in); err != nil {
+		return errors.Wrapf(err, "(%d bytes written)", written)
+	}
+	return multiwriter.Close()
+}
@@ -16,6 +16,26 @@ func (m *HooksClientHandlerMock) Terminate(id string) error {
 	return args.Error(0)
 }
 
+func (m *HooksClientHandlerMock) Retried(id string, sink string, attempts int, reason string) error {
+	args := m.Called(id, sink, attempts, reason)
+	return args.Error(0)
+}
+
+func (m *HooksClientHandlerMock) Forwarded(id string, sink string, remote string) (bool, error) {
+	args := m.Called(id, sink, remote)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *HooksClientHandlerMock) Failed(id string, sink string, attempts int, reason string) (bool, error) {
+	args := m.Called(id, sink, attempts, reason)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *HooksClientHandlerMock) Dispatch(id string, sinks map[string]bool) ([]string, error) {
+	args := m.Called(id, sinks)
+	return args.Get(0).([]string), args.Error(1)
+}
+
 type MultipartfileMock struct {
 	mock.Mock
 }
@@ -27,7 +47,27 @@ func (m *MultipartfileMock) uploadMultipartFile(
 	token,
 	key,
 	path string,
-	name string) (*http.Response, error) {
-	args := m.Called(ctx, client, url, token, key, path, name)
+	name string,
+	extra form) (*http.Response, error) {
+	args := m.Called(ctx, client, url, token, key, path, name, extra)
 	return args.Get(0).(*http.Response), args.Error(1)
 }
+
+type QueueHandlerMock struct {
+	mock.Mock
+}
+
+func (m *QueueHandlerMock) Push(id string, job []byte) error {
+	args := m.Called(id, job)
+	return args.Error(0)
+}
+
+func (m *QueueHandlerMock) Remove(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *QueueHandlerMock) ReadAll() ([][]byte, error) {
+	args := m.Called()
+	return args.Get(0).([][]byte), args.Error(1)
+}
@@ -2,6 +2,7 @@ package infrastructure
 
 import (
 	"context"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -13,17 +14,25 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestNewSYRHandler(t *testing.T) {
 	cfg, _ := NewSYRConfig("http://", "/tmp/uploads", "attachment", ".bin")
 	auth, _ := NewSYRAuth("http://", "message", "yadro", "test", "test")
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Second, 0, nil)
+	outbox := new(QueueHandlerMock)
+	outbox.On("ReadAll").Return([][]byte{}, nil)
+	deadletter := new(QueueHandlerMock)
 	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags)
 
 	t.Run("valid New", func(t *testing.T) {
 		s, err := NewSYRHandler(
 			cfg,
+			retry,
 			auth,
+			outbox,
+			deadletter,
 			logerr)
 		assert.NotNil(t, s)
 		assert.Nil(t, err)
@@ -32,7 +41,10 @@ func TestNewSYRHandler(t *testing.T) {
 	t.Run("invalid Config", func(t *testing.T) {
 		s, err := NewSYRHandler(
 			nil,
+			retry,
 			auth,
+			outbox,
+			deadletter,
 			logerr)
 		assert.Nil(t, s)
 		assert.NotNil(t, err)
@@ -40,7 +52,43 @@ func TestNewSYRHandler(t *testing.T) {
 	t.Run("invalid Auth", func(t *testing.T) {
 		s, err := NewSYRHandler(
 			cfg,
+			retry,
 			nil,
+			outbox,
+			deadletter,
+			logerr)
+		assert.Nil(t, s)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid Retry", func(t *testing.T) {
+		s, err := NewSYRHandler(
+			cfg,
+			nil,
+			auth,
+			outbox,
+			deadletter,
+			logerr)
+		assert.Nil(t, s)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid Deadletter", func(t *testing.T) {
+		s, err := NewSYRHandler(
+			cfg,
+			retry,
+			auth,
+			outbox,
+			nil,
+			logerr)
+		assert.Nil(t, s)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid Outbox", func(t *testing.T) {
+		s, err := NewSYRHandler(
+			cfg,
+			retry,
+			auth,
+			nil,
+			deadletter,
 			logerr)
 		assert.Nil(t, s)
 		assert.NotNil(t, err)
@@ -49,7 +97,10 @@ func TestNewSYRHandler(t *testing.T) {
 	t.Run("invalid Errlog", func(t *testing.T) {
 		s, err := NewSYRHandler(
 			cfg,
+			retry,
 			auth,
+			outbox,
+			deadletter,
 			nil)
 		assert.Nil(t, s)
 		assert.NotNil(t, err)
@@ -58,13 +109,19 @@ func TestNewSYRHandler(t *testing.T) {
 
 func TestSend(t *testing.T) {
 	cfg, _ := NewSYRConfig("http://test", "/tmp", "attachment", "")
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Second, 0, nil)
 	auth, _ := NewSYRAuth("http://test", "message", "yadro", "test", "test")
 	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags)
-	client, _ := NewSYRHandler(cfg, auth, logerr)
+	outbox := new(QueueHandlerMock)
+	outbox.On("ReadAll").Return([][]byte{}, nil)
+	deadletter := new(QueueHandlerMock)
+	client, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, logerr)
 	assert.NotNil(t, client)
 	id := "0123456789abcdifg"
 	name := "log.tar"
 	system := "0123456789"
+	outbox.On("Push", id, mock.Anything).Return(nil)
+	deadletter.On("Remove", id).Return(nil)
 	testfile := filepath.Join(client.cfg.pathfile, id+client.cfg.fileext)
 	defer func() {
 		if _, err := os.Stat(testfile); err == nil {
@@ -74,19 +131,76 @@ func TestSend(t *testing.T) {
 	file, _ := os.Create(testfile)
 	defer file.Close()
 	t.Run("valid call", func(t *testing.T) {
-		err := client.Send(id, name, system)
+		err := client.Send(id, name, system, "", nil)
 		assert.Nil(t, err)
 		select {
 		case d := <-client.chandata:
 			assert.Equal(t,
-				&data{id, client.auth.token(), name, "http://test/" + system, testfile, client.cfg.fieldform},
+				&data{id: id, serial: system, filename: name, urlpath: "http://test/" + system, filepath: testfile, fieldform: client.cfg.fieldform},
 				d)
 		default:
 			assert.Fail(t, "send: empty channel; expect data")
 		}
+		outbox.AssertCalled(t, "Push", id, mock.Anything)
+		deadletter.AssertCalled(t, "Remove", id)
+	})
+	t.Run("valid call of queued upload", func(t *testing.T) {
+		err := client.Send(id, name, system, "", nil)
+		assert.Nil(t, err)
+		select {
+		case <-client.chandata:
+			assert.Fail(t, "send: channel should be empty")
+		default:
+
+		}
+		outbox.AssertNumberOfCalls(t, "Push", 1)
+	})
+	t.Run("valid call by route", func(t *testing.T) {
+		routed, _ := NewSYRConfig("http://test", "/tmp", "attachment", "")
+		bearer, _ := NewBearerAuth("0123456789")
+		assert.Nil(t, routed.AddRoute("storage", "http://storage/v2", "dump", ".tar", bearer))
+		assert.Error(t, routed.AddRoute("", "http://storage/v2", "", "", nil))
+		client, _ := NewSYRHandler(routed, retry, auth, outbox, deadletter, logerr)
+		assert.Nil(t, client.Send(id, "log", system, "storage", nil))
+		select {
+		case d := <-client.chandata:
+			assert.Equal(t,
+				&data{id: id, serial: system, filename: "log.tar", urlpath: "http://storage/v2/" + system,
+					filepath: testfile, fieldform: "dump", route: "storage"},
+				d)
+			assert.Equal(t, bearer, client.authorization(d))
+		default:
+			assert.Fail(t, "send: empty channel; expect data")
+		}
+		// unknown route goes to url of config
+		client, _ = NewSYRHandler(routed, retry, auth, outbox, deadletter, logerr)
+		assert.Nil(t, client.Send(id, name, system, "unknown", nil))
+		select {
+		case d := <-client.chandata:
+			assert.Equal(t, "http://test/"+system, d.urlpath)
+			assert.Equal(t, "", d.route)
+			assert.Equal(t, auth, client.authorization(d))
+		default:
+			assert.Fail(t, "send: empty channel; expect data")
+		}
+	})
+	t.Run("invalid outbox push", func(t *testing.T) {
+		failed := new(QueueHandlerMock)
+		client, _ := NewSYRHandler(cfg, retry, auth, failed, new(QueueHandlerMock), logerr)
+		e := errors.New("fail")
+		failed.On("ReadAll").Return([][]byte{}, nil)
+		failed.On("Push", id, mock.Anything).Return(e)
+		err := client.Send(id, name, system, "", nil)
+		assert.Equal(t, e, errors.Cause(err))
+		select {
+		case <-client.chandata:
+			assert.Fail(t, "send: channel should be empty")
+		default:
+
+		}
 	})
 	t.Run("invalid file name", func(t *testing.T) {
-		err := client.Send(id, "", system)
+		err := client.Send(id, "", system, "", nil)
 		assert.NotNil(t, err)
 		select {
 		case <-client.chandata:
@@ -96,7 +210,7 @@ func TestSend(t *testing.T) {
 		}
 	})
 	t.Run("invalid file not exist", func(t *testing.T) {
-		err := client.Send("test", name, system)
+		err := client.Send("test", name, system, "", nil)
 		assert.NotNil(t, err)
 		select {
 		case <-client.chandata:
@@ -109,17 +223,25 @@ func TestSend(t *testing.T) {
 
 func TestRun(t *testing.T) {
 	cfg, _ := NewSYRConfig("http://test", "/tmp", "attachment", "")
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Second, 0, nil)
 	auth := new(SYRAuthMock)
 	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags) // TODO: need mock for logerr
-	client, _ := NewSYRHandler(cfg, auth, logerr)
+	outbox := new(QueueHandlerMock)
+	deadletter := new(QueueHandlerMock)
+	client, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, logerr)
 	assert.NotNil(t, client)
 	id := "0123456789abcdifg"
 	name := "log.tar"
+	outbox.On("ReadAll").Return([][]byte{}, nil)
+	outbox.On("Push", id, mock.Anything).Return(nil)
+	outbox.On("Remove", id).Return(nil)
+	deadletter.On("Push", id, mock.Anything).Return(nil)
 	//system := "0123456789"
 	//testfile := filepath.Join(client.cfg.pathfile, id+client.cfg.fileext)
 	t.Run("invalid authorization fail", func(t *testing.T) {
 		e := errors.New("fail")
 		ctx, cancel := context.WithCancel(context.Background())
+		auth.On("token").Return("")
 		auth.On("authorize", ctx).Return(e)
 		var wg sync.WaitGroup
 		wg.Add(1)
@@ -128,16 +250,66 @@ func TestRun(t *testing.T) {
 			err := client.Run(ctx, nil)
 			assert.Nil(t, err)
 		}()
-		client.chandata <- &data{id, "", name, "", "", ""}
+		client.chandata <- &data{id: id, filename: name, urlpath: "", filepath: "", fieldform: ""}
 		time.Sleep(10 * time.Millisecond)
 		cancel()
 		wg.Wait()
 		auth.AssertCalled(t, "authorize", ctx)
+		auth.AssertNumberOfCalls(t, "authorize", 3)
+		deadletter.AssertCalled(t, "Push", id, mock.Anything)
+		outbox.AssertCalled(t, "Remove", id)
+	})
+	t.Run("valid restore outbox", func(t *testing.T) {
+		auth := new(SYRAuthMock)
+		outbox := new(QueueHandlerMock)
+		client, _ := NewSYRHandler(cfg, retry, auth, outbox, new(QueueHandlerMock), logerr)
+		restored := &data{id: id, filename: name, urlpath: "http://test/0123456789", filepath: "/tmp/" + id, fieldform: "attachment"}
+		b, _ := restored.marshal()
+		outbox.On("ReadAll").Return([][]byte{b, []byte("{}")}, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		err := client.restore(ctx)
+		assert.Nil(t, err)
+		select {
+		case d := <-client.chandata:
+			assert.Equal(t, restored, d)
+		case <-time.After(time.Second):
+			assert.Fail(t, "restore: empty channel; expect data")
+		}
+		// job is in flight already, so it isn't re-enqueued twice
+		err = client.restore(ctx)
+		assert.Nil(t, err)
+		select {
+		case <-client.chandata:
+			assert.Fail(t, "restore: channel should be empty")
+		case <-time.After(10 * time.Millisecond):
+		}
+	})
+	t.Run("valid send before run keeps attempts of outbox", func(t *testing.T) {
+		outbox := new(QueueHandlerMock)
+		client, _ := NewSYRHandler(cfg, retry, new(SYRAuthMock), outbox, new(QueueHandlerMock), logerr)
+		failing := &data{id: id, filename: name, urlpath: "http://test/0123456789", filepath: "/tmp/" + id,
+			fieldform: "attachment", attempts: 2, lasterr: "status code = 503"}
+		b, _ := failing.marshal()
+		outbox.On("ReadAll").Return([][]byte{b}, nil)
+		assert.Nil(t, ioutil.WriteFile("/tmp/"+id, []byte("hello"), 0644))
+		defer os.Remove("/tmp/" + id)
+		// e.g. reconcile sends complete upload again on start
+		assert.Nil(t, client.Send(id, name, "0123456789", "", nil))
+		outbox.AssertNotCalled(t, "Push", id, mock.Anything)
+		select {
+		case d := <-client.chandata:
+			assert.Equal(t, 2, d.attempts)
+		case <-time.After(time.Second):
+			assert.Fail(t, "restore: empty channel; expect data")
+		}
+		outbox.AssertNumberOfCalls(t, "ReadAll", 1)
 	})
 }
 
 func TestUpload(t *testing.T) {
 	cfg, _ := NewSYRConfig("http://test", "/tmp", "attachment", "")
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Second, 0, nil)
 	//auth := new(SYRAuthMock)
 	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags) // TODO: need mock for logerr
 	//client, _ := NewSYRHandler(cfg, auth, logerr)
@@ -154,9 +326,12 @@ func TestUpload(t *testing.T) {
 	response := httptest.NewRecorder().Result()
 	t.Run("valid upload", func(t *testing.T) {
 		auth := new(SYRAuthMock)
-		client, _ := NewSYRHandler(cfg, auth, logerr)
+		outbox := new(QueueHandlerMock)
+		outbox.On("ReadAll").Return([][]byte{}, nil)
+		client, _ := NewSYRHandler(cfg, retry, auth, outbox, new(QueueHandlerMock), logerr)
 		assert.NotNil(t, client)
 		multi := new(MultipartfileMock)
+		outbox.On("Remove", id).Return(nil)
 		response.StatusCode = 201
 		response.Status = "201 Created"
 		auth.On("token").Return(token)
@@ -169,11 +344,13 @@ func TestUpload(t *testing.T) {
 			client.cfg.fieldform,
 			testfile,
 			name,
+			form{},
 		).Return(response, nil)
-		d := data{id, token, name, "http://test/", testfile, client.cfg.fieldform}
+		d := data{id: id, filename: name, urlpath: "http://test/", filepath: testfile, fieldform: client.cfg.fieldform}
 		err := client.upload(ctx, &d, multi)
 		auth.AssertCalled(t, "client")
-		auth.AssertNotCalled(t, "token")
+		auth.AssertCalled(t, "token")
+		auth.AssertNotCalled(t, "authorize", ctx)
 		multi.AssertCalled(t, "uploadMultipartFile",
 			ctx,
 			httpclient,
@@ -181,8 +358,10 @@ func TestUpload(t *testing.T) {
 			token,
 			client.cfg.fieldform,
 			testfile,
-			name)
+			name,
+			form{})
 		assert.Nil(t, err)
+		outbox.AssertCalled(t, "Remove", id)
 		select {
 		case d := <-client.GetChanTerm():
 			assert.Equal(t, id, d)
@@ -192,9 +371,12 @@ func TestUpload(t *testing.T) {
 	})
 	t.Run("invalid authorize", func(t *testing.T) {
 		auth := new(SYRAuthMock)
-		client, _ := NewSYRHandler(cfg, auth, logerr)
+		outbox := new(QueueHandlerMock)
+		outbox.On("ReadAll").Return([][]byte{}, nil)
+		client, _ := NewSYRHandler(cfg, retry, auth, outbox, new(QueueHandlerMock), logerr)
 		assert.NotNil(t, client)
 		multi := new(MultipartfileMock)
+		outbox.On("Push", id, mock.Anything).Return(nil)
 		response.StatusCode = 401
 		response.Status = "401 Unauthorized"
 		auth.On("token").Return(token)
@@ -208,8 +390,9 @@ func TestUpload(t *testing.T) {
 			client.cfg.fieldform,
 			testfile,
 			name,
+			form{},
 		).Return(response, nil)
-		d := data{id, token, name, "http://test/", testfile, client.cfg.fieldform}
+		d := data{id: id, filename: name, urlpath: "http://test/", filepath: testfile, fieldform: client.cfg.fieldform}
 		err := client.upload(ctx, &d, multi)
 		auth.AssertCalled(t, "client")
 		auth.AssertCalled(t, "token")
@@ -221,8 +404,23 @@ func TestUpload(t *testing.T) {
 			token,
 			client.cfg.fieldform,
 			testfile,
-			name)
+			name,
+			form{})
+		assert.Nil(t, err)
+		// Upload with new token is repeated at once without counting attempt
+		assert.Equal(t, 0, d.attempts)
+		outbox.AssertNotCalled(t, "Push", id, mock.Anything)
+		select {
+		case queued := <-client.chandata:
+			assert.Equal(t, &d, queued)
+		case <-time.After(time.Second):
+			assert.Fail(t, "send: empty channel, expect data")
+		}
+		// New token which is rejected again counts the attempt
+		err = client.upload(ctx, &d, multi)
 		assert.Nil(t, err)
+		assert.Equal(t, 1, d.attempts)
+		outbox.AssertCalled(t, "Push", id, mock.Anything)
 		select {
 		case <-client.chanterm:
 			assert.Fail(t, "send: channel should be empty")
@@ -232,6 +430,96 @@ func TestUpload(t *testing.T) {
 	})
 }
 
+func TestRetrySYR(t *testing.T) {
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Millisecond, 0, []int{503})
+	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	id := "0123456789retry"
+	name := "log.tar"
+	testfile := filepath.Join("/tmp", id)
+	file, _ := os.Create(testfile)
+	file.Close()
+	defer os.Remove(testfile)
+	run := func(status func(call int) int) (*HooksClientHandlerMock, *QueueHandlerMock, *QueueHandlerMock, int) {
+		var mu sync.Mutex
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			calls++
+			code := status(calls)
+			mu.Unlock()
+			w.WriteHeader(code)
+		}))
+		defer ts.Close()
+		cfg, _ := NewSYRConfig(ts.URL, "/tmp", "attachment", "")
+		auth := new(SYRAuthMock)
+		auth.On("token").Return("yadro0123456789")
+		auth.On("client").Return(ts.Client())
+		outbox := new(QueueHandlerMock)
+		outbox.On("ReadAll").Return([][]byte{}, nil)
+		outbox.On("Push", id, mock.Anything).Return(nil)
+		outbox.On("Remove", id).Return(nil)
+		deadletter := new(QueueHandlerMock)
+		deadletter.On("Push", id, mock.Anything).Return(nil)
+		deadletter.On("Remove", id).Return(nil)
+		hooks := new(HooksClientHandlerMock)
+		hooks.On("Retried", id, DefaultSink, mock.Anything, mock.Anything).Return(nil)
+		hooks.On("Failed", id, DefaultSink, mock.Anything, mock.Anything).Return(false, nil)
+		hooks.On("Forwarded", id, DefaultSink, "").Return(true, nil)
+		client, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, logerr)
+		assert.Nil(t, client.SetHooksHandler(hooks))
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, client.Run(ctx, nil))
+		}()
+		assert.Nil(t, client.Send(id, name, "0123456789", "", nil))
+		select {
+		case <-client.GetChanTerm():
+		case <-time.After(200 * time.Millisecond):
+		}
+		cancel()
+		wg.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		return hooks, outbox, deadletter, calls
+	}
+	t.Run("valid retry until created", func(t *testing.T) {
+		hooks, outbox, deadletter, calls := run(func(call int) int {
+			if call < 3 {
+				return http.StatusServiceUnavailable
+			}
+			return http.StatusCreated
+		})
+		assert.Equal(t, 3, calls)
+		hooks.AssertCalled(t, "Retried", id, DefaultSink, 1, mock.Anything)
+		hooks.AssertCalled(t, "Retried", id, DefaultSink, 2, mock.Anything)
+		hooks.AssertNotCalled(t, "Failed", id, DefaultSink, mock.Anything, mock.Anything)
+		hooks.AssertCalled(t, "Forwarded", id, DefaultSink, "")
+		outbox.AssertCalled(t, "Remove", id)
+		deadletter.AssertNotCalled(t, "Push", id, mock.Anything)
+	})
+	t.Run("invalid attempts exhausted", func(t *testing.T) {
+		hooks, outbox, deadletter, calls := run(func(call int) int {
+			return http.StatusServiceUnavailable
+		})
+		assert.Equal(t, 3, calls)
+		hooks.AssertCalled(t, "Failed", id, DefaultSink, 3, mock.Anything)
+		outbox.AssertCalled(t, "Remove", id)
+		deadletter.AssertCalled(t, "Push", id, mock.Anything)
+	})
+	t.Run("invalid status isn't retryable", func(t *testing.T) {
+		hooks, _, deadletter, calls := run(func(call int) int {
+			return http.StatusBadRequest
+		})
+		assert.Equal(t, 1, calls)
+		hooks.AssertNotCalled(t, "Retried", id, DefaultSink, mock.Anything, mock.Anything)
+		hooks.AssertCalled(t, "Failed", id, DefaultSink, 1, mock.Anything)
+		deadletter.AssertCalled(t, "Push", id, mock.Anything)
+	})
+}
+
 func TestMultipartFile(t *testing.T) {
 	ctx := context.Background()
 	client := &http.Client{Timeout: time.Second * 5}
@@ -260,6 +548,7 @@ func TestMultipartFile(t *testing.T) {
 			key,
 			path,
 			name,
+			form{},
 		)
 		assert.Nil(t, err)
 		assert.Equal(t, 201, res.StatusCode)
@@ -278,6 +567,7 @@ func TestMultipartFile(t *testing.T) {
 			key,
 			path,
 			name,
+			form{},
 		)
 		assert.Nil(t, err)
 		assert.Equal(t, 401, res.StatusCode)
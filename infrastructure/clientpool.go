@@ -0,0 +1,175 @@
+package infrastructure
+
+import (
+	"net/url"
+	"path"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// WorkerPool - rules to forward uploads to SYR concurrently, handlers
+// which share the pool share its limits
+type WorkerPool struct {
+	workers int
+	// perdest - default limit of concurrent uploads to one destination
+	perdest int
+	// caps - limits of concurrent uploads to destinations by host
+	caps map[string]int
+	mu   sync.Mutex
+	// running - uploads by destination of all handlers of the pool
+	running map[string]int
+	busy    int
+	// free - closed when upload of any handler is finished
+	free chan struct{}
+}
+
+// NewWorkerPool - create new instance of worker pool for SYRHandler,
+// workers is a number of concurrent uploads, perdest limits concurrent uploads
+// to one destination (host of url) and caps overrides the limit for destinations
+func NewWorkerPool(workers int, perdest int, caps map[string]int) (*WorkerPool, error) {
+	if workers < 1 || perdest < 1 {
+		return nil, errors.New("[clientpool] [new] bad argument")
+	}
+	limits := make(map[string]int, len(caps))
+	for host, limit := range caps {
+		if host == "" || limit < 1 {
+			return nil, errors.New("[clientpool] [new] bad argument")
+		}
+		limits[host] = limit
+	}
+	return &WorkerPool{
+		workers: workers,
+		perdest: perdest,
+		caps:    limits,
+		running: make(map[string]int),
+		free:    make(chan struct{}),
+	}, nil
+}
+
+// limit - return limit of concurrent uploads to destination
+func (pool *WorkerPool) limit(dest string) int {
+	if limit, ok := pool.caps[dest]; ok {
+		return limit
+	}
+	return pool.perdest
+}
+
+// acquire - take worker and destination, return false if any of them is
+// at its limit
+func (pool *WorkerPool) acquire(dest string) bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.busy >= pool.workers || pool.running[dest] >= pool.limit(dest) {
+		return false
+	}
+	pool.running[dest]++
+	pool.busy++
+	return true
+}
+
+// release - return worker and destination to the pool and wake handlers
+// which wait for them
+func (pool *WorkerPool) release(dest string) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.running[dest]--; pool.running[dest] <= 0 {
+		delete(pool.running, dest)
+	}
+	pool.busy--
+	close(pool.free)
+	pool.free = make(chan struct{})
+}
+
+// released - return channel which is closed when the next upload of the
+// pool is finished
+func (pool *WorkerPool) released() <-chan struct{} {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.free
+}
+
+// destination - return destination of job, it is host of url
+func destination(d *data) string {
+	u, err := url.Parse(d.urlpath)
+	if err != nil {
+		return d.urlpath
+	}
+	return u.Host
+}
+
+// owner - return serial number of job, jobs persisted without it
+// are owned by the last element of url path
+func owner(d *data) string {
+	if d.serial != "" {
+		return d.serial
+	}
+	return path.Base(d.urlpath)
+}
+
+// scheduler - queue of jobs of handler waiting for worker of the pool, jobs
+// are taken round-robin by serial number, so uploads of one device can't
+// monopolise the pool
+type scheduler struct {
+	pool   *WorkerPool
+	queues map[string][]*data
+	order  []string
+	next   int
+	// busy - running jobs of the handler
+	busy int
+}
+
+func newScheduler(pool *WorkerPool) *scheduler {
+	return &scheduler{
+		pool:   pool,
+		queues: make(map[string][]*data),
+	}
+}
+
+// push - add job to queue of its serial number
+func (s *scheduler) push(d *data) {
+	serial := owner(d)
+	if _, ok := s.queues[serial]; !ok {
+		s.order = append(s.order, serial)
+	}
+	s.queues[serial] = append(s.queues[serial], d)
+}
+
+// pop - take the next job which destination isn't at its limit,
+// return nil if all workers are busy or no job may be started
+func (s *scheduler) pop() *data {
+	for i := 0; i < len(s.order); i++ {
+		k := (s.next + i) % len(s.order)
+		serial := s.order[k]
+		queue := s.queues[serial]
+		// the first job of serial which destination is free is taken
+		for j, d := range queue {
+			if !s.pool.acquire(destination(d)) {
+				continue
+			}
+			queue = append(queue[:j:j], queue[j+1:]...)
+			if len(queue) == 0 {
+				delete(s.queues, serial)
+				s.order = append(s.order[:k], s.order[k+1:]...)
+				s.next = k
+			} else {
+				s.queues[serial] = queue
+				s.next = k + 1
+			}
+			if len(s.order) > 0 {
+				s.next %= len(s.order)
+			} else {
+				s.next = 0
+			}
+			s.busy++
+			return d
+		}
+	}
+	return nil
+}
+
+// done - release worker and destination of finished job
+func (s *scheduler) done(d *data) {
+	s.pool.release(destination(d))
+	s.busy--
+}
@@ -0,0 +1,258 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewWorkerPool(t *testing.T) {
+	t.Run("valid New", func(t *testing.T) {
+		pool, err := NewWorkerPool(4, 2, map[string]int{"syr.com": 3})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, pool.limit("syr.com"))
+		assert.Equal(t, 2, pool.limit("test.com"))
+	})
+	t.Run("invalid workers", func(t *testing.T) {
+		pool, err := NewWorkerPool(0, 2, nil)
+		assert.Nil(t, pool)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid destination", func(t *testing.T) {
+		pool, err := NewWorkerPool(4, 2, map[string]int{"syr.com": 0})
+		assert.Nil(t, pool)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestScheduler(t *testing.T) {
+	job := func(id, serial, host string) *data {
+		return &data{id: id, serial: serial, urlpath: "http://" + host + "/v1/" + serial}
+	}
+	ids := func(s *scheduler) []string {
+		list := []string{}
+		for d := s.pop(); d != nil; d = s.pop() {
+			list = append(list, d.id)
+		}
+		return list
+	}
+	t.Run("valid round-robin by serial", func(t *testing.T) {
+		pool, _ := NewWorkerPool(10, 10, nil)
+		s := newScheduler(pool)
+		s.push(job("a1", "a", "syr.com"))
+		s.push(job("a2", "a", "syr.com"))
+		s.push(job("a3", "a", "syr.com"))
+		s.push(job("b1", "b", "syr.com"))
+		s.push(job("c1", "c", "syr.com"))
+		s.push(job("c2", "c", "syr.com"))
+		assert.Equal(t, []string{"a1", "b1", "c1", "a2", "c2", "a3"}, ids(s))
+	})
+	t.Run("valid workers limit", func(t *testing.T) {
+		pool, _ := NewWorkerPool(2, 10, nil)
+		s := newScheduler(pool)
+		for i := 0; i < 3; i++ {
+			s.push(job(fmt.Sprint(i), fmt.Sprint(i), "syr.com"))
+		}
+		list := ids(s)
+		assert.Equal(t, []string{"0", "1"}, list)
+		s.done(job("0", "0", "syr.com"))
+		assert.Equal(t, []string{"2"}, ids(s))
+	})
+	t.Run("valid destination limit", func(t *testing.T) {
+		pool, _ := NewWorkerPool(10, 1, map[string]int{"fast.com": 2})
+		s := newScheduler(pool)
+		s.push(job("a1", "a", "syr.com"))
+		s.push(job("a2", "a", "syr.com"))
+		s.push(job("a3", "a", "fast.com"))
+		s.push(job("b1", "b", "fast.com"))
+		s.push(job("b2", "b", "fast.com"))
+		// a2 waits for syr.com, so a3 of the same serial is taken instead
+		assert.Equal(t, []string{"a1", "b1", "a3"}, ids(s))
+		s.done(job("a1", "a", "syr.com"))
+		s.done(job("b1", "b", "fast.com"))
+		assert.Equal(t, []string{"b2", "a2"}, ids(s))
+		assert.Equal(t, 3, s.busy)
+	})
+}
+
+func TestRunPool(t *testing.T) {
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Millisecond, 0, nil)
+	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	run := func(pool *WorkerPool, count int, cancelAfter int) (int, int) {
+		var mu sync.Mutex
+		active, peak, created := 0, 0, 0
+		release := make(chan struct{})
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			if active++; active > peak {
+				peak = active
+			}
+			mu.Unlock()
+			<-release
+			mu.Lock()
+			active--
+			created++
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer ts.Close()
+		cfg, _ := NewSYRConfig(ts.URL, "/tmp", "attachment", "")
+		auth := new(SYRAuthMock)
+		auth.On("token").Return("yadro0123456789")
+		auth.On("client").Return(ts.Client())
+		outbox := new(QueueHandlerMock)
+		outbox.On("ReadAll").Return([][]byte{}, nil)
+		outbox.On("Push", mock.Anything, mock.Anything).Return(nil)
+		outbox.On("Remove", mock.Anything).Return(nil)
+		deadletter := new(QueueHandlerMock)
+		deadletter.On("Remove", mock.Anything).Return(nil)
+		client, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, logerr)
+		assert.Nil(t, client.SetWorkerPool(pool))
+		for i := 0; i < count; i++ {
+			id := fmt.Sprintf("0123456789pool%d", i)
+			testfile := filepath.Join("/tmp", id)
+			file, _ := os.Create(testfile)
+			file.Close()
+			defer os.Remove(testfile)
+			assert.Nil(t, client.Send(id, "log.tar", fmt.Sprint(i), "", nil))
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		// Files of forwarded uploads are deleted by hooks of tusd
+		go func() {
+			for {
+				select {
+				case <-client.GetChanTerm():
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			assert.Nil(t, client.Run(ctx, nil))
+		}()
+		time.Sleep(50 * time.Millisecond)
+		if cancelAfter >= 0 {
+			cancel()
+			<-stopped
+			close(release)
+		} else {
+			defer cancel()
+			close(release)
+			for i := 0; i < 100; i++ {
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				forwarded := created == count
+				mu.Unlock()
+				if forwarded {
+					break
+				}
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return peak, created
+	}
+	t.Run("valid concurrent workers", func(t *testing.T) {
+		pool, _ := NewWorkerPool(3, 3, nil)
+		peak, created := run(pool, 5, -1)
+		assert.Equal(t, 3, peak)
+		assert.Equal(t, 5, created)
+	})
+	t.Run("valid destination limit", func(t *testing.T) {
+		pool, _ := NewWorkerPool(3, 1, nil)
+		peak, created := run(pool, 3, -1)
+		assert.Equal(t, 1, peak)
+		assert.Equal(t, 3, created)
+	})
+	t.Run("valid drain on cancel", func(t *testing.T) {
+		pool, _ := NewWorkerPool(2, 2, nil)
+		// Run returns after running uploads are interrupted
+		peak, created := run(pool, 4, 0)
+		assert.Equal(t, 2, peak)
+		assert.True(t, created <= 2)
+	})
+}
+
+func TestSharedPool(t *testing.T) {
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Millisecond, 0, nil)
+	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	var mu sync.Mutex
+	active, peak := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if active++; active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	// Sinks on the same host share its limit
+	pool, _ := NewWorkerPool(4, 2, nil)
+	fanout, _ := NewFanoutHandler(logerr)
+	for _, name := range []string{"syr", "syr-dc2"} {
+		cfg, _ := NewSYRConfig(ts.URL, "/tmp", "attachment", "")
+		auth := new(SYRAuthMock)
+		auth.On("token").Return("yadro0123456789")
+		auth.On("client").Return(ts.Client())
+		outbox := new(QueueHandlerMock)
+		outbox.On("ReadAll").Return([][]byte{}, nil)
+		outbox.On("Push", mock.Anything, mock.Anything).Return(nil)
+		outbox.On("Remove", mock.Anything).Return(nil)
+		deadletter := new(QueueHandlerMock)
+		deadletter.On("Remove", mock.Anything).Return(nil)
+		handler, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, logerr)
+		assert.Nil(t, handler.SetWorkerPool(pool))
+		assert.Nil(t, fanout.AddSink(name, true, handler))
+	}
+	const count = 3
+	for i := 0; i < count; i++ {
+		id := fmt.Sprintf("0123456789shared%d", i)
+		testfile := filepath.Join("/tmp", id)
+		file, _ := os.Create(testfile)
+		file.Close()
+		defer os.Remove(testfile)
+		assert.Nil(t, fanout.Send(id, "log.tar", fmt.Sprint(i), "", nil))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		assert.Nil(t, fanout.Run(ctx, nil))
+	}()
+	// Files are deleted slowly, requests of concurrent uploads aren't lost
+	purged := []string{}
+	timeout := time.After(2 * time.Second)
+wait:
+	for len(purged) < 2*count {
+		select {
+		case id := <-fanout.GetChanTerm():
+			purged = append(purged, id)
+			time.Sleep(10 * time.Millisecond)
+		case <-timeout:
+			break wait
+		}
+	}
+	cancel()
+	<-stopped
+	assert.Len(t, purged, 2*count)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, peak)
+}
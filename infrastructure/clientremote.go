@@ -0,0 +1,82 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxRemoteBody - limit of response of SYR which is read to find remote id
+const maxRemoteBody = 1 << 20
+
+// SetRemoteID - read id of upload on SYR (attachment or ticket) from JSON
+// response by path of keys separated by dots, e.g. "data.attachment.id",
+// number is index of array. Empty path doesn't read response
+func (cfg *SYRConfig) SetRemoteID(path string) error {
+	if path == "" {
+		cfg.remoteid = nil
+		return nil
+	}
+	keys := strings.Split(path, ".")
+	for _, key := range keys {
+		if key == "" {
+			return errors.Errorf("[clienthandler] [set remote id] bad path %q", path)
+		}
+	}
+	cfg.remoteid = keys
+	return nil
+}
+
+// remoteID - return id of upload from response of SYR, response without
+// id doesn't fail forwarding, it is only logged
+func (client *SYRHandler) remoteID(id string, res *http.Response) string {
+	if len(client.cfg.remoteid) == 0 || res.Body == nil {
+		return ""
+	}
+	remote, err := findRemoteID(io.LimitReader(res.Body, maxRemoteBody), client.cfg.remoteid)
+	if err != nil {
+		client.stderr.Printf("[client] [remote id]: id = %s: %s\n", id, err)
+		return ""
+	}
+	return remote
+}
+
+// findRemoteID - walk JSON document by keys, value has to be string,
+// number or boolean
+func findRemoteID(body io.Reader, keys []string) (string, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return "", errors.Wrap(err, "bad response")
+	}
+	for i, key := range keys {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			n, err := strconv.Atoi(key)
+			if err != nil || n < 0 || n >= len(node) {
+				return "", errors.Errorf("no index %q at %s", key, strings.Join(keys[:i], "."))
+			}
+			v = node[n]
+		default:
+			return "", errors.Errorf("no key %q at %s", key, strings.Join(keys[:i], "."))
+		}
+	}
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case nil:
+		return "", errors.Errorf("no value at %s", strings.Join(keys, "."))
+	}
+	return "", errors.Errorf("value at %s isn't scalar", strings.Join(keys, "."))
+}
@@ -0,0 +1,88 @@
+package infrastructure
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestFindRemoteID(t *testing.T) {
+	const body = `{"data": {"ticket": "SYR-42", "attachments": [{"id": 42}], "ok": true, "link": null}}`
+	t.Run("valid path", func(t *testing.T) {
+		for path, expected := range map[string]string{
+			"data.ticket":           "SYR-42",
+			"data.attachments.0.id": "42",
+			"data.ok":               "true",
+		} {
+			remote, err := findRemoteID(strings.NewReader(body), strings.Split(path, "."))
+			assert.Nil(t, err, path)
+			assert.Equal(t, expected, remote, path)
+		}
+	})
+	t.Run("invalid path", func(t *testing.T) {
+		for _, path := range []string{"data.link", "data.unknown", "data.attachments.1.id", "data.ticket.id", "data"} {
+			_, err := findRemoteID(strings.NewReader(body), strings.Split(path, "."))
+			assert.Error(t, err, path)
+		}
+		_, err := findRemoteID(strings.NewReader("Created"), []string{"id"})
+		assert.Error(t, err)
+	})
+	t.Run("invalid config", func(t *testing.T) {
+		cfg, _ := NewSYRConfig("http://syr.com", "/tmp", "attachment", "")
+		assert.Error(t, cfg.SetRemoteID("data..id"))
+		assert.Nil(t, cfg.SetRemoteID("data.id"))
+		assert.Equal(t, []string{"data", "id"}, cfg.remoteid)
+		assert.Nil(t, cfg.SetRemoteID(""))
+		assert.Nil(t, cfg.remoteid)
+	})
+}
+
+func TestSendRemoteID(t *testing.T) {
+	id := "0123456789remote"
+	dir, err := ioutil.TempDir("", "test-remote")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, id), []byte("hello world"), 0644))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"data": {"attachments": [{"id": 42}]}}`))
+	}))
+	defer ts.Close()
+	cfg, _ := NewSYRConfig(ts.URL, dir, "attachment", "")
+	assert.Nil(t, cfg.SetRemoteID("data.attachments.0.id"))
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Millisecond, 0, nil)
+	auth := new(SYRAuthMock)
+	auth.On("token").Return("yadro0123456789")
+	auth.On("client").Return(ts.Client())
+	outbox := new(QueueHandlerMock)
+	outbox.On("ReadAll").Return([][]byte{}, nil)
+	outbox.On("Push", id, mock.Anything).Return(nil)
+	outbox.On("Remove", id).Return(nil)
+	deadletter := new(QueueHandlerMock)
+	deadletter.On("Remove", id).Return(nil)
+	hooks := new(HooksClientHandlerMock)
+	hooks.On("Forwarded", id, DefaultSink, "42").Return(true, nil)
+	client, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, log.New(os.Stdout, "[test] ", log.LstdFlags))
+	assert.Nil(t, client.SetHooksHandler(hooks))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx, nil)
+	assert.Nil(t, client.Send(id, "log.tar", "0123456789", "", nil))
+	select {
+	case d := <-client.GetChanTerm():
+		assert.Equal(t, id, d)
+	case <-time.After(time.Second):
+		assert.Fail(t, "remote id: empty channel, expect id")
+	}
+	hooks.AssertCalled(t, "Forwarded", id, DefaultSink, "42")
+}
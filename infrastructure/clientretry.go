@@ -0,0 +1,73 @@
+package infrastructure
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy - rules to repeat failed uploads to SYR
+type RetryPolicy struct {
+	maxattempts int
+	base        time.Duration
+	cap         time.Duration
+	jitter      float64
+	codes       map[int]bool
+}
+
+// NewRetryPolicy - create new instance of retry policy for SYRHandler
+func NewRetryPolicy(
+	maxattempts int,
+	base time.Duration,
+	cap time.Duration,
+	jitter float64,
+	codes []int) (*RetryPolicy, error) {
+
+	if maxattempts < 1 ||
+		base <= 0 ||
+		cap < base ||
+		jitter < 0 || jitter > 1 {
+		return nil, errors.New("[clientretry] [new] bad argument")
+	}
+	retrycodes := make(map[int]bool, len(codes)+1)
+	for _, code := range codes {
+		retrycodes[code] = true
+	}
+	// Token is requested again before the next attempt
+	retrycodes[http.StatusUnauthorized] = true
+	return &RetryPolicy{
+		maxattempts,
+		base,
+		cap,
+		jitter,
+		retrycodes}, nil
+}
+
+// retryable - return true if upload with that status code may be repeated
+func (policy *RetryPolicy) retryable(status int) bool {
+	return policy.codes[status]
+}
+
+// exhausted - return true if no attempts are left
+func (policy *RetryPolicy) exhausted(attempts int) bool {
+	return attempts >= policy.maxattempts
+}
+
+// backoff - return delay before the next attempt, it grows exponentially
+// from base up to cap and is spread by jitter
+func (policy *RetryPolicy) backoff(attempts int) time.Duration {
+	delay := policy.base
+	for i := 1; i < attempts && delay < policy.cap; i++ {
+		delay *= 2
+	}
+	if delay > policy.cap {
+		delay = policy.cap
+	}
+	if policy.jitter > 0 {
+		spread := float64(delay) * policy.jitter
+		delay = time.Duration(float64(delay) - spread + 2*spread*rand.Float64())
+	}
+	return delay
+}
@@ -0,0 +1,63 @@
+package infrastructure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	codes := []int{500, 503}
+	t.Run("valid New", func(t *testing.T) {
+		p, err := NewRetryPolicy(3, time.Second, time.Minute, 0.2, codes)
+		assert.NotNil(t, p)
+		assert.Nil(t, err)
+	})
+	t.Run("invalid attempts", func(t *testing.T) {
+		p, err := NewRetryPolicy(0, time.Second, time.Minute, 0.2, codes)
+		assert.Nil(t, p)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid base", func(t *testing.T) {
+		p, err := NewRetryPolicy(3, 0, time.Minute, 0.2, codes)
+		assert.Nil(t, p)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid cap", func(t *testing.T) {
+		p, err := NewRetryPolicy(3, time.Minute, time.Second, 0.2, codes)
+		assert.Nil(t, p)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid jitter", func(t *testing.T) {
+		p, err := NewRetryPolicy(3, time.Second, time.Minute, 1.5, codes)
+		assert.Nil(t, p)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p, _ := NewRetryPolicy(3, time.Second, 5*time.Second, 0, []int{503})
+	t.Run("valid retryable", func(t *testing.T) {
+		assert.True(t, p.retryable(503))
+		assert.True(t, p.retryable(401))
+		assert.False(t, p.retryable(400))
+	})
+	t.Run("valid exhausted", func(t *testing.T) {
+		assert.False(t, p.exhausted(2))
+		assert.True(t, p.exhausted(3))
+	})
+	t.Run("valid backoff", func(t *testing.T) {
+		assert.Equal(t, time.Second, p.backoff(1))
+		assert.Equal(t, 2*time.Second, p.backoff(2))
+		assert.Equal(t, 4*time.Second, p.backoff(3))
+		assert.Equal(t, 5*time.Second, p.backoff(10))
+	})
+	t.Run("valid backoff jitter", func(t *testing.T) {
+		j, _ := NewRetryPolicy(3, time.Second, 5*time.Second, 0.5, nil)
+		for i := 0; i < 100; i++ {
+			d := j.backoff(1)
+			assert.True(t, d >= 500*time.Millisecond && d <= 1500*time.Millisecond, "backoff %s", d)
+		}
+	})
+}
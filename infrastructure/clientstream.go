@@ -0,0 +1,354 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// streamPoll - interval to check size of file if progress isn't notified
+	streamPoll = 500 * time.Millisecond
+	// streamDialTimeout - timeout of connection to SYR, request of stream
+	// isn't limited in total, it lasts as long as upload
+	streamDialTimeout = 30 * time.Second
+)
+
+var (
+	errStreamAborted  = errors.New("upload is terminated")
+	errStreamStalled  = errors.New("upload is stalled")
+	errStreamChecksum = errors.New("upload uses checksum")
+)
+
+// stream - forwarding of upload which is still arriving
+type stream struct {
+	data  *data
+	wake  chan struct{}
+	abort chan struct{}
+	// finished - request to SYR is over, err and remote id are its result
+	finished bool
+	err      error
+	remote   string
+	// sent - Send is called while request isn't over yet
+	sent bool
+	// expires - mark of Unstream is dropped after it, Stream of upload is
+	// called soon after it is created
+	expires time.Time
+}
+
+// EnableStream - forward uploads to SYR while they are arriving,
+// stream is failed if upload doesn't grow during stall
+func (client *SYRHandler) EnableStream(stall time.Duration) error {
+	if stall <= 0 {
+		return errors.New("[clienthandler] [enable stream] bad argument")
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.stall = stall
+	return nil
+}
+
+// Stream - start forwarding of created upload, file is sent as it grows and
+// request is over when all of its bytes are written. Upload which can't be
+// streamed is forwarded by Send after it is complete
+func (client *SYRHandler) Stream(id string, name string, system string, route string, meta map[string]string) error {
+	client.mu.Lock()
+	stall, ctx := client.stall, client.ctx
+	s, exists := client.streams[id]
+	if exists && s.err == errStreamChecksum {
+		delete(client.streams, id)
+	}
+	client.mu.Unlock()
+	// Upload isn't streamed while SYR is down, it is queued after it is complete.
+	// Upload with checksum in metadata is verified before it is forwarded,
+	// stream could deliver corrupted file before verification
+	if stall == 0 || exists || !client.breaker.allow() || meta["Checksum"] != "" {
+		return nil
+	}
+	d, err := client.newData(id, name, system, route, meta)
+	if err != nil {
+		return errors.Wrap(err, "[client] [stream]")
+	}
+	// tus upstream resumes upload itself, see forwardTus,
+	// local archive copies complete file
+	if client.usesTus(d) || usesArchive(d) {
+		return nil
+	}
+	size, err := uploadSize(filepath.Join(client.cfg.pathfile, id+".info"))
+	if err != nil {
+		return errors.Wrap(err, "[client] [stream]")
+	}
+	s = &stream{data: d, wake: make(chan struct{}, 1), abort: make(chan struct{})}
+	client.mu.Lock()
+	// Unstream could be called while upload is prepared
+	if _, exists := client.streams[id]; exists {
+		client.mu.Unlock()
+		return nil
+	}
+	client.streams[id] = s
+	client.mu.Unlock()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	go client.runStream(ctx, s, size, stall)
+	return nil
+}
+
+// Progress - wake stream up, more bytes of upload are written
+func (client *SYRHandler) Progress(id string) {
+	client.mu.Lock()
+	s, ok := client.streams[id]
+	client.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Abort - stop stream of terminated upload
+func (client *SYRHandler) Abort(id string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if s, ok := client.streams[id]; ok {
+		delete(client.streams, id)
+		if !s.finished {
+			close(s.abort)
+		}
+	}
+}
+
+// Unstream - stop stream of upload before chunk with checksum is written,
+// chunk is written to file before it is verified and discarded on mismatch.
+// Upload isn't streamed anymore, it is sent after it is complete
+func (client *SYRHandler) Unstream(id string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.stall == 0 {
+		return
+	}
+	now := time.Now()
+	for key, s := range client.streams {
+		if s.err == errStreamChecksum && now.After(s.expires) {
+			delete(client.streams, key)
+		}
+	}
+	s, ok := client.streams[id]
+	if ok && s.finished {
+		return
+	}
+	if ok {
+		// Stream is over without consumer, its entry is deleted by runStream
+		close(s.abort)
+		delete(client.streams, id)
+		return
+	}
+	// Stream could be called after the first chunk, it is marked not to start
+	client.streams[id] = &stream{finished: true, err: errStreamChecksum, expires: now.Add(client.stall)}
+}
+
+// streamed - return true if upload is forwarded by stream or will be handled
+// when stream is over. Failed stream is forgotten, upload is sent as usual
+func (client *SYRHandler) streamed(id string) bool {
+	client.mu.Lock()
+	s, ok := client.streams[id]
+	if ok && !s.finished {
+		s.sent = true
+	}
+	if ok && s.finished {
+		delete(client.streams, id)
+	}
+	client.mu.Unlock()
+	switch {
+	case !ok:
+		return false
+	case !s.finished:
+		return true
+	case s.err == errStreamChecksum:
+		return false
+	case s.err != nil:
+		client.stderr.Printf("[client] [stream]: id = %s is sent again: %s\n", id, s.err)
+		return false
+	}
+	if err := client.delivered(id, s.remote); err != nil {
+		client.stderr.Printf("[client] [stream]: %s\n", err)
+	}
+	return true
+}
+
+// runStream - send upload to SYR while it is arriving
+func (client *SYRHandler) runStream(ctx context.Context, s *stream, size int64, stall time.Duration) {
+	remote, err := client.sendStream(ctx, s, size, stall)
+	client.mu.Lock()
+	s.finished = true
+	s.err = err
+	s.remote = remote
+	sent := s.sent
+	// Failed stream isn't kept for Send, upload is sent as usual after it is
+	// complete. Stream could be replaced or deleted already by Abort
+	if current, ok := client.streams[s.data.id]; ok && current == s && (sent || err != nil) {
+		delete(client.streams, s.data.id)
+	}
+	client.mu.Unlock()
+	if !sent {
+		// result is handled by Send after upload is complete
+		if err != nil && !aborted(s) {
+			client.stderr.Printf("[client] [stream]: id = %s is sent after it is complete: %s\n", s.data.id, err)
+		}
+		return
+	}
+	if err == nil {
+		err = client.delivered(s.data.id, remote)
+	} else {
+		client.stderr.Printf("[client] [stream]: id = %s is sent again: %s\n", s.data.id, err)
+		err = client.enqueue(s.data)
+	}
+	if err != nil {
+		client.stderr.Printf("[client] [stream]: %s\n", err)
+	}
+}
+
+// aborted - return true if stream is stopped by Abort or Unstream
+func aborted(s *stream) bool {
+	select {
+	case <-s.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+func (client *SYRHandler) sendStream(ctx context.Context, s *stream, size int64, stall time.Duration) (string, error) {
+	auth := client.authorization(s.data)
+	token := auth.token()
+	if token == "" {
+		if err := auth.authorize(ctx); err != nil {
+			return "", errors.Wrap(err, "[client] [stream]")
+		}
+		token = auth.token()
+	}
+	res, err := sendMultipart(ctx, streamClient(auth.client()), s.data.urlpath, token, s.data.fieldform, s.data.filename, s.data.form,
+		func() (io.ReadCloser, error) {
+			file, err := os.Open(s.data.filepath)
+			if err != nil {
+				return nil, err
+			}
+			return &growingReader{file, size, s.wake, s.abort, stall, time.Now()}, nil
+		})
+	if err != nil {
+		return "", errors.Wrap(err, "[client] [stream]")
+	}
+	var remote string
+	if res.StatusCode == 201 {
+		remote = client.remoteID(s.data.id, res)
+	}
+	if res.Body != nil {
+		res.Body.Close()
+	}
+	if res.StatusCode != 201 {
+		return "", errors.Errorf("[client] [stream]: [syr] status code = %s", res.Status)
+	}
+	return remote, nil
+}
+
+// streamClient - client of stream without total timeout, which would fail
+// long upload. Only connection and response are limited, stall of upload
+// is detected by growingReader
+func streamClient(base *http.Client) *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   streamDialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   streamDialTimeout,
+		ResponseHeaderTimeout: streamDialTimeout,
+		// transport is used by one stream only
+		DisableKeepAlives: true,
+	}
+	if base.Timeout > 0 {
+		transport.ResponseHeaderTimeout = base.Timeout
+	}
+	if t, ok := base.Transport.(*http.Transport); ok && t != nil {
+		transport.TLSClientConfig = t.TLSClientConfig
+		if t.Proxy != nil {
+			transport.Proxy = t.Proxy
+		}
+	}
+	return &http.Client{Transport: transport, CheckRedirect: base.CheckRedirect, Jar: base.Jar}
+}
+
+// growingReader - read file which is still written up to size bytes,
+// reader waits for more bytes at the end of file
+type growingReader struct {
+	// file isn't embedded, io.Copy would use its WriteTo instead of Read
+	file      *os.File
+	remaining int64
+	wake      chan struct{}
+	abort     chan struct{}
+	stall     time.Duration
+	grown     time.Time
+}
+
+func (reader *growingReader) Read(p []byte) (int, error) {
+	for {
+		if reader.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if int64(len(p)) > reader.remaining {
+			p = p[:reader.remaining]
+		}
+		n, err := reader.file.Read(p)
+		if n > 0 {
+			reader.remaining -= int64(n)
+			reader.grown = time.Now()
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if time.Since(reader.grown) > reader.stall {
+			return 0, errStreamStalled
+		}
+		select {
+		case <-reader.wake:
+		case <-reader.abort:
+			return 0, errStreamAborted
+		case <-time.After(streamPoll):
+		}
+	}
+}
+
+func (reader *growingReader) Close() error {
+	return reader.file.Close()
+}
+
+// uploadSize - read size of upload from its info file in filestore,
+// upload with deferred length can't be streamed
+func uploadSize(path string) (int64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var info struct {
+		Size           int64
+		SizeIsDeferred bool
+	}
+	if err := json.Unmarshal(b, &info); err != nil {
+		return 0, err
+	}
+	if info.SizeIsDeferred {
+		return 0, errors.New("size of upload is deferred")
+	}
+	return info.Size, nil
+}
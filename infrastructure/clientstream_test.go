@@ -0,0 +1,236 @@
+package infrastructure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStream(t *testing.T) {
+	const body = "hello world, it is a dump of logs"
+	dir, err := ioutil.TempDir("", "test-stream")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Millisecond, 0, nil)
+	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	// run - write upload by parts while it's streamed to server with status code
+	run := func(id string, code int, early bool, send func(client *SYRHandler)) (string, *HooksClientHandlerMock, *QueueHandlerMock, *SYRHandler) {
+		var mu sync.Mutex
+		received := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			file, _, err := r.FormFile("attachment")
+			if err == nil {
+				b, _ := ioutil.ReadAll(file)
+				mu.Lock()
+				received = string(b)
+				mu.Unlock()
+			}
+			w.WriteHeader(code)
+		}))
+		defer ts.Close()
+		cfg, _ := NewSYRConfig(ts.URL, dir, "attachment", "")
+		auth := new(SYRAuthMock)
+		auth.On("token").Return("yadro0123456789")
+		auth.On("client").Return(ts.Client())
+		outbox := new(QueueHandlerMock)
+		outbox.On("ReadAll").Return([][]byte{}, nil)
+		outbox.On("Push", id, mock.Anything).Return(nil)
+		deadletter := new(QueueHandlerMock)
+		deadletter.On("Remove", id).Return(nil)
+		hooks := new(HooksClientHandlerMock)
+		hooks.On("Forwarded", id, DefaultSink, "").Return(true, nil)
+		client, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, logerr)
+		assert.Nil(t, client.SetHooksHandler(hooks))
+		assert.Nil(t, client.EnableStream(time.Second))
+		info := fmt.Sprintf(`{"ID": %q, "Size": %d}`, id, len(body))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, id+".info"), []byte(info), 0644))
+		file, err := os.Create(filepath.Join(dir, id))
+		assert.Nil(t, err)
+		defer file.Close()
+		assert.Nil(t, client.Stream(id, "log.tar", "0123456789", "", nil))
+		for i := 0; i < len(body); i += 10 {
+			end := i + 10
+			if end > len(body) {
+				end = len(body)
+			}
+			file.WriteString(body[i:end])
+			client.Progress(id)
+			if early && i == 0 {
+				send(client)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if !early {
+			send(client)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return received, hooks, outbox, client
+	}
+	// finish - wait until stream is over
+	finish := func(client *SYRHandler, id string) {
+		for i := 0; i < 100; i++ {
+			client.mu.Lock()
+			s, ok := client.streams[id]
+			over := !ok || s.finished
+			client.mu.Unlock()
+			if over {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	t.Run("valid stream before complete", func(t *testing.T) {
+		id := "0123456789stream"
+		received, hooks, outbox, client := run(id, http.StatusCreated, false, func(client *SYRHandler) {
+			finish(client, id)
+			// upload isn't forwarded until it is complete
+			client.hooks.(*HooksClientHandlerMock).AssertNotCalled(t, "Forwarded", id, DefaultSink, "")
+			assert.Nil(t, client.Send(id, "log.tar", "0123456789", "", nil))
+		})
+		assert.Equal(t, body, received)
+		hooks.AssertCalled(t, "Forwarded", id, DefaultSink, "")
+		outbox.AssertNotCalled(t, "Push", id, mock.Anything)
+		assert.Equal(t, id, <-client.GetChanTerm())
+		assert.Empty(t, client.streams)
+	})
+	t.Run("valid complete before stream is over", func(t *testing.T) {
+		id := "0123456789sent"
+		received, hooks, outbox, client := run(id, http.StatusCreated, true, func(client *SYRHandler) {
+			assert.Nil(t, client.Send(id, "log.tar", "0123456789", "", nil))
+		})
+		select {
+		case d := <-client.GetChanTerm():
+			assert.Equal(t, id, d)
+		case <-time.After(time.Second):
+			assert.Fail(t, "stream: empty channel, expect id")
+		}
+		assert.Equal(t, body, received)
+		hooks.AssertCalled(t, "Forwarded", id, DefaultSink, "")
+		outbox.AssertNotCalled(t, "Push", id, mock.Anything)
+		assert.Empty(t, client.streams)
+	})
+	t.Run("invalid stream is sent again", func(t *testing.T) {
+		id := "0123456789failed"
+		_, hooks, outbox, client := run(id, http.StatusServiceUnavailable, false, func(client *SYRHandler) {
+			finish(client, id)
+			// failed stream isn't kept until Send
+			client.mu.Lock()
+			assert.Empty(t, client.streams)
+			client.mu.Unlock()
+			assert.Nil(t, client.Send(id, "log.tar", "0123456789", "", nil))
+		})
+		hooks.AssertNotCalled(t, "Forwarded", id, DefaultSink, "")
+		outbox.AssertCalled(t, "Push", id, mock.Anything)
+		select {
+		case d := <-client.chandata:
+			assert.Equal(t, id, d.id)
+		default:
+			assert.Fail(t, "stream: empty channel, expect data")
+		}
+	})
+	t.Run("invalid aborted upload", func(t *testing.T) {
+		id := "0123456789abort"
+		reader := &growingReader{nil, 10, make(chan struct{}), make(chan struct{}), time.Second, time.Now()}
+		reader.file, _ = os.Create(filepath.Join(dir, id))
+		defer reader.Close()
+		close(reader.abort)
+		_, err := reader.Read(make([]byte, 10))
+		assert.Equal(t, errStreamAborted, err)
+		reader.stall = 0
+		reader.grown = time.Now().Add(-time.Second)
+		_, err = reader.Read(make([]byte, 10))
+		assert.Equal(t, errStreamStalled, err)
+	})
+	t.Run("valid unstream of upload with checksum", func(t *testing.T) {
+		id := "0123456789unstream"
+		_, hooks, outbox, client := run(id, http.StatusCreated, true, func(client *SYRHandler) {
+			client.Unstream(id)
+			finish(client, id)
+			assert.Nil(t, client.Send(id, "log.tar", "0123456789", "", nil))
+		})
+		// upload is sent as usual after it is complete
+		hooks.AssertNotCalled(t, "Forwarded", id, DefaultSink, "")
+		outbox.AssertCalled(t, "Push", id, mock.Anything)
+		assert.Empty(t, client.streams)
+		client.Unstream(id)
+		assert.Equal(t, errStreamChecksum, client.streams[id].err)
+		// mark isn't kept after Stream, which doesn't start
+		assert.Nil(t, client.Stream(id, "log.tar", "0123456789", "", nil))
+		assert.Empty(t, client.streams)
+	})
+	t.Run("valid entries of streams without consumer are deleted", func(t *testing.T) {
+		cfg, _ := NewSYRConfig("http://localhost", dir, "attachment", "")
+		auth := new(SYRAuthMock)
+		client, _ := NewSYRHandler(cfg, retry, auth, new(QueueHandlerMock), new(QueueHandlerMock), logerr)
+		assert.Nil(t, client.EnableStream(time.Second))
+		client.Unstream("0123456789expired")
+		client.streams["0123456789expired"].expires = time.Now().Add(-time.Second)
+		client.Unstream("0123456789mark")
+		assert.Len(t, client.streams, 1)
+		assert.Contains(t, client.streams, "0123456789mark")
+		client.Abort("0123456789mark")
+		assert.Empty(t, client.streams)
+		s := &stream{wake: make(chan struct{}, 1), abort: make(chan struct{})}
+		client.streams["0123456789running"] = s
+		client.Unstream("0123456789running")
+		assert.Empty(t, client.streams)
+		assert.True(t, aborted(s))
+	})
+	t.Run("valid upload with checksum isn't streamed", func(t *testing.T) {
+		id := "0123456789checksum"
+		var mu sync.Mutex
+		requests := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests++
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer ts.Close()
+		cfg, _ := NewSYRConfig(ts.URL, dir, "attachment", "")
+		auth := new(SYRAuthMock)
+		auth.On("token").Return("yadro0123456789")
+		auth.On("client").Return(ts.Client())
+		client, _ := NewSYRHandler(cfg, retry, auth, new(QueueHandlerMock), new(QueueHandlerMock), logerr)
+		assert.Nil(t, client.EnableStream(time.Second))
+		info := fmt.Sprintf(`{"ID": %q, "Size": %d}`, id, len(body))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, id+".info"), []byte(info), 0644))
+		// file doesn't match checksum, it is corrupted while it is arriving
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, id), []byte(strings.Repeat("x", len(body))), 0644))
+		meta := map[string]string{"Checksum": "md5:d41d8cd98f00b204e9800998ecf8427e"}
+		assert.Nil(t, client.Stream(id, "log.tar", "0123456789", "", meta))
+		client.Progress(id)
+		assert.Empty(t, client.streams)
+		time.Sleep(50 * time.Millisecond)
+		// verification fails, upload is aborted and SYR gets nothing
+		client.Abort(id)
+		mu.Lock()
+		assert.Zero(t, requests)
+		mu.Unlock()
+	})
+	t.Run("valid client of stream", func(t *testing.T) {
+		client := streamClient(&http.Client{Timeout: time.Second})
+		assert.Zero(t, client.Timeout)
+		assert.Equal(t, time.Second, client.Transport.(*http.Transport).ResponseHeaderTimeout)
+	})
+	t.Run("valid stream is disabled", func(t *testing.T) {
+		cfg, _ := NewSYRConfig("http://test", dir, "attachment", "")
+		client, _ := NewSYRHandler(cfg, retry, new(SYRAuthMock), new(QueueHandlerMock), new(QueueHandlerMock), logerr)
+		assert.Nil(t, client.Stream("0123456789", "log.tar", "0123456789", "", nil))
+		assert.Empty(t, client.streams)
+		assert.NotNil(t, client.EnableStream(0))
+	})
+}
@@ -0,0 +1,115 @@
+package infrastructure
+
+import (
+	"bytes"
+	"net/url"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Template - parts of outbound request built from metadata of upload by
+// Go templates, e.g. "{{.SystemType}}". Path is joined to url of
+// destination, query, headers and extra fields of multipart form are set
+// by name. Unknown field of metadata fails the template
+type Template struct {
+	path    *template.Template
+	query   map[string]*template.Template
+	headers map[string]*template.Template
+	fields  map[string]*template.Template
+}
+
+// form - headers of request and extra fields of multipart form
+type form struct {
+	headers map[string]string
+	fields  map[string]string
+}
+
+// NewTemplate - create new instance of Template, empty path keeps url of
+// destination as is
+func NewTemplate(path string, query, headers, fields map[string]string) (*Template, error) {
+	t := &Template{}
+	var err error
+	if t.path, err = parseTemplate("path", path); err != nil {
+		return nil, errors.Wrap(err, "[clienttemplate] [new]")
+	}
+	if t.query, err = parseTemplates("query", query); err != nil {
+		return nil, errors.Wrap(err, "[clienttemplate] [new]")
+	}
+	if t.headers, err = parseTemplates("header", headers); err != nil {
+		return nil, errors.Wrap(err, "[clienttemplate] [new]")
+	}
+	if t.fields, err = parseTemplates("field", fields); err != nil {
+		return nil, errors.Wrap(err, "[clienttemplate] [new]")
+	}
+	return t, nil
+}
+
+// render - execute templates on metadata
+func (t *Template) render(meta map[string]string) (string, url.Values, form, error) {
+	p, err := execute(t.path, meta)
+	if err != nil {
+		return "", nil, form{}, errors.Wrap(err, "[clienttemplate] [render]")
+	}
+	query := url.Values{}
+	for name, tmpl := range t.query {
+		v, err := execute(tmpl, meta)
+		if err != nil {
+			return "", nil, form{}, errors.Wrap(err, "[clienttemplate] [render]")
+		}
+		query.Set(name, v)
+	}
+	extra := form{}
+	if extra.headers, err = executeAll(t.headers, meta); err != nil {
+		return "", nil, form{}, errors.Wrap(err, "[clienttemplate] [render]")
+	}
+	if extra.fields, err = executeAll(t.fields, meta); err != nil {
+		return "", nil, form{}, errors.Wrap(err, "[clienttemplate] [render]")
+	}
+	return p, query, extra, nil
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+func parseTemplates(kind string, texts map[string]string) (map[string]*template.Template, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]*template.Template, len(texts))
+	for name, text := range texts {
+		if name == "" {
+			return nil, errors.Errorf("%s without name", kind)
+		}
+		tmpl, err := parseTemplate(kind+" "+name, text)
+		if err != nil {
+			return nil, err
+		}
+		parsed[name] = tmpl
+	}
+	return parsed, nil
+}
+
+func execute(tmpl *template.Template, meta map[string]string) (string, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, meta); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func executeAll(tmpls map[string]*template.Template, meta map[string]string) (map[string]string, error) {
+	if len(tmpls) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string, len(tmpls))
+	for name, tmpl := range tmpls {
+		v, err := execute(tmpl, meta)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,113 @@
+package infrastructure
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewTemplate(t *testing.T) {
+	t.Run("valid template", func(t *testing.T) {
+		tmpl, err := NewTemplate("{{.SystemType}}/{{.SerialNumber}}",
+			map[string]string{"level": "{{.LogLevel}}"},
+			map[string]string{"X-Checksum": "{{.Checksum}}"},
+			map[string]string{"system": "{{.SystemType}}"})
+		assert.Nil(t, err)
+		p, query, extra, err := tmpl.render(map[string]string{
+			"SystemType": "storage", "SerialNumber": "0123456789", "LogLevel": "debug", "Checksum": "sha256:00"})
+		assert.Nil(t, err)
+		assert.Equal(t, "storage/0123456789", p)
+		assert.Equal(t, "debug", query.Get("level"))
+		assert.Equal(t, form{map[string]string{"X-Checksum": "sha256:00"}, map[string]string{"system": "storage"}}, extra)
+	})
+	t.Run("invalid syntax", func(t *testing.T) {
+		_, err := NewTemplate("{{.SystemType", nil, nil, nil)
+		assert.Error(t, err)
+		_, err = NewTemplate("", nil, nil, map[string]string{"": "{{.SystemType}}"})
+		assert.Error(t, err)
+	})
+	t.Run("invalid unknown field", func(t *testing.T) {
+		tmpl, err := NewTemplate("{{.Unknown}}", nil, nil, nil)
+		assert.Nil(t, err)
+		_, _, _, err = tmpl.render(map[string]string{"SystemType": "storage"})
+		assert.Error(t, err)
+	})
+}
+
+func TestSendTemplate(t *testing.T) {
+	const body = "hello world, it is a dump of logs"
+	id := "0123456789template"
+	dir, err := ioutil.TempDir("", "test-template")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, id), []byte(body), 0644))
+	received := make(chan *http.Request, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20)
+		received <- r
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	cfg, _ := NewSYRConfig(ts.URL+"/v2?api=2", dir, "attachment", "")
+	tmpl, err := NewTemplate("{{.SystemType}}/{{.SerialNumber}}",
+		map[string]string{"level": "{{.LogLevel}}"},
+		map[string]string{"X-Checksum": "{{.Checksum}}"},
+		map[string]string{"system": "{{.SystemType}}", "checksum": "{{.Checksum}}", "rack": `{{index . "Attributes.Rack"}}`})
+	assert.Nil(t, err)
+	assert.Nil(t, cfg.SetTemplate("", tmpl))
+	// Attributes are forwarded as fields, fields of template are kept
+	assert.Nil(t, cfg.SetAttributes(true, ""))
+	assert.Error(t, cfg.SetTemplate("", nil))
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Millisecond, 0, nil)
+	auth := new(SYRAuthMock)
+	auth.On("token").Return("yadro0123456789")
+	auth.On("client").Return(ts.Client())
+	outbox := new(QueueHandlerMock)
+	outbox.On("ReadAll").Return([][]byte{}, nil)
+	outbox.On("Push", id, mock.Anything).Return(nil)
+	outbox.On("Remove", id).Return(nil)
+	deadletter := new(QueueHandlerMock)
+	deadletter.On("Remove", id).Return(nil)
+	client, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, log.New(os.Stdout, "[test] ", log.LstdFlags))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx, nil)
+	meta := map[string]string{"SystemType": "storage", "LogLevel": "debug", "Checksum": "sha256:00",
+		"Attributes.Rack": "7", "Attributes.Firmware": "1.2", "Attributes.system": "server"}
+	assert.Nil(t, client.Send(id, "log.tar", "0123456789", "", meta))
+	// rendered request is saved in the outbox to repeat it after restart
+	outbox.AssertCalled(t, "Push", id, mock.MatchedBy(func(b []byte) bool {
+		d, _ := unmarshalData(b)
+		return d.form.fields["system"] == "storage" && d.form.headers["X-Checksum"] == "sha256:00"
+	}))
+	select {
+	case r := <-received:
+		assert.Equal(t, "/v2/storage/0123456789", r.URL.Path)
+		assert.Equal(t, "2", r.URL.Query().Get("api"))
+		assert.Equal(t, "debug", r.URL.Query().Get("level"))
+		assert.Equal(t, "sha256:00", r.Header.Get("X-Checksum"))
+		assert.Equal(t, "storage", r.FormValue("system"))
+		assert.Equal(t, "sha256:00", r.FormValue("checksum"))
+		assert.Equal(t, "7", r.FormValue("rack"))
+		assert.Equal(t, "7", r.FormValue("Rack"))
+		assert.Equal(t, "1.2", r.FormValue("Firmware"))
+		file, header, err := r.FormFile("attachment")
+		assert.Nil(t, err)
+		if err == nil {
+			b, _ := ioutil.ReadAll(file)
+			assert.Equal(t, body, string(b))
+			assert.Equal(t, "log.tar", header.Filename)
+		}
+	case <-time.After(time.Second):
+		assert.Fail(t, "template: request isn't received")
+	}
+}
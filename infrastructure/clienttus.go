@@ -0,0 +1,266 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const tusVersion = "1.0.0"
+
+// tusAttempts - limit of requests to recover offset of upload in one attempt
+const tusAttempts = 3
+
+// DefaultTusChunk - size of PATCH request of tus upload, each of them is
+// limited by timeout of client of authorization
+const DefaultTusChunk = 8 << 20
+
+// errTusGone - upload is unknown to upstream, it is created again
+var errTusGone = errors.New("upload is gone")
+
+// SetTusChunk - set size of PATCH request of tus upload, large file is sent
+// by chunks, so each request is finished within timeout of client
+func (cfg *SYRConfig) SetTusChunk(size int64) error {
+	if size <= 0 {
+		return errors.New("[clienthandler] [set tus chunk] bad argument")
+	}
+	cfg.tuschunk = size
+	return nil
+}
+
+// usesTus - return true if job is forwarded to its destination by tus protocol
+func (client *SYRHandler) usesTus(data *data) bool {
+	return client.cfg.tus[destination(data)]
+}
+
+// forwardTus - upload file as tus client. Upload is created once, its url
+// is saved in the outbox, so forwarding is resumed from offset of upstream
+// after failure or restart. Completed upload is reported as 201 Created
+// like multipart upload, any other response is returned to handle by status
+func (client *SYRHandler) forwardTus(ctx context.Context, token string, data *data) (*http.Response, error) {
+	file, err := os.Open(data.filepath)
+	if err != nil {
+		return nil, errors.Wrap(err, "[client] [tus]")
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "[client] [tus]")
+	}
+	size := info.Size()
+	httpclient := client.authorization(data).client()
+	for i := 0; i < tusAttempts; i++ {
+		if data.location == "" {
+			res, err := client.createTus(ctx, httpclient, token, data, size)
+			if err != nil || res.StatusCode != http.StatusCreated {
+				return res, err
+			}
+		}
+		offset, res, err := headTus(ctx, httpclient, token, data.location)
+		if err == errTusGone {
+			client.resetTus(data)
+			continue
+		}
+		if err != nil || res.StatusCode != http.StatusOK {
+			return res, err
+		}
+		for offset < size {
+			length := size - offset
+			if length > client.cfg.tuschunk {
+				length = client.cfg.tuschunk
+			}
+			res, err = patchTus(ctx, httpclient, token, data.location, file, offset, length)
+			if err != nil {
+				return nil, err
+			}
+			if res.StatusCode != http.StatusNoContent {
+				break
+			}
+			next, err := strconv.ParseInt(res.Header.Get("Upload-Offset"), 10, 64)
+			if err != nil || next <= offset {
+				return nil, errors.Errorf("[client] [tus] bad offset %q", res.Header.Get("Upload-Offset"))
+			}
+			offset = next
+		}
+		switch {
+		case offset >= size:
+			res.StatusCode = http.StatusCreated
+			res.Status = "201 Created"
+			return res, nil
+		case res.StatusCode == http.StatusConflict:
+			// offset is changed by upstream, it is requested again
+			continue
+		case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+			client.resetTus(data)
+			continue
+		}
+		return res, nil
+	}
+	return nil, errors.Errorf("[client] [tus] offset isn't recovered after %d attempts", tusAttempts)
+}
+
+// createTus - create upload on upstream and save its url in the outbox
+func (client *SYRHandler) createTus(ctx context.Context, httpclient *http.Client, token string, data *data, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, data.urlpath, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "[client] [tus] create")
+	}
+	metadata := []string{
+		"filename " + base64.StdEncoding.EncodeToString([]byte(data.filename)),
+		"id " + base64.StdEncoding.EncodeToString([]byte(data.id)),
+	}
+	if data.serial != "" {
+		metadata = append(metadata, "serial "+base64.StdEncoding.EncodeToString([]byte(data.serial)))
+	}
+	for k, v := range data.form.fields {
+		metadata = append(metadata, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	for k, v := range data.form.headers {
+		req.Header.Set(k, v)
+	}
+	setTusHeaders(req, token)
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("Upload-Metadata", strings.Join(metadata, ","))
+	res, err := httpclient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "[client] [tus] create")
+	}
+	closeBody(res)
+	if res.StatusCode != http.StatusCreated {
+		return res, nil
+	}
+	base, _ := url.Parse(data.urlpath)
+	location, err := base.Parse(res.Header.Get("Location"))
+	if err != nil || res.Header.Get("Location") == "" {
+		return nil, errors.Errorf("[client] [tus] create: bad location %q", res.Header.Get("Location"))
+	}
+	data.location = location.String()
+	if err := client.persist(data); err != nil {
+		client.stderr.Printf("[client] [tus]: %s\n", err)
+	}
+	return res, nil
+}
+
+// headTus - return offset of upload on upstream
+func headTus(ctx context.Context, httpclient *http.Client, token string, location string) (int64, *http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, location, nil)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "[client] [tus] head")
+	}
+	setTusHeaders(req, token)
+	res, err := httpclient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "[client] [tus] head")
+	}
+	closeBody(res)
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return 0, res, errTusGone
+	default:
+		return 0, res, nil
+	}
+	offset, err := strconv.ParseInt(res.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, nil, errors.Errorf("[client] [tus] head: bad offset %q", res.Header.Get("Upload-Offset"))
+	}
+	return offset, res, nil
+}
+
+// patchTus - send length bytes of file from offset, response of the last
+// chunk may have id of upload, so its body is kept, see remoteID
+func patchTus(ctx context.Context, httpclient *http.Client, token string, location string, file *os.File, offset int64, length int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPatch, location, io.NewSectionReader(file, offset, length))
+	if err != nil {
+		return nil, errors.Wrap(err, "[client] [tus] patch")
+	}
+	req.ContentLength = length
+	setTusHeaders(req, token)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	res, err := httpclient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "[client] [tus] patch")
+	}
+	keepBody(res)
+	return res, nil
+}
+
+// terminateTus - delete unfinished upload on upstream, it isn't resumed anymore
+func (client *SYRHandler) terminateTus(data *data) error {
+	if data.location == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodDelete, data.location, nil)
+	if err != nil {
+		return errors.Wrap(err, "[client] [tus] terminate")
+	}
+	auth := client.authorization(data)
+	setTusHeaders(req, auth.token())
+	res, err := auth.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "[client] [tus] terminate")
+	}
+	closeBody(res)
+	switch res.StatusCode {
+	case http.StatusNoContent, http.StatusNotFound, http.StatusGone:
+		data.location = ""
+		return nil
+	}
+	return errors.Errorf("[client] [tus] terminate: status code = %s", res.Status)
+}
+
+// resetTus - forget url of upload which upstream doesn't know
+func (client *SYRHandler) resetTus(data *data) {
+	data.location = ""
+	if err := client.persist(data); err != nil {
+		client.stderr.Printf("[client] [tus]: %s\n", err)
+	}
+}
+
+// persist - save state of job in the outbox
+func (client *SYRHandler) persist(data *data) error {
+	b, err := data.marshal()
+	if err != nil {
+		return errors.Wrap(err, "[client] [persist]")
+	}
+	return errors.Wrap(client.outbox.Push(data.id, b), "[client] [persist]")
+}
+
+func setTusHeaders(req *http.Request, token string) {
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+}
+
+func closeBody(res *http.Response) {
+	if res.Body != nil {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}
+}
+
+// keepBody - read response up to maxRemoteBody and close it, the body is
+// kept in memory to be read later
+func keepBody(res *http.Response) {
+	if res.Body == nil {
+		return
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxRemoteBody))
+	closeBody(res)
+	res.Body = ioutil.NopCloser(bytes.NewReader(b))
+	res.ContentLength = int64(len(b))
+}
@@ -0,0 +1,177 @@
+package infrastructure
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/tus/tusd/pkg/filestore"
+	tusd "github.com/tus/tusd/pkg/handler"
+)
+
+// tusUpstream - tus server which records requests, uploads are saved in dir
+type tusUpstream struct {
+	*httptest.Server
+	dir      string
+	mu       sync.Mutex
+	requests []string
+}
+
+func newTusUpstream(t *testing.T) *tusUpstream {
+	dir, err := ioutil.TempDir("", "test-upstream")
+	assert.Nil(t, err)
+	composer := tusd.NewStoreComposer()
+	filestore.New(dir).UseIn(composer)
+	handler, err := tusd.NewHandler(tusd.Config{BasePath: "/files/", StoreComposer: composer})
+	assert.Nil(t, err)
+	upstream := &tusUpstream{dir: dir}
+	files := http.StripPrefix("/files/", handler)
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.mu.Lock()
+		upstream.requests = append(upstream.requests, r.Method+" "+r.Header.Get("Upload-Offset"))
+		upstream.mu.Unlock()
+		files.ServeHTTP(w, r)
+	}))
+	return upstream
+}
+
+func (upstream *tusUpstream) Close() {
+	upstream.Server.Close()
+	os.RemoveAll(upstream.dir)
+}
+
+// create - create upload on upstream and send first bytes of body
+func (upstream *tusUpstream) create(t *testing.T, body string, sent int) string {
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/files/", nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(body)))
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	location := res.Header.Get("Location")
+	req, _ = http.NewRequest(http.MethodPatch, location, strings.NewReader(body[:sent]))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Offset", "0")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	res, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	upstream.mu.Lock()
+	upstream.requests = nil
+	upstream.mu.Unlock()
+	return location
+}
+
+func (upstream *tusUpstream) content(location string) string {
+	b, _ := ioutil.ReadFile(filepath.Join(upstream.dir, filepath.Base(location)))
+	return string(b)
+}
+
+func TestForwardTus(t *testing.T) {
+	const body = "hello world, it is a dump of logs"
+	id := "0123456789tus"
+	testfile := filepath.Join("/tmp", id)
+	assert.Nil(t, ioutil.WriteFile(testfile, []byte(body), 0644))
+	defer os.Remove(testfile)
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Millisecond, 0, nil)
+	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	newClient := func(upstream *tusUpstream) (*SYRHandler, *QueueHandlerMock) {
+		cfg, _ := NewSYRConfig(upstream.URL+"/files", "/tmp", "attachment", "")
+		u, _ := url.Parse(upstream.URL)
+		assert.Nil(t, cfg.UseTus(u.Host))
+		auth := new(SYRAuthMock)
+		auth.On("token").Return("yadro0123456789")
+		auth.On("client").Return(upstream.Client())
+		outbox := new(QueueHandlerMock)
+		outbox.On("ReadAll").Return([][]byte{}, nil)
+		outbox.On("Push", id, mock.Anything).Return(nil)
+		outbox.On("Remove", id).Return(nil)
+		deadletter := new(QueueHandlerMock)
+		deadletter.On("Push", id, mock.Anything).Return(nil)
+		client, _ := NewSYRHandler(cfg, retry, auth, outbox, deadletter, logerr)
+		return client, outbox
+	}
+	newData := func(upstream *tusUpstream, location string) *data {
+		return &data{id: id, serial: "0123456789", filename: "log.tar",
+			urlpath: upstream.URL + "/files/", filepath: testfile, location: location}
+	}
+
+	t.Run("valid new upload", func(t *testing.T) {
+		upstream := newTusUpstream(t)
+		defer upstream.Close()
+		client, outbox := newClient(upstream)
+		d := newData(upstream, "")
+		assert.Nil(t, client.upload(context.Background(), d, nil))
+		assert.NotEmpty(t, d.location)
+		assert.Equal(t, body, upstream.content(d.location))
+		assert.Equal(t, []string{"POST ", "HEAD ", "PATCH 0"}, upstream.requests)
+		// location is saved before file is sent
+		outbox.AssertCalled(t, "Push", id, mock.MatchedBy(func(b []byte) bool {
+			j, _ := unmarshalData(b)
+			return j.location == d.location
+		}))
+		outbox.AssertCalled(t, "Remove", id)
+	})
+	t.Run("valid upload by chunks", func(t *testing.T) {
+		upstream := newTusUpstream(t)
+		defer upstream.Close()
+		client, _ := newClient(upstream)
+		assert.Error(t, client.cfg.SetTusChunk(0))
+		assert.Nil(t, client.cfg.SetTusChunk(10))
+		hooks := new(HooksClientHandlerMock)
+		hooks.On("Forwarded", id, DefaultSink, mock.Anything).Return(false, nil)
+		assert.Nil(t, client.SetHooksHandler(hooks))
+		d := newData(upstream, "")
+		assert.Nil(t, client.upload(context.Background(), d, nil))
+		assert.Equal(t, []string{"POST ", "HEAD ", "PATCH 0", "PATCH 10", "PATCH 20", "PATCH 30"}, upstream.requests)
+		assert.Equal(t, body, upstream.content(d.location))
+		// upstream answers the last chunk without body, url is id of upload
+		hooks.AssertCalled(t, "Forwarded", id, DefaultSink, d.location)
+	})
+	t.Run("valid resume from offset", func(t *testing.T) {
+		upstream := newTusUpstream(t)
+		defer upstream.Close()
+		client, _ := newClient(upstream)
+		location := upstream.create(t, body, 11)
+		// job is restored from the outbox after restart
+		b, _ := newData(upstream, location).marshal()
+		d, err := unmarshalData(b)
+		assert.Nil(t, err)
+		assert.Nil(t, client.upload(context.Background(), d, nil))
+		assert.Equal(t, []string{"HEAD ", "PATCH 11"}, upstream.requests)
+		assert.Equal(t, body, upstream.content(location))
+	})
+	t.Run("valid gone upload is created again", func(t *testing.T) {
+		upstream := newTusUpstream(t)
+		defer upstream.Close()
+		client, _ := newClient(upstream)
+		d := newData(upstream, upstream.URL+"/files/unknown")
+		assert.Nil(t, client.upload(context.Background(), d, nil))
+		assert.Equal(t, []string{"HEAD ", "POST ", "HEAD ", "PATCH 0"}, upstream.requests)
+		assert.Equal(t, body, upstream.content(d.location))
+	})
+	t.Run("valid terminate on fail", func(t *testing.T) {
+		upstream := newTusUpstream(t)
+		defer upstream.Close()
+		client, _ := newClient(upstream)
+		location := upstream.create(t, body, 5)
+		d := newData(upstream, location)
+		assert.NotNil(t, client.fail(d))
+		assert.Equal(t, []string{"DELETE "}, upstream.requests)
+		assert.Empty(t, d.location)
+		_, err := os.Stat(filepath.Join(upstream.dir, filepath.Base(location)))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
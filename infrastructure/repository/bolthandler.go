@@ -3,117 +3,174 @@ package repository
 import (
 	"bytes"
 	"os"
+	"time"
 
+	"b.yadro.com/sys/ch-server/interfaces"
 	"github.com/boltdb/bolt"
 	"github.com/pkg/errors"
 )
 
+// lockTimeout - time to wait for lock of database file held by other process
+const lockTimeout = time.Second
+
 type logger interface {
-	Fatalf(string, ...interface{})
+	Printf(string, ...interface{})
 }
 
-// BoltHandler - implements interface DBHandler from repositories(interfaces)
+// BoltHandler - implements interface DBHandler from repositories(interfaces),
+// database is opened once for the process lifetime
 type BoltHandler struct {
-	dbname string
+	db     *bolt.DB
 	errlog logger
 }
 
-func (handler *BoltHandler) Create(bucket []byte, key []byte, value []byte) error {
-	conn := handler.open()
-	defer handler.close(conn)
-	err := conn.Update(
-		func(tx *bolt.Tx) error {
-			b, err := tx.CreateBucketIfNotExists(bucket)
-			if err != nil {
-				return errors.Wrap(err, "Create bolthandler")
-			}
-			if err := b.Put(key, value); err != nil {
-				return errors.Wrap(err, "Create bolthandler")
-			}
-			return nil
-		})
-	return err
+// boltTx - implements interface DbTx from repositories(interfaces)
+// in bolt transaction
+type boltTx struct {
+	tx *bolt.Tx
 }
 
-func (handler *BoltHandler) Get(bucket []byte, key []byte) ([]byte, error) {
-	conn := handler.open()
-	defer handler.close(conn)
-	var value bytes.Buffer
-	err := conn.View(
-		func(tx *bolt.Tx) error {
-			b := tx.Bucket(bucket)
-			if b == nil {
-				return errors.New("Name of bucket is wrong")
-			}
-			v := b.Get(key)
-			if v == nil {
-				return errors.New("Name of key is wrong")
-			}
-			value.Write(v)
-			return nil
-		})
+func (t boltTx) Create(bucket []byte, key []byte, value []byte) error {
+	b, err := t.tx.CreateBucketIfNotExists(bucket)
 	if err != nil {
-		return nil, errors.Wrap(err, "Get bolthandler")
+		return errors.Wrap(err, "Create bolthandler")
 	}
-	return value.Bytes(), nil
+	if err := b.Put(key, value); err != nil {
+		return errors.Wrap(err, "Create bolthandler")
+	}
+	return nil
 }
 
-func (handler *BoltHandler) Delete(bucket []byte, key []byte) error {
-	conn := handler.open()
-	defer handler.close(conn)
-	err := conn.Update(
-		func(tx *bolt.Tx) error {
-			b := tx.Bucket(bucket)
-			if b == nil {
-				return errors.New("Name of bucket is wrong")
-			}
-			if err := b.Delete(key); err != nil {
-				return errors.Wrap(err, "Delete bolthandler")
-			}
-			return nil
-		})
-	return err
+func (t boltTx) Get(bucket []byte, key []byte) ([]byte, error) {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil, errors.New("Get bolthandler: Name of bucket is wrong")
+	}
+	v := b.Get(key)
+	if v == nil {
+		return nil, errors.New("Get bolthandler: Name of key is wrong")
+	}
+	// Value is valid only inside transaction
+	value := make([]byte, len(v))
+	copy(value, v)
+	return value, nil
 }
 
-func (handler *BoltHandler) Keys(bucket []byte) ([][]byte, error) {
-	conn := handler.open()
-	defer handler.close(conn)
+// Delete - delete key from bucket, bucket which isn't created yet has nothing to delete
+func (t boltTx) Delete(bucket []byte, key []byte) error {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	if err := b.Delete(key); err != nil {
+		return errors.Wrap(err, "Delete bolthandler")
+	}
+	return nil
+}
+
+// Keys - return all keys of bucket, bucket which isn't created yet has no keys
+func (t boltTx) Keys(bucket []byte) ([][]byte, error) {
+	return t.Range(bucket, nil, nil)
+}
+
+// Range - return sorted keys of bucket from min inclusive to max exclusive,
+// nil bound is unlimited
+func (t boltTx) Range(bucket []byte, min []byte, max []byte) ([][]byte, error) {
 	values := make([][]byte, 0, 100)
-	err := conn.View(
-		func(tx *bolt.Tx) error {
-			b := tx.Bucket(bucket)
-			if b == nil {
-				return errors.New("Name of bucket is wrong")
-			}
-			b.ForEach(func(k, _ []byte) error {
-				key := make([]byte, len(k))
-				copy(key, k)
-				values = append(values, key)
-				return nil
-			})
-			return nil
-		})
-	if err != nil {
-		return nil, errors.Wrap(err, "Keys bolthandler")
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return values, nil
+	}
+	c := b.Cursor()
+	k, _ := c.First()
+	if min != nil {
+		k, _ = c.Seek(min)
+	}
+	for ; k != nil && (max == nil || bytes.Compare(k, max) < 0); k, _ = c.Next() {
+		key := make([]byte, len(k))
+		copy(key, k)
+		values = append(values, key)
 	}
 	return values, nil
 }
 
+// Update - run fn in read-write transaction, transaction is rolled back
+// if fn returns error
+func (handler *BoltHandler) Update(fn func(tx interfaces.DbTx) error) error {
+	return handler.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+// View - run fn in read-only transaction
+func (handler *BoltHandler) View(fn func(tx interfaces.DbTx) error) error {
+	return handler.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+// Batch - run fn in read-write transaction shared with concurrent callers,
+// fn may be run more than once and must be idempotent
+func (handler *BoltHandler) Batch(fn func(tx interfaces.DbTx) error) error {
+	return handler.db.Batch(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (handler *BoltHandler) Create(bucket []byte, key []byte, value []byte) error {
+	return handler.Batch(func(tx interfaces.DbTx) error {
+		return tx.Create(bucket, key, value)
+	})
+}
+
+func (handler *BoltHandler) Get(bucket []byte, key []byte) ([]byte, error) {
+	var value []byte
+	err := handler.View(func(tx interfaces.DbTx) error {
+		var err error
+		value, err = tx.Get(bucket, key)
+		return err
+	})
+	return value, err
+}
+
+func (handler *BoltHandler) Delete(bucket []byte, key []byte) error {
+	return handler.Batch(func(tx interfaces.DbTx) error {
+		return tx.Delete(bucket, key)
+	})
+}
+
+func (handler *BoltHandler) Keys(bucket []byte) ([][]byte, error) {
+	return handler.Range(bucket, nil, nil)
+}
+
+func (handler *BoltHandler) Range(bucket []byte, min []byte, max []byte) ([][]byte, error) {
+	var values [][]byte
+	err := handler.View(func(tx interfaces.DbTx) error {
+		var err error
+		values, err = tx.Range(bucket, min, max)
+		return err
+	})
+	return values, err
+}
+
+// Close - close database, handler can't be used after
+func (handler *BoltHandler) Close() error {
+	if err := handler.db.Close(); err != nil {
+		handler.errlog.Printf("[bolthandler] [close]: %s\n", err)
+		return errors.Wrap(err, "[bolthandler] [close]")
+	}
+	return nil
+}
+
+// NewBoltHandler - open database file, error is returned if the file
+// is locked by other process longer than lockTimeout
 func NewBoltHandler(dbfilename string, errlog logger) (*BoltHandler, error) {
 	if dbfilename == "" || errlog == nil {
 		return nil, errors.New("[bolthandler] [new handler] bad argument")
 	}
-	return &BoltHandler{dbfilename, errlog}, nil
-}
-
-func (handler *BoltHandler) open() *bolt.DB {
-	conn, err := bolt.Open(handler.dbname, os.FileMode(0664), nil)
+	db, err := bolt.Open(dbfilename, os.FileMode(0664), &bolt.Options{Timeout: lockTimeout})
 	if err != nil {
-		handler.errlog.Fatalf("from open db: %v\n", err)
+		return nil, errors.Wrap(err, "[bolthandler] [new handler]")
 	}
-	return conn
-}
-
-func (handler *BoltHandler) close(conn *bolt.DB) {
-	conn.Close()
+	return &BoltHandler{db, errlog}, nil
 }
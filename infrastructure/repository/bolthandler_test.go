@@ -1,23 +1,37 @@
 package repository
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sync"
 	"testing"
 
+	"b.yadro.com/sys/ch-server/interfaces"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestNewBoltHandler(t *testing.T) {
-	dbfile := "test.db"
+	dbfile := "/tmp/test-new.db"
 	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	defer os.Remove(dbfile)
 
 	t.Run("valid New", func(t *testing.T) {
 		d, err := NewBoltHandler(
 			dbfile,
 			logerr)
-		assert.Equal(t, d, &BoltHandler{dbfile, logerr})
 		assert.Nil(t, err)
+		assert.Equal(t, dbfile, d.db.Path())
+		assert.Equal(t, logerr, d.errlog)
+		t.Run("invalid locked DbFile", func(t *testing.T) {
+			locked, err := NewBoltHandler(
+				dbfile,
+				logerr)
+			assert.Nil(t, locked)
+			assert.NotNil(t, err)
+		})
+		assert.Nil(t, d.Close())
 	})
 
 	t.Run("invalid DbFile", func(t *testing.T) {
@@ -45,6 +59,7 @@ func TestBoltHandler(t *testing.T) {
 		logerr)
 	assert.Nil(t, err)
 	defer func() {
+		d.Close()
 		if _, err := os.Stat(dbfile); err == nil {
 			os.Remove(dbfile)
 		}
@@ -64,4 +79,67 @@ func TestBoltHandler(t *testing.T) {
 		_, err = d.Get([]byte("test"), []byte("testkey"))
 		assert.NotNil(t, err)
 	})
+	t.Run("valid keys delete of empty bucket", func(t *testing.T) {
+		keys, err := d.Keys([]byte("empty"))
+		assert.Nil(t, err)
+		assert.Empty(t, keys)
+		err = d.Delete([]byte("empty"), []byte("testkey"))
+		assert.Nil(t, err)
+	})
+	t.Run("valid range", func(t *testing.T) {
+		for _, k := range []string{"a", "b", "c", "d"} {
+			err := d.Create([]byte("range"), []byte(k), []byte{})
+			assert.Nil(t, err)
+		}
+		keys, err := d.Range([]byte("range"), []byte("b"), []byte("d"))
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, keys)
+		keys, err = d.Range([]byte("range"), nil, nil)
+		assert.Nil(t, err)
+		assert.Len(t, keys, 4)
+		keys, err = d.Range([]byte("empty"), nil, nil)
+		assert.Nil(t, err)
+		assert.Empty(t, keys)
+	})
+	t.Run("valid update", func(t *testing.T) {
+		err := d.Update(func(tx interfaces.DbTx) error {
+			if err := tx.Create([]byte("tx"), []byte("first"), []byte("1")); err != nil {
+				return err
+			}
+			return tx.Create([]byte("tx"), []byte("second"), []byte("2"))
+		})
+		assert.Nil(t, err)
+		keys, err := d.Keys([]byte("tx"))
+		assert.Nil(t, err)
+		assert.Len(t, keys, 2)
+	})
+	t.Run("invalid update is rolled back", func(t *testing.T) {
+		e := errors.New("fail")
+		err := d.Update(func(tx interfaces.DbTx) error {
+			if err := tx.Create([]byte("tx"), []byte("third"), []byte("3")); err != nil {
+				return err
+			}
+			return e
+		})
+		assert.Equal(t, e, errors.Cause(err))
+		_, err = d.Get([]byte("tx"), []byte("third"))
+		assert.NotNil(t, err)
+	})
+	t.Run("valid concurrent batch", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				err := d.Batch(func(tx interfaces.DbTx) error {
+					return tx.Create([]byte("batch"), []byte(fmt.Sprintf("%02d", i)), []byte{})
+				})
+				assert.Nil(t, err)
+			}(i)
+		}
+		wg.Wait()
+		keys, err := d.Keys([]byte("batch"))
+		assert.Nil(t, err)
+		assert.Len(t, keys, 20)
+	})
 }
@@ -21,6 +21,8 @@ func TusdConfig(composer *tusd.StoreComposer, filepath string, urlpath string) (
 	store.UseIn(composer)
 	locker := filelocker.New(filepath)
 	locker.UseIn(composer)
+	// Chunks with mismatched checksum are discarded, see ChecksumMiddleware
+	useChecksumDataStore(composer)
 
 	// Create a new HTTP handler for the tusd server by providing a configuration.
 	handler, err := tusd.NewHandler(tusd.Config{
@@ -2,6 +2,7 @@ package infrastructure
 
 import (
 	"context"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"b.yadro.com/sys/ch-server/interfaces"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	tusd "github.com/tus/tusd/pkg/handler"
 )
 
 func TestNewTusd(t *testing.T) {
@@ -118,10 +120,76 @@ func TestRestTusd(t *testing.T) {
 	// Validate(id, metadata.data)
 	hooks.AssertCalled(t, "Validate", "", "hello")
 	// Create a new handler to invoke tusd functions
-	invoke, err := NewTusdInvoke(composer)
+	invoke, err := NewTusdInvoke(composer, filepath, filepath+"quarantine")
 	assert.Nil(t, err)
 	assert.NotNil(t, invoke)
+	files, err := invoke.Files()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{s}, files)
 	if err := invoke.Remove(s); err != nil {
 		assert.FailNow(t, "unable to delete: %s, error: %v", s, err)
 	}
+	// Orphaned upload is moved to quarantine
+	assert.Nil(t, ioutil.WriteFile(filepath+"orphan", []byte("hello"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath+"orphan.info", []byte("{}"), 0644))
+	modified, err := invoke.Modified("orphan")
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), modified, time.Minute)
+	_, err = invoke.Modified("missing")
+	assert.Error(t, err)
+	assert.Nil(t, invoke.Quarantine("orphan"))
+	files, err = invoke.Files()
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+	_, err = os.Stat(filepath + "quarantine/orphan.info")
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath + "quarantine/orphan")
+	assert.Nil(t, err)
+}
+
+func TestRunHooksComplete(t *testing.T) {
+	composer := NewStoreComposer()
+	handler, err := TusdConfig(composer, "/tmp/test-complete/", "/test/")
+	assert.Nil(t, err)
+	logger := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	hooks := new(interfaces.HooksHandlerMock)
+	event := func(id string) tusd.HookEvent {
+		return tusd.HookEvent{Upload: tusd.FileInfo{ID: id}}
+	}
+	hooksTusd, _ := NewHooksTusdHandler(composer, hooks, logger)
+	assert.Error(t, hooksTusd.SetCompleteWorkers(0))
+	assert.Nil(t, hooksTusd.SetCompleteWorkers(2))
+	// Complete of the first upload hashes large file
+	hashing := make(chan time.Time)
+	hooks.On("Complete", "large").Return(nil).WaitUntil(hashing)
+	hooks.On("Complete", "small").Return(nil)
+	hooks.On("Purge", "forwarded").Return(nil)
+	term := make(chan string)
+	hooks.On("GetChanTerm").Return(term)
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, hooksTusd.RunHooks(ctx, handler))
+	}()
+	handler.CompleteUploads <- event("large")
+	// Other uploads and notifications aren't held by it
+	select {
+	case handler.CompleteUploads <- event("small"):
+	case <-time.After(time.Second):
+		assert.Fail(t, "complete: upload is held by verification of other one")
+	}
+	select {
+	case term <- "forwarded":
+	case <-time.After(time.Second):
+		assert.Fail(t, "purge: notification is held by verification")
+	}
+	time.Sleep(10 * time.Millisecond)
+	hooks.AssertCalled(t, "Complete", "small")
+	hooks.AssertCalled(t, "Purge", "forwarded")
+	close(hashing)
+	cancel()
+	wg.Wait()
+	hooks.AssertCalled(t, "Complete", "large")
 }
@@ -0,0 +1,239 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	tusd "github.com/tus/tusd/pkg/handler"
+)
+
+// StatusChecksumMismatch - status code of tus checksum extension,
+// client has to send the chunk again
+const StatusChecksumMismatch = 460
+
+var (
+	errChecksumMismatch = tusd.NewHTTPError(
+		errors.New("checksum mismatch"), StatusChecksumMismatch)
+	errChecksumIncomplete = tusd.NewHTTPError(
+		errors.New("checksum of incomplete chunk can't be verified"), StatusChecksumMismatch)
+)
+
+// checksumStreams - streams of uploads, chunk with checksum can't be
+// streamed until it is verified
+type checksumStreams interface {
+	Unstream(id string)
+}
+
+// checksumReader - hash body of request and fail at the end of body
+// if checksum doesn't match header Upload-Checksum. Body is verified
+// after Content-Length bytes, tusd doesn't read body up to EOF
+type checksumReader struct {
+	io.ReadCloser
+	hash      hash.Hash
+	expected  []byte
+	remaining int64
+	// unstream - stop stream of upload before its first byte is written
+	unstream func()
+}
+
+func (reader *checksumReader) Read(p []byte) (int, error) {
+	if reader.unstream != nil {
+		reader.unstream()
+		reader.unstream = nil
+	}
+	n, err := reader.ReadCloser.Read(p)
+	reader.hash.Write(p[:n])
+	reader.remaining -= int64(n)
+	if err == nil && reader.remaining == 0 {
+		err = io.EOF
+	}
+	switch {
+	case err == io.EOF && !bytes.Equal(reader.hash.Sum(nil), reader.expected):
+		return n, errChecksumMismatch
+	case err != nil && err != io.EOF:
+		return n, errChecksumIncomplete
+	}
+	return n, err
+}
+
+// checksumAlgorithms - value of header Tus-Checksum-Algorithm
+func checksumAlgorithms() string {
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// checksumWriter - add checksum extension to headers of tusd response
+type checksumWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *checksumWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		header := w.Header()
+		if extensions := header.Get("Tus-Extension"); extensions != "" {
+			header.Set("Tus-Extension", extensions+",checksum")
+			header.Set("Tus-Checksum-Algorithm", checksumAlgorithms())
+		}
+		if allow := header.Get("Access-Control-Allow-Headers"); allow != "" {
+			header.Set("Access-Control-Allow-Headers", allow+", Upload-Checksum")
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *checksumWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ChecksumMiddleware - implement tus checksum extension over tusd handler,
+// body of request with header Upload-Checksum is verified while it's
+// written by DataStore from TusdConfig. Upload with checksum isn't streamed
+// by streams, they may be nil
+func ChecksumMiddleware(next http.Handler, streams checksumStreams) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := &checksumWriter{ResponseWriter: w}
+		if value := r.Header.Get("Upload-Checksum"); value != "" && r.Body != nil &&
+			(r.Method == http.MethodPatch || r.Method == http.MethodPost) {
+			parts := strings.SplitN(value, " ", 2)
+			h, err := newHash(parts[0])
+			if err != nil || len(parts) != 2 {
+				http.Error(w, "unsupported checksum algorithm", http.StatusBadRequest)
+				return
+			}
+			expected, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				http.Error(w, "bad checksum", http.StatusBadRequest)
+				return
+			}
+			reader := &checksumReader{r.Body, h, expected, r.ContentLength, nil}
+			if streams != nil {
+				reader.unstream = func() {
+					// id of created upload is known by its location
+					id := path.Base(r.URL.Path)
+					if r.Method == http.MethodPost {
+						id = path.Base(writer.Header().Get("Location"))
+					}
+					streams.Unstream(id)
+				}
+			}
+			r.Body = reader
+		}
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// checksumDataStore - DataStore which discards chunk with mismatched checksum,
+// so offset of upload isn't moved and client sends the chunk again
+type checksumDataStore struct {
+	tusd.DataStore
+	terminater     tusd.TerminaterDataStore
+	concater       tusd.ConcaterDataStore
+	lengthDeferrer tusd.LengthDeferrerDataStore
+}
+
+type checksumUpload struct {
+	tusd.Upload
+}
+
+// useChecksumDataStore - wrap DataStore of composer, wrapped upload
+// is unwrapped for extensions of the DataStore
+func useChecksumDataStore(composer *tusd.StoreComposer) {
+	store := checksumDataStore{
+		composer.Core,
+		composer.Terminater,
+		composer.Concater,
+		composer.LengthDeferrer,
+	}
+	composer.UseCore(store)
+	if composer.UsesTerminater {
+		composer.UseTerminater(store)
+	}
+	if composer.UsesConcater {
+		composer.UseConcater(store)
+	}
+	if composer.UsesLengthDeferrer {
+		composer.UseLengthDeferrer(store)
+	}
+}
+
+func unwrapUpload(upload tusd.Upload) tusd.Upload {
+	if u, ok := upload.(checksumUpload); ok {
+		return u.Upload
+	}
+	return upload
+}
+
+func (store checksumDataStore) NewUpload(ctx context.Context, info tusd.FileInfo) (tusd.Upload, error) {
+	upload, err := store.DataStore.NewUpload(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	return checksumUpload{upload}, nil
+}
+
+func (store checksumDataStore) GetUpload(ctx context.Context, id string) (tusd.Upload, error) {
+	upload, err := store.DataStore.GetUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return checksumUpload{upload}, nil
+}
+
+func (store checksumDataStore) AsTerminatableUpload(upload tusd.Upload) tusd.TerminatableUpload {
+	return store.terminater.AsTerminatableUpload(unwrapUpload(upload))
+}
+
+func (store checksumDataStore) AsLengthDeclarableUpload(upload tusd.Upload) tusd.LengthDeclarableUpload {
+	return store.lengthDeferrer.AsLengthDeclarableUpload(unwrapUpload(upload))
+}
+
+func (store checksumDataStore) AsConcatableUpload(upload tusd.Upload) tusd.ConcatableUpload {
+	return checksumConcatableUpload{store.concater.AsConcatableUpload(unwrapUpload(upload))}
+}
+
+type checksumConcatableUpload struct {
+	tusd.ConcatableUpload
+}
+
+func (upload checksumConcatableUpload) ConcatUploads(ctx context.Context, partials []tusd.Upload) error {
+	uploads := make([]tusd.Upload, 0, len(partials))
+	for _, partial := range partials {
+		uploads = append(uploads, unwrapUpload(partial))
+	}
+	return upload.ConcatableUpload.ConcatUploads(ctx, uploads)
+}
+
+// WriteChunk - write chunk and truncate file to previous offset
+// if checksum of chunk is failed
+func (upload checksumUpload) WriteChunk(ctx context.Context, offset int64, src io.Reader) (int64, error) {
+	n, err := upload.Upload.WriteChunk(ctx, offset, src)
+	if err != errChecksumMismatch && err != errChecksumIncomplete {
+		return n, err
+	}
+	info, e := upload.GetInfo(ctx)
+	if e != nil {
+		return 0, errors.Wrap(e, "[tusd] [checksum] chunk isn't discarded")
+	}
+	if e := os.Truncate(info.Storage["Path"], offset); e != nil {
+		return 0, errors.Wrap(e, "[tusd] [checksum] chunk isn't discarded")
+	}
+	return 0, err
+}
@@ -0,0 +1,130 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"b.yadro.com/sys/ch-server/interfaces"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type checksumStreamsMock struct {
+	mock.Mock
+}
+
+func (m *checksumStreamsMock) Unstream(id string) {
+	m.Called(id)
+}
+
+func TestChecksumTusd(t *testing.T) {
+	composer := NewStoreComposer()
+	filepath := "/tmp/test-checksum/"
+	handler, err := TusdConfig(
+		composer,
+		filepath,
+		"/test/")
+	assert.Nil(t, err)
+	hooks := new(interfaces.HooksHandlerMock)
+	tusd, err := NewHooksTusdHandler(
+		composer,
+		hooks,
+		log.New(os.Stdout, "[test] ", log.LstdFlags))
+	assert.Nil(t, err)
+	if err := os.MkdirAll(filepath, 0777); err != nil {
+		assert.FailNow(t, "unable to make dir: %v", err)
+	}
+	defer os.RemoveAll(filepath)
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	hooks.On("Validate", mock.Anything, mock.Anything).Return(nil)
+	hooks.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	hooks.On("Complete", mock.Anything).Return(nil)
+	hooks.On("Progress", mock.Anything).Return(nil)
+	hooks.On("GetChanTerm").Return(make(chan string))
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := tusd.RunHooks(ctx, handler)
+		assert.Nil(t, err, "unable to run hooks.")
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	streams := new(checksumStreamsMock)
+	streams.On("Unstream", mock.Anything).Return()
+	checksummed := ChecksumMiddleware(handler, streams)
+	const body = "hello world!"
+	sum := sha1.Sum([]byte(body))
+
+	t.Run("valid extension", func(t *testing.T) {
+		res := (&httpTest{
+			Method: http.MethodOptions,
+			Code:   http.StatusOK,
+			ResHeader: map[string]string{
+				"Tus-Checksum-Algorithm": "md5,sha1,sha256",
+			},
+		}).Run(checksummed, t)
+		assert.Contains(t, res.Header().Get("Tus-Extension"), "checksum")
+	})
+	res := (&httpTest{
+		Method: http.MethodPost,
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "12",
+			"Upload-Metadata": "data aGVsbG8=, filename d29ybGQ=",
+		},
+		Code: http.StatusCreated,
+	}).Run(checksummed, t)
+	id := strings.TrimPrefix(res.Header().Get("Location"), "http://tus.io/test/")
+	patch := func(checksum string, code int, offset string) {
+		(&httpTest{
+			Method: http.MethodPatch,
+			URL:    id,
+			ReqHeader: map[string]string{
+				"Tus-Resumable":   "1.0.0",
+				"Upload-Offset":   "0",
+				"Content-Type":    "application/offset+octet-stream",
+				"Upload-Checksum": checksum,
+			},
+			ReqBody:   strings.NewReader(body),
+			Code:      code,
+			ResHeader: map[string]string{"Upload-Offset": offset},
+		}).Run(checksummed, t)
+	}
+	head := func(offset string) {
+		(&httpTest{
+			Method:    http.MethodHead,
+			URL:       id,
+			ReqHeader: map[string]string{"Tus-Resumable": "1.0.0"},
+			Code:      http.StatusOK,
+			ResHeader: map[string]string{"Upload-Offset": offset},
+		}).Run(checksummed, t)
+	}
+
+	t.Run("invalid algorithm", func(t *testing.T) {
+		patch("crc32 AAAAAA==", http.StatusBadRequest, "")
+		head("0")
+	})
+	t.Run("invalid checksum", func(t *testing.T) {
+		patch("sha1 "+base64.StdEncoding.EncodeToString(make([]byte, sha1.Size)), StatusChecksumMismatch, "")
+		head("0")
+	})
+	t.Run("valid checksum", func(t *testing.T) {
+		patch("sha1 "+base64.StdEncoding.EncodeToString(sum[:]), http.StatusNoContent, "12")
+		head("12")
+		// upload with checksum isn't streamed
+		streams.AssertCalled(t, "Unstream", id)
+	})
+	invoke, err := NewTusdInvoke(composer, filepath, filepath+"quarantine")
+	assert.Nil(t, err)
+	assert.Nil(t, invoke.Remove(id))
+}
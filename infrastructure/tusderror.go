@@ -0,0 +1,47 @@
+package infrastructure
+
+import (
+	"net/http"
+)
+
+// ErrorMiddleware - answer errors of tusd with JSON body, e.g. rejection of
+// metadata by pre-create hook, by Content-Type application/json. tusd
+// answers all errors as text
+func ErrorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := &errorWriter{ResponseWriter: w}
+		next.ServeHTTP(writer, r)
+		writer.flush()
+	})
+}
+
+// errorWriter - delay status of error until body is written to choose
+// its Content-Type
+type errorWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *errorWriter) WriteHeader(status int) {
+	if status < http.StatusBadRequest {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *errorWriter) Write(b []byte) (int, error) {
+	if w.status != 0 && len(b) > 0 && b[0] == '{' {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.flush()
+	return w.ResponseWriter.Write(b)
+}
+
+// flush - write delayed status of error
+func (w *errorWriter) flush() {
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.status = 0
+	}
+}
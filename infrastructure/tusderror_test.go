@@ -0,0 +1,64 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"testing"
+
+	"b.yadro.com/sys/ch-server/interfaces"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorTusd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-tusderror")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	composer := NewStoreComposer()
+	handler, err := TusdConfig(composer, dir, "/test/")
+	assert.Nil(t, err)
+	hooks := new(interfaces.HooksHandlerMock)
+	_, err = NewHooksTusdHandler(composer, hooks, log.New(os.Stdout, "[test] ", log.LstdFlags))
+	assert.Nil(t, err)
+	rejected := &interfaces.ValidationError{
+		Status: http.StatusUnprocessableEntity,
+		Reason: "unprocessable entity",
+		Fields: []interfaces.FieldError{{Field: "SerialNumber", Reason: "may not be empty"}},
+	}
+	hooks.On("Validate", "", "invalid").Return(rejected)
+	hooks.On("Validate", "", "fault").Return(os.ErrPermission)
+	post := func(data string, code int) *httpTest {
+		return &httpTest{
+			Method: http.MethodPost,
+			ReqHeader: map[string]string{
+				"Tus-Resumable":   "1.0.0",
+				"Upload-Length":   "12",
+				"Upload-Metadata": "data " + data,
+			},
+			Code: code,
+		}
+	}
+
+	t.Run("invalid metadata is answered by JSON", func(t *testing.T) {
+		// base64 of "invalid"
+		res := post("aW52YWxpZA==", http.StatusUnprocessableEntity).Run(ErrorMiddleware(handler), t)
+		assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+		var body interfaces.ValidationError
+		assert.Nil(t, json.Unmarshal(res.Body.Bytes(), &body))
+		assert.Equal(t, *rejected, body)
+	})
+	t.Run("invalid fault of server is answered by text", func(t *testing.T) {
+		// base64 of "fault"
+		res := post("ZmF1bHQ=", http.StatusInternalServerError).Run(ErrorMiddleware(handler), t)
+		assert.Equal(t, "text/plain; charset=utf-8", res.Header().Get("Content-Type"))
+	})
+	t.Run("valid success isn't changed", func(t *testing.T) {
+		(&httpTest{
+			Method:    http.MethodOptions,
+			Code:      http.StatusOK,
+			ResHeader: map[string]string{"Tus-Resumable": "1.0.0"},
+		}).Run(ErrorMiddleware(handler), t)
+	})
+}
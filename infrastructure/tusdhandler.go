@@ -2,6 +2,7 @@ package infrastructure
 
 import (
 	"context"
+	"sync"
 
 	"github.com/pkg/errors"
 	tusd "github.com/tus/tusd/pkg/handler"
@@ -16,6 +17,7 @@ type hooksHandler interface {
 	Progress(id string) error
 	Terminate(id string) error
 	Complete(id string) error
+	Purge(id string) error
 	GetChanTerm() chan string
 }
 
@@ -27,12 +29,19 @@ const (
 	hookPostReceive   hookType = "post-receive"
 	hookPostCreate    hookType = "post-create"
 	hookPreCreate     hookType = "pre-create"
-	hookTerminate     hookType = "pre-terminate"
+	// hookPostPurge - file is deleted by server after forwarding, it isn't
+	// terminated by client
+	hookPostPurge hookType = "post-purge"
 )
 
 type HooksTusdHandler struct {
-	hooks  hooksHandler
-	stderr logger
+	hooks    hooksHandler
+	stderr   logger
+	external map[hookType][]externalHook
+	// workers - number of goroutines which handle complete uploads
+	workers int
+	// running - external hooks which are run in background
+	running chan struct{}
 }
 
 type hookDataStore struct {
@@ -42,18 +51,34 @@ type hookDataStore struct {
 
 func (store hookDataStore) NewUpload(ctx context.Context, info tusd.FileInfo) (upload tusd.Upload, err error) {
 	if err := store.tusdhandler.invokeHook(hookPreCreate, info); err != nil {
+		// Rejection of metadata or by external hook is answered by its status
+		if _, ok := err.(tusd.HTTPError); ok {
+			return nil, err
+		}
 		return nil, errors.Wrapf(err, "hook %s", hookPreCreate)
 	}
 	return store.DataStore.NewUpload(ctx, info)
 }
 
 func (handler *HooksTusdHandler) RunHooks(ctx context.Context, notify *tusd.Handler) error {
+	// Complete uploads are verified by workers, so hashing of large file
+	// doesn't hold other notifications. Upload is complete after it is
+	// created, RunHooks returns after running verifications are finished
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	workers := make(chan struct{}, handler.workers)
 	for {
 		select {
 		case info := <-notify.CompleteUploads:
-			if err := handler.invokeHook(hookPostFinish, info.Upload); err != nil {
-				handler.stderr.Printf("notify %s: %s", hookPostFinish, err)
-			}
+			wg.Add(1)
+			go func(info tusd.FileInfo) {
+				defer wg.Done()
+				workers <- struct{}{}
+				defer func() { <-workers }()
+				if err := handler.invokeHook(hookPostFinish, info); err != nil {
+					handler.stderr.Printf("notify %s: %s", hookPostFinish, err)
+				}
+			}(info.Upload)
 		case info := <-notify.TerminatedUploads:
 			if err := handler.invokeHook(hookPostTerminate, info.Upload); err != nil {
 				handler.stderr.Printf("notify %s: %s", hookPostTerminate, err)
@@ -68,8 +93,8 @@ func (handler *HooksTusdHandler) RunHooks(ctx context.Context, notify *tusd.Hand
 			}
 		case id := <-handler.hooks.GetChanTerm():
 			fileinfo := tusd.FileInfo{ID: id}
-			if err := handler.invokeHook(hookTerminate, fileinfo); err != nil {
-				handler.stderr.Printf("notify %s: %s", hookTerminate, err)
+			if err := handler.invokeHook(hookPostPurge, fileinfo); err != nil {
+				handler.stderr.Printf("notify %s: %s", hookPostPurge, err)
 			}
 		case <-ctx.Done():
 			return nil
@@ -77,6 +102,16 @@ func (handler *HooksTusdHandler) RunHooks(ctx context.Context, notify *tusd.Hand
 	}
 }
 
+// SetCompleteWorkers - set number of complete uploads which are verified
+// and sent concurrently, it has to be called before RunHooks
+func (handler *HooksTusdHandler) SetCompleteWorkers(workers int) error {
+	if workers <= 0 {
+		return errors.New("[tusdhandler] [set workers] bad argument")
+	}
+	handler.workers = workers
+	return nil
+}
+
 func getMetaData(info tusd.FileInfo, key string) string {
 	return info.MetaData[key]
 }
@@ -88,7 +123,12 @@ func NewHooksTusdHandler(
 	if composer == nil || handler == nil || errlog == nil {
 		return nil, errors.New("[tusdhandler] [new] bad argument")
 	}
-	tusdhandler := &HooksTusdHandler{handler, errlog}
+	tusdhandler := &HooksTusdHandler{
+		hooks:   handler,
+		stderr:  errlog,
+		workers: 1,
+		running: make(chan struct{}, maxBackgroundHooks),
+	}
 	composer.UseCore(hookDataStore{
 		composer.Core,
 		tusdhandler,
@@ -100,9 +140,17 @@ func (handler *HooksTusdHandler) invokeHook(typ hookType, info tusd.FileInfo) er
 	if handler.hooks == nil {
 		return errors.New("[tusd] [hooks] Hooks handler is nil")
 	}
+	if typ != hookPreCreate {
+		if err := handler.invokeExternal(typ, info); err != nil {
+			handler.stderr.Printf("notify %s: %s", typ, err)
+		}
+	}
 	switch typ {
 	case hookPreCreate:
-		return handler.hooks.Validate(info.ID, getMetaData(info, "data"))
+		if err := handler.hooks.Validate(info.ID, getMetaData(info, "data")); err != nil {
+			return err
+		}
+		return handler.invokeExternal(typ, info)
 	case hookPostCreate:
 		return handler.hooks.Create(info.ID, getMetaData(info, "data"), getMetaData(info, "filename"))
 	case hookPostFinish:
@@ -111,8 +159,9 @@ func (handler *HooksTusdHandler) invokeHook(typ hookType, info tusd.FileInfo) er
 		return handler.hooks.Terminate(info.ID)
 	case hookPostReceive:
 		return handler.hooks.Progress(info.ID)
-	case hookTerminate:
-		return handler.hooks.Terminate(info.ID)
+	case hookPostPurge:
+		// File is terminated by server after forwarding, metadata is kept
+		return handler.hooks.Purge(info.ID)
 	}
 	return nil
 }
@@ -0,0 +1,231 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	tusd "github.com/tus/tusd/pkg/handler"
+)
+
+const (
+	// maxHookOutput - limit of output of external hook which is read
+	maxHookOutput = 64 << 10
+	// maxBackgroundHooks - limit of external hooks which are run in
+	// background, hook is skipped if limit is reached
+	maxBackgroundHooks = 16
+)
+
+// externalHook - script or http endpoint which is invoked on hook of tusd,
+// it gets hookRequest as JSON
+type externalHook interface {
+	invoke(ctx context.Context, typ hookType, info tusd.FileInfo, payload []byte) (hookResult, error)
+}
+
+// hookRequest - JSON which is sent to external hook, Metadata is decoded
+// metadata of upload from its "data" field
+type hookRequest struct {
+	Type     hookType
+	Upload   tusd.FileInfo
+	Metadata json.RawMessage `json:",omitempty"`
+}
+
+// hookResponse - optional JSON answer of external hook, blocking hook
+// rejects upload with status and message
+type hookResponse struct {
+	Reject  bool   `json:"reject"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// hookResult - answer of external hook: rejected upload is answered by
+// status and message
+type hookResult struct {
+	reject  bool
+	status  int
+	message string
+}
+
+// parseHookResult - read answer of hook from its output, output which
+// isn't JSON is message
+func parseHookResult(reject bool, status int, output []byte) hookResult {
+	result := hookResult{reject: reject, status: status, message: strings.TrimSpace(string(output))}
+	var res hookResponse
+	if err := json.Unmarshal(output, &res); err == nil {
+		result.reject = result.reject || res.Reject
+		result.message = res.Message
+		if res.Status >= 400 && res.Status < 600 {
+			result.status = res.Status
+		}
+	}
+	if result.status < 400 || result.status >= 600 {
+		result.status = http.StatusBadRequest
+	}
+	if result.message == "" {
+		result.message = "upload is rejected by hook"
+	}
+	return result
+}
+
+// CommandHook - executable which is run with type of hook as argument,
+// it reads hookRequest from stdin. Upload is rejected by non-zero exit code
+// or by hookResponse printed to stdout
+type CommandHook struct {
+	command string
+	timeout time.Duration
+}
+
+// NewCommandHook - create new instance of CommandHook
+func NewCommandHook(command string, timeout time.Duration) (*CommandHook, error) {
+	if command == "" || timeout <= 0 {
+		return nil, errors.New("[tusdhook] [new command] bad argument")
+	}
+	return &CommandHook{command, timeout}, nil
+}
+
+func (hook *CommandHook) invoke(ctx context.Context, typ hookType, info tusd.FileInfo, payload []byte) (hookResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, hook.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, hook.command, string(typ))
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"TUS_HOOK="+string(typ),
+		"TUS_ID="+info.ID,
+		"TUS_SIZE="+strconv.FormatInt(info.Size, 10),
+		"TUS_OFFSET="+strconv.FormatInt(info.Offset, 10))
+	var stdout bytes.Buffer
+	cmd.Stdout = &limitedBuffer{&stdout, maxHookOutput}
+	err := cmd.Run()
+	if _, exited := err.(*exec.ExitError); err != nil && (!exited || ctx.Err() != nil) {
+		return hookResult{}, errors.Wrapf(err, "[tusdhook] [command] %s", hook.command)
+	}
+	return parseHookResult(err != nil, http.StatusBadRequest, stdout.Bytes()), nil
+}
+
+// HTTPHook - endpoint which gets hookRequest by POST with header Hook-Name.
+// Upload is rejected by status 4xx or by hookResponse in body
+type HTTPHook struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPHook - create new instance of HTTPHook
+func NewHTTPHook(url string, timeout time.Duration) (*HTTPHook, error) {
+	if url == "" || timeout <= 0 {
+		return nil, errors.New("[tusdhook] [new http] bad argument")
+	}
+	return &HTTPHook{url, &http.Client{Timeout: timeout}}, nil
+}
+
+func (hook *HTTPHook) invoke(ctx context.Context, typ hookType, info tusd.FileInfo, payload []byte) (hookResult, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.url, bytes.NewReader(payload))
+	if err != nil {
+		return hookResult{}, errors.Wrap(err, "[tusdhook] [http]")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Hook-Name", string(typ))
+	res, err := hook.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return hookResult{}, errors.Wrap(err, "[tusdhook] [http]")
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxHookOutput))
+	if res.StatusCode >= http.StatusInternalServerError {
+		return hookResult{}, errors.Errorf("[tusdhook] [http] %s: status code = %s", hook.url, res.Status)
+	}
+	return parseHookResult(res.StatusCode >= 300, res.StatusCode, body), nil
+}
+
+// AddHook - invoke external hook on hook of tusd: pre-create, post-create,
+// post-receive, post-finish, post-terminate or post-purge (file is deleted
+// by server after forwarding). Only pre-create is blocking and may reject
+// upload, other hooks are run in background
+func (handler *HooksTusdHandler) AddHook(typ string, hook externalHook) error {
+	if hook == nil {
+		return errors.New("[tusdhandler] [add hook] bad argument")
+	}
+	switch hookType(typ) {
+	case hookPreCreate, hookPostCreate, hookPostReceive, hookPostFinish, hookPostTerminate, hookPostPurge:
+	default:
+		return errors.Errorf("[tusdhandler] [add hook] unknown hook %q", typ)
+	}
+	if handler.external == nil {
+		handler.external = make(map[hookType][]externalHook)
+	}
+	handler.external[hookType(typ)] = append(handler.external[hookType(typ)], hook)
+	return nil
+}
+
+// invokeExternal - run external hooks of type, blocking hook returns
+// tusd.HTTPError with status and message of rejection
+func (handler *HooksTusdHandler) invokeExternal(typ hookType, info tusd.FileInfo) error {
+	hooks := handler.external[typ]
+	if len(hooks) == 0 {
+		return nil
+	}
+	request := hookRequest{Type: typ, Upload: info}
+	if data := getMetaData(info, "data"); json.Valid([]byte(data)) {
+		request.Metadata = json.RawMessage(data)
+	}
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "[tusdhandler] [external]")
+	}
+	if typ != hookPreCreate {
+		for _, hook := range hooks {
+			select {
+			case handler.running <- struct{}{}:
+			default:
+				handler.stderr.Printf("[tusdhandler] [external] hook %s: id = %s is skipped, %d hooks are running\n",
+					typ, info.ID, maxBackgroundHooks)
+				continue
+			}
+			go func(hook externalHook) {
+				defer func() { <-handler.running }()
+				if _, err := hook.invoke(context.Background(), typ, info, payload); err != nil {
+					handler.stderr.Printf("[tusdhandler] [external] hook %s: id = %s: %s\n", typ, info.ID, err)
+				}
+			}(hook)
+		}
+		return nil
+	}
+	for _, hook := range hooks {
+		result, err := hook.invoke(context.Background(), typ, info, payload)
+		if err != nil {
+			// Upload isn't accepted without decision of hook
+			handler.stderr.Printf("[tusdhandler] [external] hook %s: id = %s: %s\n", typ, info.ID, err)
+			return tusd.NewHTTPError(errors.New("upload isn't validated by hook"), http.StatusInternalServerError)
+		}
+		if result.reject {
+			return tusd.NewHTTPError(errors.New(result.message), result.status)
+		}
+	}
+	return nil
+}
+
+// limitedBuffer - writer which drops output over limit, command isn't
+// blocked by full pipe
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (w *limitedBuffer) Write(p []byte) (int, error) {
+	if rest := w.limit - w.buf.Len(); rest > 0 {
+		if len(p) > rest {
+			w.buf.Write(p[:rest])
+		} else {
+			w.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
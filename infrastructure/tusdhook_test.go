@@ -0,0 +1,197 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"b.yadro.com/sys/ch-server/interfaces"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	tusd "github.com/tus/tusd/pkg/handler"
+)
+
+func TestExternalHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-tusdhook")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	// script - write command hook which prints answer and exits by code
+	script := func(name, answer string, code int) string {
+		path := filepath.Join(dir, name)
+		body := "#!/bin/sh\ncat > " + path + ".stdin\necho '" + answer + "'\nexit " + strconv.Itoa(code) + "\n"
+		assert.Nil(t, ioutil.WriteFile(path, []byte(body), 0755))
+		return path
+	}
+	logger := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	info := tusd.FileInfo{
+		ID:       "0123456789hook",
+		Size:     12,
+		MetaData: tusd.MetaData{"data": `{"SessionID":"0123456789","Name":"log.tar"}`, "filename": "log.tar"},
+	}
+	// handler - tusd handler with hooks, in-process hooks accept upload
+	handler := func() (*HooksTusdHandler, *interfaces.HooksHandlerMock) {
+		hooks := new(interfaces.HooksHandlerMock)
+		hooks.On("Validate", mock.Anything, mock.Anything).Return(nil)
+		hooks.On("Complete", mock.Anything).Return(nil)
+		tusdhandler, err := NewHooksTusdHandler(NewStoreComposer(), hooks, logger)
+		assert.Nil(t, err)
+		return tusdhandler, hooks
+	}
+
+	t.Run("valid command hook gets upload and metadata", func(t *testing.T) {
+		tusdhandler, _ := handler()
+		command, _ := NewCommandHook(script("accept", "", 0), time.Second)
+		assert.Nil(t, tusdhandler.AddHook("pre-create", command))
+		assert.Nil(t, tusdhandler.invokeHook(hookPreCreate, info))
+		stdin, err := ioutil.ReadFile(filepath.Join(dir, "accept.stdin"))
+		assert.Nil(t, err)
+		var req struct {
+			Type     string
+			Upload   tusd.FileInfo
+			Metadata map[string]string
+		}
+		assert.Nil(t, json.Unmarshal(stdin, &req))
+		assert.Equal(t, "pre-create", req.Type)
+		assert.Equal(t, info.ID, req.Upload.ID)
+		assert.Equal(t, int64(12), req.Upload.Size)
+		assert.Equal(t, "log.tar", req.Metadata["Name"])
+	})
+	t.Run("invalid upload is rejected by command with status", func(t *testing.T) {
+		tusdhandler, _ := handler()
+		command, _ := NewCommandHook(script("reject", `{"status": 403, "message": "quota exceeded"}`, 1), time.Second)
+		assert.Nil(t, tusdhandler.AddHook("pre-create", command))
+		err := tusdhandler.invokeHook(hookPreCreate, info)
+		if assert.Implements(t, (*tusd.HTTPError)(nil), err) {
+			assert.Equal(t, http.StatusForbidden, err.(tusd.HTTPError).StatusCode())
+			assert.Equal(t, "quota exceeded", err.Error())
+		}
+		// Text answer is message of rejection with default status
+		command, _ = NewCommandHook(script("text", "bad serial", 2), time.Second)
+		tusdhandler, _ = handler()
+		assert.Nil(t, tusdhandler.AddHook("pre-create", command))
+		err = tusdhandler.invokeHook(hookPreCreate, info)
+		if assert.Implements(t, (*tusd.HTTPError)(nil), err) {
+			assert.Equal(t, http.StatusBadRequest, err.(tusd.HTTPError).StatusCode())
+			assert.Equal(t, "bad serial", err.Error())
+		}
+	})
+	t.Run("invalid upload is rejected by http hook", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "pre-create", r.Header.Get("Hook-Name"))
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte("unknown system"))
+		}))
+		defer ts.Close()
+		tusdhandler, _ := handler()
+		hook, _ := NewHTTPHook(ts.URL, time.Second)
+		assert.Nil(t, tusdhandler.AddHook("pre-create", hook))
+		err := tusdhandler.invokeHook(hookPreCreate, info)
+		if assert.Implements(t, (*tusd.HTTPError)(nil), err) {
+			assert.Equal(t, http.StatusUnprocessableEntity, err.(tusd.HTTPError).StatusCode())
+			assert.Equal(t, "unknown system", err.Error())
+		}
+	})
+	t.Run("invalid unavailable hook rejects upload", func(t *testing.T) {
+		tusdhandler, _ := handler()
+		command, _ := NewCommandHook(filepath.Join(dir, "missing"), time.Second)
+		assert.Nil(t, tusdhandler.AddHook("pre-create", command))
+		err := tusdhandler.invokeHook(hookPreCreate, info)
+		if assert.Implements(t, (*tusd.HTTPError)(nil), err) {
+			assert.Equal(t, http.StatusInternalServerError, err.(tusd.HTTPError).StatusCode())
+		}
+	})
+	t.Run("valid post hook is run in background", func(t *testing.T) {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer wg.Done()
+			assert.Equal(t, "post-finish", r.Header.Get("Hook-Name"))
+			// Answer of post hook doesn't fail upload
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer ts.Close()
+		tusdhandler, hooks := handler()
+		hook, _ := NewHTTPHook(ts.URL, time.Second)
+		assert.Nil(t, tusdhandler.AddHook("post-finish", hook))
+		assert.Nil(t, tusdhandler.invokeHook(hookPostFinish, info))
+		wg.Wait()
+		hooks.AssertCalled(t, "Complete", info.ID)
+	})
+	t.Run("valid post hooks are limited", func(t *testing.T) {
+		release := make(chan struct{})
+		var mu sync.Mutex
+		calls := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			<-release
+		}))
+		defer ts.Close()
+		tusdhandler, hooks := handler()
+		hooks.On("Purge", info.ID).Return(nil)
+		hook, _ := NewHTTPHook(ts.URL, time.Second)
+		assert.Nil(t, tusdhandler.AddHook("post-purge", hook))
+		for i := 0; i < maxBackgroundHooks+4; i++ {
+			assert.Nil(t, tusdhandler.invokeHook(hookPostPurge, info))
+		}
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return calls == maxBackgroundHooks
+		}, time.Second, 5*time.Millisecond)
+		close(release)
+		assert.Eventually(t, func() bool { return len(tusdhandler.running) == 0 }, time.Second, 5*time.Millisecond)
+		mu.Lock()
+		assert.Equal(t, maxBackgroundHooks, calls)
+		mu.Unlock()
+	})
+	t.Run("valid rejection is answered by tusd", func(t *testing.T) {
+		path := filepath.Join(dir, "uploads")
+		assert.Nil(t, os.MkdirAll(path, 0777))
+		composer := NewStoreComposer()
+		tusdHandler, err := TusdConfig(composer, path, "/test/")
+		assert.Nil(t, err)
+		hooks := new(interfaces.HooksHandlerMock)
+		hooks.On("Validate", mock.Anything, mock.Anything).Return(nil)
+		tusdhandler, err := NewHooksTusdHandler(composer, hooks, logger)
+		assert.Nil(t, err)
+		command, _ := NewCommandHook(script("forbid", `{"status": 403, "message": "quota exceeded"}`, 1), time.Second)
+		assert.Nil(t, tusdhandler.AddHook("pre-create", command))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		hooks.On("GetChanTerm").Return(make(chan string))
+		go tusdhandler.RunHooks(ctx, tusdHandler)
+		res := (&httpTest{
+			Method: "POST",
+			ReqHeader: map[string]string{
+				"Tus-Resumable":   "1.0.0",
+				"Upload-Length":   "12",
+				"Upload-Metadata": "data aGVsbG8=, filename d29ybGQ=",
+			},
+			Code: http.StatusForbidden,
+		}).Run(tusdHandler, t)
+		assert.True(t, strings.HasPrefix(res.Body.String(), "quota exceeded"))
+	})
+	t.Run("invalid arguments", func(t *testing.T) {
+		_, err := NewCommandHook("", time.Second)
+		assert.Error(t, err)
+		_, err = NewHTTPHook("http://hooks.com", 0)
+		assert.Error(t, err)
+		tusdhandler, _ := handler()
+		hook, _ := NewHTTPHook("http://hooks.com", time.Second)
+		assert.Error(t, tusdhandler.AddHook("pre-finish", hook))
+		assert.Error(t, tusdhandler.AddHook("pre-terminate", hook))
+		assert.Error(t, tusdhandler.AddHook("pre-create", nil))
+	})
+}
@@ -2,6 +2,13 @@ package infrastructure
 
 import (
 	"context"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	tusd "github.com/tus/tusd/pkg/handler"
@@ -12,7 +19,9 @@ var ctx = context.Background() // TODO: pass through ctx from main().
 // TusdInvoke - wrapper for tusd.StoreComposer
 // implement interface InvokeHandler from interfaces/repositories
 type TusdInvoke struct {
-	composer *tusd.StoreComposer
+	composer   *tusd.StoreComposer
+	filepath   string
+	quarantine string
 }
 
 // Remove - implement delete files from tusd
@@ -43,10 +52,74 @@ func (invoke *TusdInvoke) Remove(id string) error {
 	return nil
 }
 
-// NewTusdInvoke - create instance of InvokeHandler interface from interfaces/repositories
-func NewTusdInvoke(composer *tusd.StoreComposer) (*TusdInvoke, error) {
-	if composer == nil {
+// Checksum - hash uploaded file, return hex encoded digest
+func (invoke *TusdInvoke) Checksum(id string, algorithm string) (string, error) {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return "", errors.Wrap(err, "[tusdinvoke] [checksum]")
+	}
+	upload, err := invoke.composer.Core.GetUpload(ctx, id)
+	if err != nil {
+		return "", errors.Wrap(err, "[tusdinvoke] [checksum]")
+	}
+	reader, err := upload.GetReader(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "[tusdinvoke] [checksum]")
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", errors.Wrap(err, "[tusdinvoke] [checksum]")
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Files - return id of all uploads in filestore, upload is a file with
+// info file "<id>.info" beside it
+func (invoke *TusdInvoke) Files() ([]string, error) {
+	infos, err := ioutil.ReadDir(invoke.filepath)
+	if err != nil {
+		return nil, errors.Wrap(err, "[tusdinvoke] [files]")
+	}
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".info") {
+			ids = append(ids, strings.TrimSuffix(info.Name(), ".info"))
+		}
+	}
+	return ids, nil
+}
+
+// Modified - return time of last change of info file of upload, tusd
+// writes it when upload is created
+func (invoke *TusdInvoke) Modified(id string) (time.Time, error) {
+	info, err := os.Stat(filepath.Join(invoke.filepath, id+".info"))
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "[tusdinvoke] [modified]")
+	}
+	return info.ModTime(), nil
+}
+
+// Quarantine - move file of upload and its info file to quarantine directory
+func (invoke *TusdInvoke) Quarantine(id string) error {
+	if err := os.MkdirAll(invoke.quarantine, 0775); err != nil {
+		return errors.Wrap(err, "[tusdinvoke] [quarantine]")
+	}
+	for _, name := range []string{id, id + ".info"} {
+		err := os.Rename(filepath.Join(invoke.filepath, name), filepath.Join(invoke.quarantine, name))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "[tusdinvoke] [quarantine]")
+		}
+	}
+	return nil
+}
+
+// NewTusdInvoke - create instance of InvokeHandler interface from interfaces/repositories,
+// filepath is directory of filestore, orphaned files are moved to quarantine directory
+func NewTusdInvoke(composer *tusd.StoreComposer, filepath string, quarantine string) (*TusdInvoke, error) {
+	if composer == nil || filepath == "" || quarantine == "" {
 		return nil, errors.New("[tusdinvoke] [new] bad argument")
 	}
-	return &TusdInvoke{composer}, nil
+	return &TusdInvoke{composer, filepath, quarantine}, nil
 }
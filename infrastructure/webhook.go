@@ -0,0 +1,244 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Events of upload which are sent to webhooks
+const (
+	EventCreated    = "created"
+	EventComplete   = "complete"
+	EventForwarded  = "forwarded"
+	EventFailed     = "failed"
+	EventTerminated = "terminated"
+)
+
+const (
+	// webhookQueue - limit of events which wait for delivery, event is
+	// dropped if queue is full
+	webhookQueue = 100
+	// DefaultWebhookWorkers - events which are delivered concurrently
+	DefaultWebhookWorkers = 4
+)
+
+// WebhookEvent - body of request to webhook
+type WebhookEvent struct {
+	Event     string            `json:"event"`
+	ID        string            `json:"id"`
+	Timestamp string            `json:"timestamp"`
+	State     string            `json:"state,omitempty"`
+	Sink      string            `json:"sink,omitempty"`
+	Size      int64             `json:"size,omitempty"`
+	Offset    int64             `json:"offset,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// webhook - endpoint which is notified about events, empty events
+// means all of them
+type webhook struct {
+	url    string
+	secret string
+	events map[string]bool
+}
+
+// notification - event which is delivered to webhook
+type notification struct {
+	hook     *webhook
+	event    string
+	body     []byte
+	attempts int
+}
+
+// WebhookHandler - implements interface notifyHandler from hooks(interfaces),
+// events of uploads are posted to webhooks as JSON signed by HMAC-SHA256
+// of secret of webhook in header X-CH-Signature. Failed request is repeated
+// by retry policy
+type WebhookHandler struct {
+	pathfile string
+	retry    *RetryPolicy
+	client   *http.Client
+	hooks    []*webhook
+	queue    chan *notification
+	stderr   logger
+	// workers - goroutines which deliver events, slow webhook holds
+	// one of them
+	workers int
+}
+
+// NewWebhookHandler - create new instance of WebhookHandler, size of upload
+// is read from filestore of tusd by path
+func NewWebhookHandler(path string, retry *RetryPolicy, timeout time.Duration, errlog logger) (*WebhookHandler, error) {
+	if path == "" || retry == nil || timeout <= 0 || errlog == nil {
+		return nil, errors.New("[webhook] [new] bad argument")
+	}
+	return &WebhookHandler{
+		pathfile: path,
+		retry:    retry,
+		client:   &http.Client{Timeout: timeout},
+		queue:    make(chan *notification, webhookQueue),
+		stderr:   errlog,
+		workers:  DefaultWebhookWorkers,
+	}, nil
+}
+
+// SetWorkers - set number of events which are delivered concurrently,
+// it has to be called before Run
+func (handler *WebhookHandler) SetWorkers(workers int) error {
+	if workers <= 0 {
+		return errors.New("[webhook] [set workers] bad argument")
+	}
+	handler.workers = workers
+	return nil
+}
+
+// AddWebhook - notify url about events, webhook without events is notified
+// about all of them. Request isn't signed if secret is empty
+func (handler *WebhookHandler) AddWebhook(url string, secret string, events []string) error {
+	if url == "" {
+		return errors.New("[webhook] [add] bad argument")
+	}
+	hook := &webhook{url: url, secret: secret}
+	if len(events) > 0 {
+		hook.events = make(map[string]bool, len(events))
+	}
+	for _, event := range events {
+		switch event {
+		case EventCreated, EventComplete, EventForwarded, EventFailed, EventTerminated:
+			hook.events[event] = true
+		default:
+			return errors.Errorf("[webhook] [add] unknown event %q", event)
+		}
+	}
+	handler.hooks = append(handler.hooks, hook)
+	return nil
+}
+
+// Notify - send event of upload to webhooks which wait for it, sink and
+// reason are set for events of forwarding and failures
+func (handler *WebhookHandler) Notify(event string, id string, sink string, meta map[string]string, reason string) {
+	e := WebhookEvent{
+		Event:     event,
+		ID:        id,
+		Timestamp: time.Now().Format(time.RFC3339),
+		State:     meta["State"],
+		Sink:      sink,
+		Error:     reason,
+		Metadata:  meta,
+	}
+	// File and info of upload are missing after it is deleted
+	e.Size, _ = uploadSize(filepath.Join(handler.pathfile, id+".info"))
+	if info, err := os.Stat(filepath.Join(handler.pathfile, id)); err == nil {
+		e.Offset = info.Size()
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		handler.stderr.Printf("[webhook] [notify]: id = %s; event = %s: %s\n", id, event, err)
+		return
+	}
+	for _, hook := range handler.hooks {
+		if hook.events != nil && !hook.events[event] {
+			continue
+		}
+		select {
+		case handler.queue <- &notification{hook: hook, event: event, body: body}:
+		default:
+			handler.stderr.Printf("[webhook] [notify]: id = %s; event = %s is dropped for %s, queue is full\n",
+				id, event, hook.url)
+		}
+	}
+}
+
+// Run - deliver events to webhooks by workers until ctx is done, Run
+// returns when all deliveries are over
+func (handler *WebhookHandler) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < handler.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case n := <-handler.queue:
+					handler.deliver(ctx, n)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// deliver - post event to webhook, request is repeated after backoff
+// until attempts are exhausted
+func (handler *WebhookHandler) deliver(ctx context.Context, n *notification) {
+	for {
+		n.attempts++
+		err := handler.post(ctx, n)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if handler.retry.exhausted(n.attempts) || errors.Cause(err) == errWebhookRejected {
+			handler.stderr.Printf("[webhook] [deliver]: event = %s isn't delivered to %s after %d attempts: %s\n",
+				n.event, n.hook.url, n.attempts, err)
+			return
+		}
+		select {
+		case <-time.After(handler.retry.backoff(n.attempts)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// errWebhookRejected - webhook answers by status which isn't repeated
+var errWebhookRejected = errors.New("rejected")
+
+func (handler *WebhookHandler) post(ctx context.Context, n *notification) error {
+	req, err := http.NewRequest(http.MethodPost, n.hook.url, bytes.NewReader(n.body))
+	if err != nil {
+		return errors.Wrap(errWebhookRejected, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CH-Event", n.event)
+	if n.hook.secret != "" {
+		req.Header.Set("X-CH-Signature", "sha256="+Sign(n.hook.secret, n.body))
+	}
+	res, err := handler.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case handler.retry.retryable(res.StatusCode):
+		return errors.Errorf("status code = %s", res.Status)
+	}
+	return errors.Wrapf(errWebhookRejected, "status code = %s", res.Status)
+}
+
+// Sign - return hex encoded HMAC-SHA256 of body by secret, receiver of
+// webhook compares it with header X-CH-Signature
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,152 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhook(t *testing.T) {
+	id := "0123456789webhook"
+	dir, err := ioutil.TempDir("", "test-webhook")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, id), []byte("hello"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, id+".info"), []byte(`{"Size": 11}`), 0644))
+	logerr := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	retry, _ := NewRetryPolicy(3, time.Millisecond, time.Millisecond, 0, []int{http.StatusServiceUnavailable})
+	meta := map[string]string{"SessionID": id, "State": "complete", "FileName": "logs.tar"}
+	// run - notify webhooks about events, status returns answer of
+	// webhook by number of call
+	run := func(events []string, status func(call int) int, notify ...string) ([]*http.Request, []WebhookEvent) {
+		var mu sync.Mutex
+		var requests []*http.Request
+		var received []WebhookEvent
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			requests = append(requests, r)
+			code := status(len(requests))
+			if code == http.StatusOK {
+				var e WebhookEvent
+				assert.Nil(t, json.Unmarshal(body, &e))
+				assert.Equal(t, "sha256="+Sign("secret", body), r.Header.Get("X-CH-Signature"))
+				received = append(received, e)
+			}
+			w.WriteHeader(code)
+		}))
+		defer ts.Close()
+		webhooks, err := NewWebhookHandler(dir, retry, time.Second, logerr)
+		assert.Nil(t, err)
+		assert.Nil(t, webhooks.AddWebhook(ts.URL, "secret", events))
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			assert.Nil(t, webhooks.Run(ctx))
+			close(done)
+		}()
+		for _, event := range notify {
+			webhooks.Notify(event, id, "syr", meta, "")
+		}
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		<-done
+		mu.Lock()
+		defer mu.Unlock()
+		return requests, received
+	}
+	ok := func(call int) int { return http.StatusOK }
+
+	t.Run("valid signed event", func(t *testing.T) {
+		requests, received := run(nil, ok, EventForwarded)
+		assert.Len(t, requests, 1)
+		if assert.Len(t, received, 1) {
+			e := received[0]
+			assert.Equal(t, EventForwarded, e.Event)
+			assert.Equal(t, id, e.ID)
+			assert.Equal(t, "complete", e.State)
+			assert.Equal(t, "syr", e.Sink)
+			assert.Equal(t, int64(11), e.Size)
+			assert.Equal(t, int64(5), e.Offset)
+			assert.Equal(t, "logs.tar", e.Metadata["FileName"])
+			assert.Equal(t, EventForwarded, requests[0].Header.Get("X-CH-Event"))
+		}
+	})
+	t.Run("valid filter of events", func(t *testing.T) {
+		_, received := run([]string{EventFailed, EventTerminated}, ok, EventCreated, EventFailed, EventComplete)
+		if assert.Len(t, received, 1) {
+			assert.Equal(t, EventFailed, received[0].Event)
+		}
+	})
+	t.Run("valid retry", func(t *testing.T) {
+		requests, received := run(nil, func(call int) int {
+			if call < 3 {
+				return http.StatusServiceUnavailable
+			}
+			return http.StatusOK
+		}, EventComplete)
+		assert.Len(t, requests, 3)
+		assert.Len(t, received, 1)
+	})
+	t.Run("invalid rejected event isn't repeated", func(t *testing.T) {
+		requests, _ := run(nil, func(call int) int { return http.StatusBadRequest }, EventComplete)
+		assert.Len(t, requests, 1)
+	})
+	t.Run("valid limit of workers", func(t *testing.T) {
+		var mu sync.Mutex
+		running, peak, calls := 0, 0, 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			running++
+			calls++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}))
+		defer ts.Close()
+		webhooks, _ := NewWebhookHandler(dir, retry, time.Second, logerr)
+		assert.Nil(t, webhooks.AddWebhook(ts.URL, "secret", nil))
+		assert.Nil(t, webhooks.SetWorkers(2))
+		for i := 0; i < 6; i++ {
+			webhooks.Notify(EventComplete, id, "", meta, "")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			assert.Nil(t, webhooks.Run(ctx))
+			close(done)
+		}()
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return calls == 6
+		}, time.Second, 5*time.Millisecond)
+		cancel()
+		<-done
+		assert.Equal(t, 2, peak)
+	})
+	t.Run("invalid arguments", func(t *testing.T) {
+		_, err := NewWebhookHandler("", retry, time.Second, logerr)
+		assert.Error(t, err)
+		webhooks, _ := NewWebhookHandler(dir, retry, time.Second, logerr)
+		assert.Error(t, webhooks.SetWorkers(0))
+		assert.Error(t, webhooks.AddWebhook("", "secret", nil))
+		assert.Error(t, webhooks.AddWebhook("http://hooks.com", "secret", []string{"progress"}))
+	})
+}
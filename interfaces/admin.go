@@ -0,0 +1,224 @@
+package interfaces
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"b.yadro.com/sys/ch-server/usecases"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+	// attributeParam - prefix of query parameter of attribute of upload
+	attributeParam = "attr."
+)
+
+// adminAgent - interface of dataAgent from usecases to manage uploads
+type adminAgent interface {
+	Read(id string) (usecases.Data, error)
+	List(filter usecases.Filter) ([]usecases.Data, string, error)
+	Resend(id string) error
+	Delete(id string) error
+	Reconcile(policy string) (usecases.Report, error)
+}
+
+// AdminHandler - http handler of admin API, request is authorized by token
+// in header "Authorization: Bearer <token>"
+//
+//	GET    /uploads              list uploads, see parseFilter for parameters
+//	GET    /uploads/{id}         read metadata of upload
+//	POST   /uploads/{id}/retry   send upload to SYR again
+//	DELETE /uploads/{id}         delete metadata and file of upload
+//	POST   /reconcile            reconcile metadata with filestore, parameter
+//	                             orphans is keep (default), remove or quarantine
+type AdminHandler struct {
+	agent  adminAgent
+	token  string
+	stdout logger
+}
+
+// NewAdminHandler - create new instance of AdminHandler to mount in http server
+func NewAdminHandler(agent adminAgent, token string, stdlog logger) (*AdminHandler, error) {
+	if agent == nil || token == "" || stdlog == nil {
+		return nil, errors.New("[admin] [new] bad argument")
+	}
+	return &AdminHandler{agent, token, stdlog}, nil
+}
+
+// ServeHTTP - route request of admin API, path is relative to mount point
+func (admin *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !admin.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch parts[0] {
+	case "uploads":
+	case "reconcile":
+		if len(parts) == 1 && r.Method == http.MethodPost {
+			admin.reconcile(w, r)
+			return
+		}
+		fallthrough
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		admin.list(w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		admin.read(w, parts[1])
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		admin.delete(w, parts[1])
+	case len(parts) == 3 && parts[2] == "retry" && r.Method == http.MethodPost:
+		admin.retry(w, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// authorized - test token of request, it is sent by Bearer scheme only
+func (admin *AdminHandler) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(admin.token)) == 1
+}
+
+func (admin *AdminHandler) list(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	list, next, err := admin.agent.List(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Uploads []usecases.Data `json:"uploads"`
+		Next    string          `json:"next,omitempty"`
+	}{list, next})
+}
+
+func (admin *AdminHandler) read(w http.ResponseWriter, id string) {
+	data, err := admin.agent.Read(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (admin *AdminHandler) retry(w http.ResponseWriter, id string) {
+	if err := admin.agent.Resend(id); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	admin.stdout.Printf("[admin] [retry]: id = %s\n", id)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (admin *AdminHandler) delete(w http.ResponseWriter, id string) {
+	if _, err := admin.agent.Read(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err := admin.agent.Delete(id); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	admin.stdout.Printf("[admin] [delete]: id = %s\n", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (admin *AdminHandler) reconcile(w http.ResponseWriter, r *http.Request) {
+	policy := r.URL.Query().Get("orphans")
+	if policy == "" {
+		policy = usecases.OrphanKeep
+	}
+	if !usecases.ValidOrphanPolicy(policy) {
+		writeError(w, http.StatusBadRequest, errors.New("bad parameter orphans, expect keep, remove or quarantine"))
+		return
+	}
+	report, err := admin.agent.Reconcile(policy)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	admin.stdout.Printf("[admin] [reconcile]: migrated = %d; missing = %d; orphans = %d; resent = %d\n",
+		len(report.Migrated), len(report.Missing), len(report.Orphans), len(report.Resent))
+	writeJSON(w, http.StatusOK, report)
+}
+
+// parseFilter - read filter from query parameters: serial, hostname, system,
+// state, remote (id of upload on SYR), attr.<name> (attribute of upload),
+// from and to (RFC 3339), order (asc or desc), cursor and limit
+func parseFilter(r *http.Request) (usecases.Filter, error) {
+	query := r.URL.Query()
+	filter := usecases.Filter{
+		SerialNumber: query.Get("serial"),
+		Hostname:     query.Get("hostname"),
+		SystemType:   query.Get("system"),
+		State:        query.Get("state"),
+		RemoteID:     query.Get("remote"),
+		Cursor:       query.Get("cursor"),
+		Limit:        defaultLimit,
+	}
+	// Attributes are matched by parameters attr.<name>
+	for k, v := range query {
+		if name := strings.TrimPrefix(k, attributeParam); name != k && name != "" && len(v) > 0 {
+			if filter.Attributes == nil {
+				filter.Attributes = make(map[string]string)
+			}
+			filter.Attributes[name] = v[0]
+		}
+	}
+	var err error
+	if v := query.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.Wrap(err, "bad parameter from")
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.Wrap(err, "bad parameter to")
+		}
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, errors.New("bad parameter order, expect asc or desc")
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 || filter.Limit > maxLimit {
+			return filter, errors.Errorf("bad parameter limit, expect 1..%d", maxLimit)
+		}
+	}
+	return filter, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
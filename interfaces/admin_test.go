@@ -0,0 +1,161 @@
+package interfaces
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"b.yadro.com/sys/ch-server/domain"
+	"b.yadro.com/sys/ch-server/usecases"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newAdminHandler(t *testing.T) (*AdminHandler, *usecases.DataRepositoryMock, *usecases.HttpClientMock) {
+	repo := new(usecases.DataRepositoryMock)
+	client := new(usecases.HttpClientMock)
+	dataAgent, err := usecases.NewDataAgent(repo, client)
+	assert.Nil(t, err)
+	admin, err := NewAdminHandler(dataAgent, "secret", log.New(os.Stdout, "[test] ", log.LstdFlags))
+	assert.Nil(t, err)
+	return admin, repo, client
+}
+
+func serveAdmin(admin *AdminHandler, method, url, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	return w
+}
+
+func TestNewAdminHandler(t *testing.T) {
+	logger := log.New(os.Stdout, "[test] ", log.LstdFlags)
+	h, err := NewAdminHandler(newDataAgent().(adminAgent), "", logger)
+	assert.Nil(t, h)
+	assert.NotNil(t, err)
+	h, err = NewAdminHandler(nil, "secret", logger)
+	assert.Nil(t, h)
+	assert.NotNil(t, err)
+	h, err = NewAdminHandler(newDataAgent().(adminAgent), "secret", nil)
+	assert.Nil(t, h)
+	assert.NotNil(t, err)
+}
+
+func TestAdminUnauthorized(t *testing.T) {
+	admin, _, _ := newAdminHandler(t)
+	w := serveAdmin(admin, http.MethodGet, "/uploads", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serveAdmin(admin, http.MethodGet, "/uploads", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	// token without Bearer scheme is rejected
+	req := httptest.NewRequest(http.MethodGet, "/uploads", nil)
+	req.Header.Set("Authorization", "secret")
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAdminList(t *testing.T) {
+	admin, repo, _ := newAdminHandler(t)
+	first := domain.Query{SerialNumber: "0123456789", Hostname: "node1", Limit: 1}
+	repo.On("Query", first).Return([]domain.Data{{SessionID: "1", SerialNumber: "0123456789"}}, "0a", nil)
+	second := domain.Query{SerialNumber: "0123456789", Hostname: "node1", Descending: true, Cursor: "0a", Limit: 1}
+	repo.On("Query", second).Return([]domain.Data{{SessionID: "3", SerialNumber: "0123456789"}}, "", nil)
+	w := serveAdmin(admin, http.MethodGet, "/uploads?serial=0123456789&hostname=node1&limit=1", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Uploads []usecases.Data
+		Next    string
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Uploads, 1)
+	assert.Equal(t, "1", page.Uploads[0].SessionID)
+	assert.Equal(t, "0a", page.Next)
+	w = serveAdmin(admin, http.MethodGet, "/uploads?serial=0123456789&hostname=node1&order=desc&limit=1&cursor=0a", "secret")
+	page.Next = ""
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Uploads, 1)
+	assert.Equal(t, "3", page.Uploads[0].SessionID)
+	assert.Equal(t, "", page.Next)
+	// Attributes are listed by parameters attr.<name>
+	attrs := domain.Query{Attributes: map[string]string{"Firmware": "1.2"}, Limit: defaultLimit}
+	repo.On("Query", attrs).Return([]domain.Data{{SessionID: "2", Attributes: map[string]string{"Firmware": "1.2"}}}, "", nil)
+	w = serveAdmin(admin, http.MethodGet, "/uploads?attr.Firmware=1.2", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	if assert.Len(t, page.Uploads, 1) {
+		assert.Equal(t, "1.2", page.Uploads[0].Attributes["Firmware"])
+	}
+	w = serveAdmin(admin, http.MethodGet, "/uploads?from=yesterday", "secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveAdmin(admin, http.MethodGet, "/uploads?limit=100000", "secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveAdmin(admin, http.MethodGet, "/uploads?order=random", "secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdminRead(t *testing.T) {
+	admin, repo, _ := newAdminHandler(t)
+	repo.On("FindById", "1").Return(domain.Data{SessionID: "1"}, nil)
+	repo.On("FindById", "2").Return(domain.Data{}, errors.New("fail"))
+	w := serveAdmin(admin, http.MethodGet, "/uploads/1", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"SessionID":"1"`)
+	w = serveAdmin(admin, http.MethodGet, "/uploads/2", "secret")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serveAdmin(admin, http.MethodGet, "/unknown", "secret")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminRetry(t *testing.T) {
+	admin, repo, client := newAdminHandler(t)
+	failed := domain.Data{SessionID: "1", SerialNumber: "0123456789", FileName: "log.tar", State: domain.StateFailed}
+	repo.On("FindById", "1").Return(failed, nil)
+	repo.On("Store", mock.Anything).Return(nil)
+	client.On("Send", "1", mock.AnythingOfType("usecases.Data"), "").Return(nil)
+	w := serveAdmin(admin, http.MethodPost, "/uploads/1/retry", "secret")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+		return d.State == domain.StateComplete
+	}))
+	client.AssertCalled(t, "Send", "1", mock.AnythingOfType("usecases.Data"), "")
+	created := domain.Data{SessionID: "2", State: domain.StateCreated}
+	repo.On("FindById", "2").Return(created, nil)
+	w = serveAdmin(admin, http.MethodPost, "/uploads/2/retry", "secret")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestAdminDelete(t *testing.T) {
+	admin, repo, _ := newAdminHandler(t)
+	repo.On("FindById", "1").Return(domain.Data{SessionID: "1"}, nil)
+	repo.On("Remove", "1").Return(nil)
+	w := serveAdmin(admin, http.MethodDelete, "/uploads/1", "secret")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	repo.AssertCalled(t, "Remove", "1")
+}
+
+func TestAdminReconcile(t *testing.T) {
+	admin, repo, _ := newAdminHandler(t)
+	repo.On("ReadAll").Return([]string{}, nil)
+	repo.On("Files").Return([]string{"orphan"}, nil)
+	repo.On("Modified", "orphan").Return(time.Now().Add(-2*usecases.DefaultOrphanGrace), nil)
+	repo.On("Quarantine", "orphan").Return(nil)
+	w := serveAdmin(admin, http.MethodPost, "/reconcile?orphans=quarantine", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report usecases.Report
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal(t, []string{"orphan"}, report.Orphans)
+	repo.AssertCalled(t, "Quarantine", "orphan")
+	w = serveAdmin(admin, http.MethodPost, "/reconcile?orphans=drop", "secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveAdmin(admin, http.MethodGet, "/reconcile", "secret")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,150 @@
+package interfaces
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Default limits of attributes of upload
+const (
+	defaultMaxAttributes     = 32
+	defaultMaxAttributeName  = 64
+	defaultMaxAttributeValue = 1024
+)
+
+// attributeName - allowed name of attribute, it may be forwarded as field
+// of form or tus metadata
+var attributeName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// knownFields - lower case names of fields of usecases.Data which device
+// may send, they aren't attributes
+var knownFields = map[string]bool{
+	"id":                     true,
+	"service":                true,
+	"serialnumber":           true,
+	"logcollectiontimestamp": true,
+	"clientstarttimestamp":   true,
+	"systemtype":             true,
+	"loglevel":               true,
+	"originator":             true,
+	"sessionid":              true,
+	"checksum":               true,
+	"hostname":               true,
+	"notificationmanager":    true,
+	"cancel":                 true,
+	"starttimestamp":         true,
+	"finishtimestamp":        true,
+	"filename":               true,
+}
+
+// serverFields - lower case names of fields of usecases.Data which are set
+// by server, metadata with them is rejected
+var serverFields = map[string]bool{
+	"checksumresult":   true,
+	"computedchecksum": true,
+	"attempts":         true,
+	"lasterror":        true,
+	"state":            true,
+	"history":          true,
+	"deliveries":       true,
+	"remoteid":         true,
+	"attributes":       true,
+}
+
+// attributeLimits - limits of attributes of upload against abuse: number
+// of attributes, length of name and length of value
+type attributeLimits struct {
+	count int
+	name  int
+	value int
+}
+
+// SetAttributeLimits - limit number of attributes of upload, length of their
+// names and values
+func (hook *hooksHandler) SetAttributeLimits(count int, name int, value int) error {
+	if count < 0 || name <= 0 || value <= 0 {
+		return errors.New("[hooks] [set attribute limits] bad argument")
+	}
+	hook.limits = attributeLimits{count, name, value}
+	return nil
+}
+
+// attributes - return fields of metadata which are unknown to usecases.Data,
+// values which aren't strings are kept as JSON. Fields set by server are
+// rejected with status 400, too many or too long attributes with status 413,
+// bad names with status 422.
+// Fields declared by schema of metadata are kept as attributes too, they
+// are limited by schema only
+func (hook *hooksHandler) attributes(data string) (map[string]string, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil, newValidationError(http.StatusBadRequest, FieldError{"data", "is not valid JSON object"})
+	}
+	names := make([]string, 0, len(fields))
+	var owned []FieldError
+	for name, value := range fields {
+		switch {
+		case string(value) == "null" || knownFields[strings.ToLower(name)]:
+		case serverFields[strings.ToLower(name)]:
+			owned = append(owned, FieldError{name, "is set by server"})
+		default:
+			names = append(names, name)
+		}
+	}
+	if len(owned) > 0 {
+		sort.Slice(owned, func(i, j int) bool { return owned[i].Field < owned[j].Field })
+		return nil, newValidationError(http.StatusBadRequest, owned...)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	sort.Strings(names)
+	declared := hook.schema.declared(data)
+	count := 0
+	for _, name := range names {
+		if !declared[name] {
+			count++
+		}
+	}
+	var invalid, long []FieldError
+	if count > hook.limits.count {
+		long = append(long, FieldError{"data", fmt.Sprintf("has more than %d attributes", hook.limits.count)})
+	}
+	attributes := make(map[string]string, len(names))
+	for _, name := range names {
+		value := string(fields[name])
+		var str string
+		if err := json.Unmarshal(fields[name], &str); err == nil {
+			value = str
+		} else {
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, fields[name]); err == nil {
+				value = compact.String()
+			}
+		}
+		switch {
+		case !attributeName.MatchString(name):
+			invalid = append(invalid, FieldError{name, "has invalid name, expected " + attributeName.String()})
+		case declared[name]:
+		case len(name) > hook.limits.name:
+			long = append(long, FieldError{name, fmt.Sprintf("exceeds max length of name %d", hook.limits.name)})
+		case len(value) > hook.limits.value:
+			long = append(long, FieldError{name, fmt.Sprintf("exceeds max length %d", hook.limits.value)})
+		}
+		attributes[name] = value
+	}
+	switch {
+	case len(invalid) > 0:
+		return nil, newValidationError(http.StatusUnprocessableEntity, append(invalid, long...)...)
+	case len(long) > 0:
+		return nil, newValidationError(http.StatusRequestEntityTooLarge, long...)
+	}
+	return attributes, nil
+}
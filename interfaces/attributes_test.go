@@ -0,0 +1,122 @@
+package interfaces
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"b.yadro.com/sys/ch-server/domain"
+	"b.yadro.com/sys/ch-server/usecases"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAttributes(t *testing.T) {
+	repo := new(usecases.DataRepositoryMock)
+	dataAgent, _ := usecases.NewDataAgent(repo, new(usecases.HttpClientMock))
+	hooksHandler, _ := NewHooksHandler(
+		dataAgent,
+		new(clientHooks),
+		log.New(os.Stdout, "[test] ", log.LstdFlags))
+	repo.On("FindById", mock.Anything).Return(domain.Data{}, errors.New("fail"))
+	repo.On("Store", mock.Anything).Return(nil)
+	id := "0123456789"
+	// meta - valid metadata with extra fields
+	meta := func(extra string) string {
+		return `{
+			"SerialNumber"          : "0123456789",
+			"LogCollectionTimestamp": "Thu Aug 17 14:00:06 MSK 2019",
+			"ClientStartTimestamp"  : "Thu Oct 17 14:00:06 MSK 2019"` + extra + `}`
+	}
+	// rejected - return status and fields of rejection of metadata
+	rejected := func(data string) (int, []FieldError) {
+		err := hooksHandler.Validate(id, data)
+		e, ok := err.(*ValidationError)
+		if !assert.True(t, ok, "attributes: error isn't ValidationError: %v", err) {
+			return 0, nil
+		}
+		return e.StatusCode(), e.Fields
+	}
+
+	t.Run("valid attributes are stored", func(t *testing.T) {
+		data := meta(`, "Firmware": "1.2", "Rack": 7, "Slots": [1, 2], "hostname": "node1", "Empty": null`)
+		assert.Nil(t, hooksHandler.Validate(id, data))
+		assert.Nil(t, hooksHandler.Create(id, data, "logs.tar"))
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return assert.Equal(t, map[string]string{"Firmware": "1.2", "Rack": "7", "Slots": "[1,2]"}, d.Attributes) &&
+				d.Hostname == "node1"
+		}))
+	})
+	t.Run("valid attributes are given to sinks with prefix", func(t *testing.T) {
+		m := metadata(usecases.Data{SerialNumber: id, Attributes: map[string]string{"Firmware": "1.2"}})
+		assert.Equal(t, "1.2", m["Attributes.Firmware"])
+		assert.Equal(t, id, m["SerialNumber"])
+	})
+	t.Run("invalid too long attributes", func(t *testing.T) {
+		assert.Nil(t, hooksHandler.SetAttributeLimits(2, 8, 16))
+		status, fields := rejected(meta(`, "Firmware": "` + strings.Repeat("1", 17) + `", "Manufacturer": "yadro"`))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+		assert.Equal(t, []FieldError{
+			{"Firmware", "exceeds max length 16"},
+			{"Manufacturer", "exceeds max length of name 8"},
+		}, fields)
+		status, fields = rejected(meta(`, "A": "1", "B": "2", "C": "3"`))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+		assert.Equal(t, "data", fields[0].Field)
+		assert.Error(t, hooksHandler.Create(id, meta(`, "A": "1", "B": "2", "C": "3"`), "logs.tar"))
+	})
+	t.Run("invalid name of attribute", func(t *testing.T) {
+		status, fields := rejected(meta(`, "Rack Unit": "7"`))
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+		assert.Equal(t, "Rack Unit", fields[0].Field)
+	})
+	t.Run("invalid fields set by server", func(t *testing.T) {
+		status, fields := rejected(meta(`, "State": "forwarded", "remoteId": "1", "Attempts": 0, "History": null`))
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, []FieldError{
+			{"Attempts", "is set by server"},
+			{"State", "is set by server"},
+			{"remoteId", "is set by server"},
+		}, fields)
+		assert.Error(t, hooksHandler.Create(id, meta(`, "Deliveries": {}`), "logs.tar"))
+	})
+	t.Run("valid fields of data are known or set by server", func(t *testing.T) {
+		typ := reflect.TypeOf(usecases.Data{})
+		for i := 0; i < typ.NumField(); i++ {
+			name := strings.ToLower(typ.Field(i).Name)
+			assert.True(t, knownFields[name] != serverFields[name], "attributes: field %s", typ.Field(i).Name)
+		}
+	})
+	t.Run("valid fields of schema aren't limited as attributes", func(t *testing.T) {
+		handler, _ := NewHooksHandler(dataAgent, new(clientHooks), log.New(os.Stdout, "[test] ", log.LstdFlags))
+		schema, _ := NewMetadataSchema("SchemaVersion", "1")
+		assert.Nil(t, schema.AddVersion("1", nil))
+		assert.Nil(t, schema.AddVersion("2", []FieldSchema{
+			{Name: "SerialNumber", Type: "string", Required: true},
+			{Name: "FirmwareVersion", Type: "string", MaxLength: 32},
+		}))
+		assert.Nil(t, handler.SetSchema(schema))
+		assert.Nil(t, handler.SetAttributeLimits(1, 8, 16))
+		data := `{"SerialNumber": "0123456789", "SchemaVersion": 2, "FirmwareVersion": "` +
+			strings.Repeat("1", 20) + `", "Rack": "7"}`
+		assert.Nil(t, handler.Validate(id, data))
+		attributes, err := handler.attributes(data)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{
+			"SchemaVersion":   "2",
+			"FirmwareVersion": strings.Repeat("1", 20),
+			"Rack":            "7",
+		}, attributes)
+		// field isn't declared by version 1, it is limited as attribute
+		_, err = handler.attributes(`{"SerialNumber": "0123456789", "FirmwareVersion": "1.2", "Rack": "7"}`)
+		assert.Error(t, err)
+	})
+	t.Run("invalid limits", func(t *testing.T) {
+		assert.Error(t, hooksHandler.SetAttributeLimits(-1, 8, 16))
+		assert.Error(t, hooksHandler.SetAttributeLimits(2, 0, 16))
+	})
+}
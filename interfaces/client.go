@@ -1,10 +1,13 @@
 package interfaces
 
-import "github.com/pkg/errors"
+import (
+	"b.yadro.com/sys/ch-server/usecases"
+	"github.com/pkg/errors"
+)
 
 // clientHandler - interface of SYRHandler from infrastructure/clienthandler
 type clientHandler interface {
-	Send(id string, name string, system string) error
+	Send(id string, name string, system string, route string, meta map[string]string) error
 }
 
 // HTTPClient - implement interface httpClient from usecases
@@ -22,10 +25,38 @@ func NewHTTPClient(client clientHandler, stdlog logger) (*HTTPClient, error) {
 }
 
 // Send - implement func to send file to extern server
-func (client *HTTPClient) Send(id string, name string, system string) error {
-	client.stdout.Printf("[client] [send]: id = %s; filename = %s\n", id, name)
-	if err := client.clientHandler.Send(id, name, system); err != nil {
+func (client *HTTPClient) Send(id string, data usecases.Data, route string) error {
+	client.stdout.Printf("[client] [send]: id = %s; filename = %s; route = %s\n", id, data.FileName, route)
+	if err := client.clientHandler.Send(id, data.FileName, data.SerialNumber, route, metadata(data)); err != nil {
 		return errors.Wrap(err, "[client] [send]")
 	}
 	return nil
 }
+
+// metadata - fields of upload by name for templates of outbound request,
+// attributes are prefixed by usecases.AttributePrefix
+func metadata(data usecases.Data) map[string]string {
+	meta := map[string]string{
+		"Service":                data.Service,
+		"SerialNumber":           data.SerialNumber,
+		"LogCollectionTimestamp": data.LogCollectionTimestamp,
+		"ClientStartTimestamp":   data.ClientStartTimestamp,
+		"SystemType":             data.SystemType,
+		"LogLevel":               data.LogLevel,
+		"Originator":             data.Originator,
+		"SessionID":              data.SessionID,
+		"Checksum":               data.Checksum,
+		"ComputedChecksum":       data.ComputedChecksum,
+		"Hostname":               data.Hostname,
+		"NotificationManager":    data.NotificationManager,
+		"StartTimestamp":         data.StartTimestamp,
+		"FinishTimestamp":        data.FinishTimestamp,
+		"FileName":               data.FileName,
+		"State":                  data.State,
+		"RemoteID":               data.RemoteID,
+	}
+	for k, v := range data.Attributes {
+		meta[usecases.AttributePrefix+k] = v
+	}
+	return meta
+}
@@ -8,7 +8,7 @@ type ClientMock struct {
 	mock.Mock
 }
 
-func (m *ClientMock) Send(id string, name string, system string) error {
-	args := m.Called(id, name, system)
+func (m *ClientMock) Send(id string, name string, system string, route string, meta map[string]string) error {
+	args := m.Called(id, name, system, route, meta)
 	return args.Error(0)
 }
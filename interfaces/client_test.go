@@ -5,8 +5,10 @@ import (
 	"os"
 	"testing"
 
+	"b.yadro.com/sys/ch-server/usecases"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestNewHTTPClientClientHandlerNil(t *testing.T) {
@@ -41,10 +43,12 @@ func TestSendInvalid(t *testing.T) {
 	id := "0123456789"
 	name := "logs.tar"
 	system := "0123456789"
+	route := "storage"
+	data := usecases.Data{SessionID: id, FileName: name, SerialNumber: system}
 	e := errors.New("failed")
-	client.On("Send", id, name, system).Return(e)
-	err := httpClient.Send(id, name, system)
-	client.AssertCalled(t, "Send", id, name, system)
+	client.On("Send", id, name, system, route, mock.Anything).Return(e)
+	err := httpClient.Send(id, data, route)
+	client.AssertCalled(t, "Send", id, name, system, route, mock.Anything)
 	assert.Equal(t, errors.Cause(err), e)
 }
 
@@ -57,8 +61,13 @@ func TestSendValid(t *testing.T) {
 	id := "0123456789"
 	name := "logs.tar"
 	system := "0123456789"
-	client.On("Send", id, name, system).Return(nil)
-	err := httpClient.Send(id, name, system)
-	client.AssertCalled(t, "Send", id, name, system)
+	route := "storage"
+	data := usecases.Data{SessionID: id, FileName: name, SerialNumber: system, SystemType: "storage"}
+	meta := func(m map[string]string) bool {
+		return m["SessionID"] == id && m["FileName"] == name && m["SystemType"] == "storage"
+	}
+	client.On("Send", id, name, system, route, mock.MatchedBy(meta)).Return(nil)
+	err := httpClient.Send(id, data, route)
+	client.AssertCalled(t, "Send", id, name, system, route, mock.MatchedBy(meta))
 	assert.Nil(t, err)
 }
@@ -0,0 +1,107 @@
+package interfaces
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// circuitBreaker - implemented in Breaker from infrastructure/clientbreaker
+// to report whether sink is available
+type circuitBreaker interface {
+	State() string
+	Failures() int
+	Trips() int
+	Probes() int
+	Since() time.Time
+}
+
+// breakerOpen - state of circuitBreaker while sink is down
+const breakerOpen = "open"
+
+// HealthHandler - http handler of health of server and state of circuit
+// breakers of sinks, server is degraded while any breaker is open. Uploads
+// are still accepted then and wait until sink is available
+type HealthHandler struct {
+	breakers map[string]circuitBreaker
+}
+
+// breakerStatus - state of circuit breaker of sink in health report
+type breakerStatus struct {
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	Trips    int        `json:"trips"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+// NewHealthHandler - create new instance of HealthHandler to mount in http server
+func NewHealthHandler() (*HealthHandler, error) {
+	return &HealthHandler{breakers: make(map[string]circuitBreaker)}, nil
+}
+
+// AddBreaker - report state of circuit breaker of sink
+func (health *HealthHandler) AddBreaker(sink string, breaker circuitBreaker) error {
+	if sink == "" || breaker == nil {
+		return errors.New("[health] [add breaker] bad argument")
+	}
+	health.breakers[sink] = breaker
+	return nil
+}
+
+// ServeHTTP - report status ok or degraded and state of breakers by sink
+func (health *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	breakers := make(map[string]breakerStatus, len(health.breakers))
+	for sink, b := range health.breakers {
+		s := breakerStatus{State: b.State(), Failures: b.Failures(), Trips: b.Trips()}
+		if s.State == breakerOpen {
+			status = "degraded"
+			since := b.Since()
+			s.Since = &since
+		}
+		breakers[sink] = s
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Status   string                   `json:"status"`
+		Breakers map[string]breakerStatus `json:"breakers,omitempty"`
+	}{status, breakers})
+}
+
+// Metrics - http handler of metrics of breakers in text format of Prometheus
+func (health *HealthHandler) Metrics(w http.ResponseWriter, r *http.Request) {
+	sinks := make([]string, 0, len(health.breakers))
+	for sink := range health.breakers {
+		sinks = append(sinks, sink)
+	}
+	sort.Strings(sinks)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(b circuitBreaker) int
+	}{
+		{"ch_server_breaker_open", "gauge", "State of circuit breaker of sink, 1 is open",
+			func(b circuitBreaker) int {
+				if b.State() == breakerOpen {
+					return 1
+				}
+				return 0
+			}},
+		{"ch_server_breaker_failures", "gauge", "Consecutive failures of sink",
+			func(b circuitBreaker) int { return b.Failures() }},
+		{"ch_server_breaker_trips_total", "counter", "Number of times circuit breaker of sink is opened",
+			func(b circuitBreaker) int { return b.Trips() }},
+		{"ch_server_breaker_probes_total", "counter", "Number of probes of sink while circuit breaker is open",
+			func(b circuitBreaker) int { return b.Probes() }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, sink := range sinks {
+			fmt.Fprintf(w, "%s{sink=%q} %d\n", m.name, sink, m.value(health.breakers[sink]))
+		}
+	}
+}
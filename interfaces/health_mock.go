@@ -0,0 +1,32 @@
+package interfaces
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type CircuitBreakerMock struct {
+	mock.Mock
+}
+
+func (m *CircuitBreakerMock) State() string {
+	args := m.Called()
+	return args.String(0)
+}
+func (m *CircuitBreakerMock) Failures() int {
+	args := m.Called()
+	return args.Int(0)
+}
+func (m *CircuitBreakerMock) Trips() int {
+	args := m.Called()
+	return args.Int(0)
+}
+func (m *CircuitBreakerMock) Probes() int {
+	args := m.Called()
+	return args.Int(0)
+}
+func (m *CircuitBreakerMock) Since() time.Time {
+	args := m.Called()
+	return args.Get(0).(time.Time)
+}
@@ -0,0 +1,69 @@
+package interfaces
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newBreaker(state string, failures int, trips int) *CircuitBreakerMock {
+	b := new(CircuitBreakerMock)
+	b.On("State").Return(state)
+	b.On("Failures").Return(failures)
+	b.On("Trips").Return(trips)
+	b.On("Probes").Return(trips * 3)
+	b.On("Since").Return(time.Date(2019, 8, 1, 14, 0, 0, 0, time.UTC))
+	return b
+}
+
+func TestHealth(t *testing.T) {
+	health, err := NewHealthHandler()
+	assert.Nil(t, err)
+	assert.Error(t, health.AddBreaker("", newBreaker("closed", 0, 0)))
+	assert.Error(t, health.AddBreaker("syr", nil))
+	assert.Nil(t, health.AddBreaker("syr", newBreaker("closed", 1, 0)))
+	report := func() (string, map[string]breakerStatus) {
+		w := httptest.NewRecorder()
+		health.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Status   string
+			Breakers map[string]breakerStatus
+		}
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+		return body.Status, body.Breakers
+	}
+
+	t.Run("valid ok", func(t *testing.T) {
+		status, breakers := report()
+		assert.Equal(t, "ok", status)
+		assert.Equal(t, breakerStatus{State: "closed", Failures: 1}, breakers["syr"])
+	})
+	t.Run("valid degraded", func(t *testing.T) {
+		assert.Nil(t, health.AddBreaker("syr-dc2", newBreaker("open", 5, 2)))
+		status, breakers := report()
+		assert.Equal(t, "degraded", status)
+		assert.Equal(t, "open", breakers["syr-dc2"].State)
+		assert.NotNil(t, breakers["syr-dc2"].Since)
+	})
+	t.Run("valid metrics", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		health.Metrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		metrics := w.Body.String()
+		for _, line := range []string{
+			`ch_server_breaker_open{sink="syr"} 0`,
+			`ch_server_breaker_open{sink="syr-dc2"} 1`,
+			`ch_server_breaker_failures{sink="syr-dc2"} 5`,
+			`ch_server_breaker_trips_total{sink="syr-dc2"} 2`,
+			`ch_server_breaker_probes_total{sink="syr-dc2"} 6`,
+			"# TYPE ch_server_breaker_trips_total counter",
+		} {
+			assert.True(t, strings.Contains(metrics, line+"\n"), line)
+		}
+	})
+}
@@ -2,27 +2,61 @@ package interfaces
 
 import (
 	"encoding/json"
+	"net/http"
 	"time"
 
 	"b.yadro.com/sys/ch-server/usecases"
 	"github.com/pkg/errors"
 )
 
-const maxLength = 64
+const (
+	maxLength = 64
+	// maxLengthChecksum - checksum is prefixed by algorithm, e.g. "sha256:"
+	maxLengthChecksum = 128
+)
 
 type clientHooksI interface {
 	GetChanTerm() chan string
 }
 
+// streamHandler - implemented in SYRHandler(infrastructure) to forward
+// upload while it is arriving
+type streamHandler interface {
+	Stream(id string, name string, system string, route string, meta map[string]string) error
+	Progress(id string)
+	Abort(id string)
+}
+
+// notifyHandler - implemented in WebhookHandler(infrastructure) to tell
+// external services about events of upload
+type notifyHandler interface {
+	Notify(event string, id string, sink string, meta map[string]string, reason string)
+}
+
+// Events of upload for notifyHandler
+const (
+	eventCreated    = "created"
+	eventComplete   = "complete"
+	eventForwarded  = "forwarded"
+	eventFailed     = "failed"
+	eventTerminated = "terminated"
+)
+
 // dataAgent - interface of dataAgent from usecases
 type dataAgent interface {
 	Create(data usecases.Data) error
 	Read(id string) (usecases.Data, error)
 	Update(id string, data usecases.Data) error
 	Delete(id string) error
+	Transit(id string, state string, reason string) error
+	Purge(id string) error
+	Verify(id string) (bool, error)
 	IsUnique(data usecases.Data) error
 	ReadAll() ([]string, error)
 	Send(id string) error
+	Dispatch(id string, sinks map[string]bool) ([]string, error)
+	Route(data usecases.Data) (string, error)
+	Deliver(id string, sink string, state string, attempts int, reason string, remote string) (bool, error)
 }
 
 // hooksHandler - implements interface hooksHandler from TusdHandler(infrastructure)
@@ -30,6 +64,10 @@ type hooksHandler struct {
 	dataAgent   dataAgent
 	clientHooks clientHooksI
 	stdout      logger
+	stream      streamHandler
+	notifier    notifyHandler
+	schema      *MetadataSchema
+	limits      attributeLimits
 }
 
 // NewHooksHandler - create new hooksHandler instance
@@ -37,34 +75,106 @@ func NewHooksHandler(dataAgent dataAgent, clientHooks clientHooksI, stdlog logge
 	if dataAgent == nil || clientHooks == nil || stdlog == nil {
 		return nil, errors.New("[hooks] [new] bad argument")
 	}
-	return &hooksHandler{dataAgent, clientHooks, stdlog}, nil
+	return &hooksHandler{
+		dataAgent:   dataAgent,
+		clientHooks: clientHooks,
+		stdout:      stdlog,
+		schema:      defaultSchema(),
+		limits:      attributeLimits{defaultMaxAttributes, defaultMaxAttributeName, defaultMaxAttributeValue},
+	}, nil
+}
+
+// SetSchema - validate metadata of uploads by schema instead of default one
+func (hook *hooksHandler) SetSchema(schema *MetadataSchema) error {
+	if schema == nil || schema.versions[schema.fallback] == nil {
+		return errors.New("[hooks] [set schema] bad argument")
+	}
+	hook.schema = schema
+	return nil
+}
+
+// SetStreamHandler - forward uploads while they are arriving, upload is sent
+// as usual when it is complete if stream is failed
+func (hook *hooksHandler) SetStreamHandler(stream streamHandler) error {
+	if stream == nil {
+		return errors.New("[hooks] [set stream] bad argument")
+	}
+	hook.stream = stream
+	return nil
+}
+
+// SetNotifyHandler - notify about created, complete, forwarded, failed
+// and terminated uploads
+func (hook *hooksHandler) SetNotifyHandler(notifier notifyHandler) error {
+	if notifier == nil {
+		return errors.New("[hooks] [set notify] bad argument")
+	}
+	hook.notifier = notifier
+	return nil
+}
+
+// notify - send event with current metadata of upload
+func (hook *hooksHandler) notify(event string, id string, sink string, reason string) {
+	if hook.notifier == nil {
+		return
+	}
+	meta, err := hook.dataAgent.Read(id)
+	if err != nil {
+		hook.stdout.Printf("[hooks] [notify]: id = %s; event = %s without metadata: %s\n", id, event, err)
+		meta = usecases.Data{SessionID: id}
+	}
+	hook.notifier.Notify(event, id, sink, metadata(meta), reason)
 }
 
+// Validate - test metadata of new upload, rejection by client fault is
+// ValidationError, other errors are faults of server
 func (hook *hooksHandler) Validate(id string, data string) error {
-	if !json.Valid([]byte(data)) {
-		return errors.New("[hooks] [validate] invalid json in metadata")
+	if err := hook.schema.validate(data); err != nil {
+		return err
+	}
+	if _, err := hook.attributes(data); err != nil {
+		return err
 	}
 	meta := usecases.Data{}
 	if err := json.Unmarshal([]byte(data), &meta); err != nil {
-		return errors.Wrap(err, "[hooks] [validate]")
+		if e, ok := err.(*json.UnmarshalTypeError); ok {
+			return newValidationError(http.StatusBadRequest, FieldError{e.Field, "must be " + e.Type.String()})
+		}
+		return newValidationError(http.StatusBadRequest, FieldError{"data", err.Error()})
 	}
-	if err := preValidate(meta); err != nil {
+	if err := hook.dataAgent.IsUnique(meta); err != nil {
+		if errors.Cause(err) == usecases.ErrNotUnique {
+			return newValidationError(http.StatusConflict,
+				FieldError{"SerialNumber", "has upload with the same LogCollectionTimestamp"},
+				FieldError{"LogCollectionTimestamp", "has upload with the same SerialNumber"})
+		}
 		return errors.Wrap(err, "[hooks] [validate]")
 	}
-	if err := hook.dataAgent.IsUnique(meta); err != nil {
+	// Upload without destination is rejected before it is created
+	if _, err := hook.dataAgent.Route(meta); err != nil {
+		if errors.Cause(err) == usecases.ErrNoRoute {
+			return newValidationError(http.StatusUnprocessableEntity, FieldError{"data", "matches no route"})
+		}
 		return errors.Wrap(err, "[hooks] [validate]")
 	}
 	return nil
 }
 
 func (hook *hooksHandler) Create(id string, data string, name string) error {
-	if !json.Valid([]byte(data)) {
-		return errors.New("[hooks] [create] invalid json in metadata")
+	// File is already created by tusd, metadata is stored anyway,
+	// otherwise the file would be orphaned
+	if err := hook.schema.validate(data); err != nil {
+		hook.stdout.Printf("[hooks] [create]: id = %s doesn't match schema: %s\n", id, err)
+	}
+	attributes, err := hook.attributes(data)
+	if err != nil {
+		return errors.Wrap(err, "[hooks] [create]")
 	}
 	meta := usecases.Data{}
 	if err := json.Unmarshal([]byte(data), &meta); err != nil {
 		return errors.Wrap(err, "Hook Create")
 	}
+	meta.Attributes = attributes
 	meta.SessionID = id
 	meta.FileName = name
 	// Old: meta.StartTimestamp = time.Now().Format("2006/01/02 15:04:05.999")
@@ -73,18 +183,43 @@ func (hook *hooksHandler) Create(id string, data string, name string) error {
 		return errors.Wrap(err, "[hooks] [create]")
 	}
 	hook.stdout.Printf("[hooks] [create]: id = %s\n", id)
+	hook.notify(eventCreated, id, "", "")
+	if hook.stream != nil {
+		route, err := hook.dataAgent.Route(meta)
+		if err == nil {
+			err = hook.stream.Stream(id, name, meta.SerialNumber, route, metadata(meta))
+		}
+		if err != nil {
+			hook.stdout.Printf("[hooks] [create]: id = %s isn't streamed: %s\n", id, err)
+		}
+	}
 	return nil
 }
 
 func (hook *hooksHandler) Progress(id string) error {
+	if hook.stream != nil {
+		hook.stream.Progress(id)
+	}
 	return nil
 }
 
 func (hook *hooksHandler) Terminate(id string) error {
+	if hook.stream != nil {
+		hook.stream.Abort(id)
+	}
+	// Metadata is deleted, so the event is built before
+	var meta usecases.Data
+	if hook.notifier != nil {
+		meta, _ = hook.dataAgent.Read(id)
+		meta.SessionID = id
+	}
 	if err := hook.dataAgent.Delete(id); err != nil {
 		return errors.Wrap(err, "[hooks] [terminate]")
 	}
 	hook.stdout.Printf("[hooks] [terminate]: id = %s\n", id)
+	if hook.notifier != nil {
+		hook.notifier.Notify(eventTerminated, id, "", metadata(meta), "")
+	}
 	return nil
 }
 
@@ -95,9 +230,32 @@ func (hook *hooksHandler) Complete(id string) error {
 	if err := hook.dataAgent.Update(id, meta); err != nil {
 		return errors.Wrap(err, "[hooks] [complete]")
 	}
+	if err := hook.dataAgent.Transit(id, usecases.StateComplete, ""); err != nil {
+		return errors.Wrap(err, "[hooks] [complete]")
+	}
 	hook.stdout.Printf("[hooks] [complete]: id = %s\n", id)
+	// Corrupted upload isn't forwarded
+	valid, err := hook.dataAgent.Verify(id)
+	if err != nil {
+		return errors.Wrap(err, "[hooks] [complete]")
+	}
 	meta, _ = hook.dataAgent.Read(id)
 	hook.stdout.Printf("[hooks] [complete]: metadata = %v\n", meta)
+	if !valid {
+		// Upload with checksum isn't streamed, stream is stopped anyway
+		if hook.stream != nil {
+			hook.stream.Abort(id)
+		}
+		err := errors.Errorf("[hooks] [complete] upload %s is corrupted: checksum %s, computed %s",
+			id, meta.Checksum, meta.ComputedChecksum)
+		if hook.notifier != nil {
+			hook.notifier.Notify(eventFailed, id, "", metadata(meta), err.Error())
+		}
+		return err
+	}
+	if hook.notifier != nil {
+		hook.notifier.Notify(eventComplete, id, "", metadata(meta), "")
+	}
 
 	if err := hook.dataAgent.Send(id); err != nil {
 		return errors.Wrap(err, "[hooks] [complete]")
@@ -105,44 +263,59 @@ func (hook *hooksHandler) Complete(id string) error {
 	return nil
 }
 
-func (hook *hooksHandler) GetChanTerm() chan string {
-	return hook.clientHooks.GetChanTerm()
-}
-func preValidate(data usecases.Data) error {
-	var err error
-	if data.SerialNumber == "" {
-		return errors.New("A SerialNumber may not be empty")
-	}
-	if _, err = time.Parse(time.UnixDate, data.LogCollectionTimestamp); err != nil {
-		return errors.New("A LogCollectionTimestamp is invalid format")
+// Dispatch - save sinks of upload in metadata, return sinks which upload
+// isn't forwarded to yet
+func (hook *hooksHandler) Dispatch(id string, sinks map[string]bool) ([]string, error) {
+	pending, err := hook.dataAgent.Dispatch(id, sinks)
+	if err != nil {
+		return nil, errors.Wrap(err, "[hooks] [dispatch]")
 	}
-	if _, err = time.Parse(time.UnixDate, data.ClientStartTimestamp); err != nil {
-		return errors.New("A ClientStartTimestamp is invalid format")
+	hook.stdout.Printf("[hooks] [dispatch]: id = %s; sinks = %v\n", id, pending)
+	return pending, nil
+}
+
+// Retried - save failed attempt of forwarding to sink in metadata
+func (hook *hooksHandler) Retried(id string, sink string, attempts int, reason string) error {
+	if _, err := hook.dataAgent.Deliver(id, sink, usecases.StateComplete, attempts, reason, ""); err != nil {
+		return errors.Wrap(err, "[hooks] [retried]")
 	}
-	err = maxLen(err, maxLength, data.Service)
-	err = maxLen(err, maxLength, data.SerialNumber)
-	err = maxLen(err, maxLength, data.LogCollectionTimestamp)
-	err = maxLen(err, maxLength, data.ClientStartTimestamp)
-	err = maxLen(err, maxLength, data.SystemType)
-	err = maxLen(err, maxLength, data.LogLevel)
-	err = maxLen(err, maxLength, data.Originator)
-	err = maxLen(err, maxLength, data.SessionID)
-	err = maxLen(err, maxLength, data.Checksum)
-	err = maxLen(err, maxLength, data.Hostname)
-	err = maxLen(err, maxLength, data.NotificationManager)
-	err = maxLen(err, maxLength, data.Cancel)
-	err = maxLen(err, maxLength, data.StartTimestamp)
-	err = maxLen(err, maxLength, data.FinishTimestamp)
+	hook.stdout.Printf("[hooks] [retried]: id = %s; sink = %s; attempts = %d\n", id, sink, attempts)
+	return nil
+}
 
-	return err
+// Failed - save in metadata that forwarding to sink is failed after all
+// attempts, return true if file of upload may be deleted
+func (hook *hooksHandler) Failed(id string, sink string, attempts int, reason string) (bool, error) {
+	purge, err := hook.dataAgent.Deliver(id, sink, usecases.StateFailed, attempts, reason, "")
+	if err != nil {
+		return false, errors.Wrap(err, "[hooks] [failed]")
+	}
+	hook.stdout.Printf("[hooks] [failed]: id = %s; sink = %s; attempts = %d\n", id, sink, attempts)
+	hook.notify(eventFailed, id, sink, reason)
+	return purge, nil
 }
 
-func maxLen(err error, max int, str string) error {
+// Forwarded - save in metadata that upload is delivered to sink with id
+// returned by sink, return true if file of upload may be deleted
+func (hook *hooksHandler) Forwarded(id string, sink string, remote string) (bool, error) {
+	purge, err := hook.dataAgent.Deliver(id, sink, usecases.StateForwarded, 0, "", remote)
 	if err != nil {
-		return err
+		return false, errors.Wrap(err, "[hooks] [forwarded]")
 	}
-	if len(str) > maxLength {
-		return errors.Errorf("Max length of string in metadata is %d", maxLength)
+	hook.stdout.Printf("[hooks] [forwarded]: id = %s; sink = %s; remote id = %s\n", id, sink, remote)
+	hook.notify(eventForwarded, id, sink, "")
+	return purge, nil
+}
+
+// Purge - delete file of upload which is forwarded, metadata is kept
+func (hook *hooksHandler) Purge(id string) error {
+	if err := hook.dataAgent.Purge(id); err != nil {
+		return errors.Wrap(err, "[hooks] [purge]")
 	}
+	hook.stdout.Printf("[hooks] [purge]: id = %s\n", id)
 	return nil
 }
+
+func (hook *hooksHandler) GetChanTerm() chan string {
+	return hook.clientHooks.GetChanTerm()
+}
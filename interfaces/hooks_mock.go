@@ -29,6 +29,10 @@ func (m *HooksHandlerMock) Complete(id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
+func (m *HooksHandlerMock) Purge(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
 func (m *HooksHandlerMock) GetChanTerm() chan string {
 	args := m.Called()
 	return args.Get(0).(chan string)
@@ -42,3 +46,26 @@ func (m *clientHooks) GetChanTerm() chan string {
 	args := m.Called()
 	return args.Get(0).(chan string)
 }
+
+type StreamHandlerMock struct {
+	mock.Mock
+}
+
+func (m *StreamHandlerMock) Stream(id string, name string, system string, route string, meta map[string]string) error {
+	args := m.Called(id, name, system, route, meta)
+	return args.Error(0)
+}
+func (m *StreamHandlerMock) Progress(id string) {
+	m.Called(id)
+}
+func (m *StreamHandlerMock) Abort(id string) {
+	m.Called(id)
+}
+
+type NotifyHandlerMock struct {
+	mock.Mock
+}
+
+func (m *NotifyHandlerMock) Notify(event string, id string, sink string, meta map[string]string, reason string) {
+	m.Called(event, id, sink, meta, reason)
+}
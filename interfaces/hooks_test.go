@@ -1,8 +1,11 @@
 package interfaces
 
 import (
+	"encoding/json"
 	"log"
+	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +13,7 @@ import (
 	"b.yadro.com/sys/ch-server/usecases"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func newDataAgent() dataAgent {
@@ -79,6 +83,110 @@ func TestValidate(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestValidateErrors(t *testing.T) {
+	repo := new(usecases.DataRepositoryMock)
+	dataAgent, _ := usecases.NewDataAgent(repo, new(usecases.HttpClientMock))
+	hooksHandler, _ := NewHooksHandler(
+		dataAgent,
+		new(clientHooks),
+		log.New(os.Stdout, "[test] ", log.LstdFlags))
+	repo.On("FindById", "0123456789Thu Aug 17 14:00:06 MSK 2019").Return(domain.Data{}, errors.New("fail"))
+	repo.On("FindById", "duplicateThu Aug 17 14:00:06 MSK 2019").Return(domain.Data{}, nil)
+	// validate - return status and offending fields of rejection
+	validate := func(data string) (int, []string) {
+		err := hooksHandler.Validate("0123456789", data)
+		e, ok := err.(*ValidationError)
+		if !assert.True(t, ok, "validate: error isn't ValidationError: %v", err) {
+			return 0, nil
+		}
+		var body ValidationError
+		assert.Nil(t, json.Unmarshal(e.Body(), &body))
+		assert.Equal(t, e.StatusCode(), body.Status)
+		var fields []string
+		for _, f := range body.Fields {
+			fields = append(fields, f.Field)
+		}
+		return e.StatusCode(), fields
+	}
+
+	t.Run("invalid json", func(t *testing.T) {
+		status, fields := validate(`{"SerialNumber": `)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, []string{"data"}, fields)
+		status, fields = validate(`{"SerialNumber": 42}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, []string{"SerialNumber"}, fields)
+	})
+	t.Run("invalid fields", func(t *testing.T) {
+		status, fields := validate(`{
+			"LogCollectionTimestamp": "2019-08-17",
+			"ClientStartTimestamp"  : "Thu Oct 17 14:00:06 MSK 2019",
+			"Hostname"              : "` + strings.Repeat("h", maxLength+1) + `"
+			}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+		assert.Equal(t, []string{"SerialNumber", "LogCollectionTimestamp", "Hostname"}, fields)
+	})
+	t.Run("invalid too long field", func(t *testing.T) {
+		status, fields := validate(`{
+			"SerialNumber"          : "0123456789",
+			"LogCollectionTimestamp": "Thu Aug 17 14:00:06 MSK 2019",
+			"ClientStartTimestamp"  : "Thu Oct 17 14:00:06 MSK 2019",
+			"Checksum"              : "` + strings.Repeat("0", maxLengthChecksum+1) + `"
+			}`)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+		assert.Equal(t, []string{"Checksum"}, fields)
+	})
+	t.Run("invalid duplicate", func(t *testing.T) {
+		status, fields := validate(`{
+			"SerialNumber"          : "duplicate",
+			"LogCollectionTimestamp": "Thu Aug 17 14:00:06 MSK 2019",
+			"ClientStartTimestamp"  : "Thu Oct 17 14:00:06 MSK 2019"
+			}`)
+		assert.Equal(t, http.StatusConflict, status)
+		assert.Equal(t, []string{"SerialNumber", "LogCollectionTimestamp"}, fields)
+	})
+	t.Run("invalid unmatched route", func(t *testing.T) {
+		assert.Nil(t, dataAgent.SetRoutes([]usecases.Route{{Name: "storage", SystemType: "storage"}}, true))
+		defer dataAgent.SetRoutes(nil, false)
+		status, _ := validate(`{
+			"SerialNumber"          : "0123456789",
+			"LogCollectionTimestamp": "Thu Aug 17 14:00:06 MSK 2019",
+			"ClientStartTimestamp"  : "Thu Oct 17 14:00:06 MSK 2019"
+			}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+	})
+}
+
+func TestValidateRoute(t *testing.T) {
+	repo := new(usecases.DataRepositoryMock)
+	dataAgent, _ := usecases.NewDataAgent(repo, new(usecases.HttpClientMock))
+	assert.Nil(t, dataAgent.SetRoutes([]usecases.Route{{Name: "storage", SystemType: "storage"}}, true))
+	hooksHandler, _ := NewHooksHandler(
+		dataAgent,
+		new(clientHooks),
+		log.New(os.Stdout, "[test] ", log.LstdFlags))
+	id := "0123456789"
+	repo.On("FindById", mock.Anything).Return(domain.Data{}, errors.New("fail"))
+	t.Run("valid matched route", func(t *testing.T) {
+		err := hooksHandler.Validate(id, `{
+			"SerialNumber"          : "0123456789",
+			"SystemType"            : "storage",
+			"LogCollectionTimestamp": "Thu Aug 17 14:00:06 MSK 2019",
+			"ClientStartTimestamp"  : "Thu Oct 17 14:00:06 MSK 2019"
+			}`)
+		assert.Nil(t, err)
+	})
+	t.Run("invalid unmatched upload", func(t *testing.T) {
+		err := hooksHandler.Validate(id, `{
+			"SerialNumber"          : "0123456789",
+			"SystemType"            : "server",
+			"LogCollectionTimestamp": "Thu Aug 17 14:00:06 MSK 2019",
+			"ClientStartTimestamp"  : "Thu Oct 17 14:00:06 MSK 2019"
+			}`)
+		assert.Error(t, err)
+	})
+}
+
 func TestCreate(t *testing.T) {
 	repo := new(usecases.DataRepositoryMock)
 	client := new(usecases.HttpClientMock)
@@ -104,10 +212,30 @@ func TestCreate(t *testing.T) {
 	meta.LogCollectionTimestamp = "Thu Aug 17 14:00:06 MSK 2019"
 	meta.ClientStartTimestamp = "Thu Oct 17 14:00:06 MSK 2019"
 	meta.StartTimestamp = time.Now().Format(time.UnixDate)
+	meta.State = domain.StateCreated
+	meta.History = []domain.Transition{{State: domain.StateCreated, Timestamp: meta.StartTimestamp}}
 	repo.On("Store", meta).Return(nil)
 	err = hooksHandler.Create(id, data, name)
 	repo.AssertCalled(t, "Store", meta)
 	assert.Nil(t, err)
+	t.Run("valid stream", func(t *testing.T) {
+		stream := new(StreamHandlerMock)
+		assert.Nil(t, hooksHandler.SetStreamHandler(stream))
+		stream.On("Stream", id, name, "0123456789", "", mock.Anything).Return(errors.New("fail"))
+		stream.On("Progress", id).Return()
+		stream.On("Abort", id).Return()
+		repo.On("Remove", id).Return(nil)
+		// upload isn't streamed, but it is created
+		assert.Nil(t, hooksHandler.Create(id, data, name))
+		assert.Nil(t, hooksHandler.Progress(id))
+		assert.Nil(t, hooksHandler.Terminate(id))
+		stream.AssertCalled(t, "Stream", id, name, "0123456789", "", mock.Anything)
+		stream.AssertCalled(t, "Progress", id)
+		stream.AssertCalled(t, "Abort", id)
+	})
+	t.Run("invalid stream", func(t *testing.T) {
+		assert.NotNil(t, hooksHandler.SetStreamHandler(nil))
+	})
 }
 func TestTerminate(t *testing.T) {
 	repo := new(usecases.DataRepositoryMock)
@@ -147,12 +275,191 @@ func TestComplete(t *testing.T) {
 	meta.ClientStartTimestamp = "Thu Oct 17 14:00:06 MSK 2019"
 	meta.StartTimestamp = time.Now().Format(time.UnixDate)
 	meta.FinishTimestamp = time.Now().Format(time.UnixDate)
+	meta.State = domain.StateCreated
 	repo.On("FindById", id).Return(meta, nil)
 	repo.On("Store", meta).Return(nil)
-	client.On("Send", id, name, id).Return(nil)
+	complete := func(d domain.Data) bool {
+		return d.State == domain.StateComplete
+	}
+	repo.On("Store", mock.MatchedBy(complete)).Return(nil)
+	verified := meta
+	verified.ChecksumResult = domain.ChecksumMissing
+	repo.On("Store", verified).Return(nil)
+	client.On("Send", id, mock.MatchedBy(func(d usecases.Data) bool { return d.FileName == name }), "").Return(nil)
 	err = hooksHandler.Complete(id)
 	repo.AssertCalled(t, "FindById", id)
 	repo.AssertCalled(t, "Store", meta)
-	client.AssertCalled(t, "Send", id, name, id)
+	repo.AssertCalled(t, "Store", mock.MatchedBy(complete))
+	repo.AssertCalled(t, "Store", verified)
+	client.AssertCalled(t, "Send", id, mock.Anything, "")
+	assert.Nil(t, err)
+}
+
+func TestCompleteCorrupted(t *testing.T) {
+	repo := new(usecases.DataRepositoryMock)
+	client := new(usecases.HttpClientMock)
+	dataAgent, err := usecases.NewDataAgent(
+		repo,
+		client)
 	assert.Nil(t, err)
+	hooksHandler, _ := NewHooksHandler(
+		dataAgent,
+		new(clientHooks),
+		log.New(os.Stdout, "[test] ", log.LstdFlags))
+	id := "0123456789"
+	meta := domain.Data{}
+	meta.SerialNumber = id
+	meta.SessionID = id
+	meta.FileName = "logs.tar"
+	meta.Checksum = "md5:d41d8cd98f00b204e9800998ecf8427e"
+	meta.State = domain.StateCreated
+	repo.On("FindById", id).Return(meta, nil).Twice()
+	complete := meta
+	complete.State = domain.StateComplete
+	repo.On("FindById", id).Return(complete, nil)
+	repo.On("Checksum", id, "md5").Return("00000000000000000000000000000000", nil)
+	corrupted := func(d domain.Data) bool {
+		return d.State == domain.StateCorrupted &&
+			d.ChecksumResult == domain.ChecksumMismatch &&
+			d.ComputedChecksum == "md5:00000000000000000000000000000000"
+	}
+	repo.On("Store", mock.MatchedBy(corrupted)).Return(nil)
+	repo.On("Store", mock.Anything).Return(nil)
+	err = hooksHandler.Complete(id)
+	assert.NotNil(t, err)
+	repo.AssertCalled(t, "Store", mock.MatchedBy(corrupted))
+	client.AssertNotCalled(t, "Send", id, mock.Anything, "")
+}
+
+func TestRetriedFailed(t *testing.T) {
+	repo := new(usecases.DataRepositoryMock)
+	client := new(usecases.HttpClientMock)
+	dataAgent, err := usecases.NewDataAgent(
+		repo,
+		client)
+	assert.Nil(t, err)
+	hooksHandler, _ := NewHooksHandler(
+		dataAgent,
+		new(clientHooks),
+		log.New(os.Stdout, "[test] ", log.LstdFlags))
+	id := "0123456789"
+	meta := domain.Data{}
+	meta.SerialNumber = id
+	meta.SessionID = id
+	meta.FileName = "logs.tar"
+	meta.State = domain.StateComplete
+	repo.On("FindById", id).Return(meta, nil)
+	retried := func(d domain.Data) bool {
+		return d.State == domain.StateComplete && d.Attempts == 1 && d.LastError == "status code = 503" &&
+			d.Deliveries["syr"].State == domain.StateComplete
+	}
+	repo.On("Store", mock.MatchedBy(retried)).Return(nil)
+	err = hooksHandler.Retried(id, "syr", 1, "status code = 503")
+	assert.Nil(t, err)
+	repo.AssertCalled(t, "Store", mock.MatchedBy(retried))
+	failed := func(d domain.Data) bool {
+		return d.State == domain.StateFailed && d.Attempts == 5 && d.LastError == "status code = 400"
+	}
+	repo.On("Store", mock.MatchedBy(failed)).Return(errors.New("fail"))
+	purge, err := hooksHandler.Failed(id, "syr", 5, "status code = 400")
+	assert.NotNil(t, err)
+	assert.False(t, purge)
+	repo.AssertCalled(t, "Store", mock.MatchedBy(failed))
+}
+
+func TestForwardedPurge(t *testing.T) {
+	repo := new(usecases.DataRepositoryMock)
+	client := new(usecases.HttpClientMock)
+	dataAgent, err := usecases.NewDataAgent(
+		repo,
+		client)
+	assert.Nil(t, err)
+	hooksHandler, _ := NewHooksHandler(
+		dataAgent,
+		new(clientHooks),
+		log.New(os.Stdout, "[test] ", log.LstdFlags))
+	id := "0123456789"
+	meta := domain.Data{}
+	meta.SerialNumber = id
+	meta.SessionID = id
+	meta.FileName = "logs.tar"
+	meta.State = domain.StateComplete
+	repo.On("FindById", id).Return(meta, nil)
+	forwarded := func(d domain.Data) bool {
+		return d.State == domain.StateForwarded && len(d.History) == 1 &&
+			d.RemoteID == "SYR-42" && d.Deliveries["syr"].RemoteID == "SYR-42"
+	}
+	repo.On("Store", mock.MatchedBy(forwarded)).Return(nil)
+	purge, err := hooksHandler.Forwarded(id, "syr", "SYR-42")
+	assert.Nil(t, err)
+	assert.True(t, purge)
+	repo.AssertCalled(t, "Store", mock.MatchedBy(forwarded))
+	repo.On("Purge", id).Return(nil)
+	err = hooksHandler.Purge(id)
+	assert.Nil(t, err)
+	repo.AssertCalled(t, "Purge", id)
+	repo.AssertNotCalled(t, "Remove", id)
+}
+
+func TestDispatch(t *testing.T) {
+	repo := new(usecases.DataRepositoryMock)
+	dataAgent, _ := usecases.NewDataAgent(repo, new(usecases.HttpClientMock))
+	hooksHandler, _ := NewHooksHandler(
+		dataAgent,
+		new(clientHooks),
+		log.New(os.Stdout, "[test] ", log.LstdFlags))
+	id := "0123456789"
+	meta := domain.Data{SessionID: id, SerialNumber: id, FileName: "logs.tar", State: domain.StateComplete}
+	repo.On("FindById", id).Return(meta, nil)
+	repo.On("Store", mock.Anything).Return(nil)
+	pending, err := hooksHandler.Dispatch(id, map[string]bool{"syr": true, "archive": false})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"archive", "syr"}, pending)
+	repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+		return d.Deliveries["syr"].Required && !d.Deliveries["archive"].Required
+	}))
+}
+
+func TestNotify(t *testing.T) {
+	repo := new(usecases.DataRepositoryMock)
+	dataAgent, _ := usecases.NewDataAgent(repo, new(usecases.HttpClientMock))
+	hooksHandler, _ := NewHooksHandler(
+		dataAgent,
+		new(clientHooks),
+		log.New(os.Stdout, "[test] ", log.LstdFlags))
+	notifier := new(NotifyHandlerMock)
+	notifier.On("Notify", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	assert.Error(t, hooksHandler.SetNotifyHandler(nil))
+	assert.Nil(t, hooksHandler.SetNotifyHandler(notifier))
+	id := "0123456789"
+	meta := domain.Data{SessionID: id, SerialNumber: id, FileName: "logs.tar", State: domain.StateComplete}
+	repo.On("FindById", id).Return(meta, nil)
+	repo.On("Store", mock.Anything).Return(nil)
+	repo.On("Remove", id).Return(nil)
+	withFile := mock.MatchedBy(func(m map[string]string) bool {
+		return m["SessionID"] == id && m["FileName"] == "logs.tar"
+	})
+
+	t.Run("valid created", func(t *testing.T) {
+		assert.Nil(t, hooksHandler.Create(id, `{
+			"SerialNumber"          : "0123456789",
+			"LogCollectionTimestamp": "Thu Aug 17 14:00:06 MSK 2019",
+			"ClientStartTimestamp"  : "Thu Oct 17 14:00:06 MSK 2019"
+			}`, "logs.tar"))
+		notifier.AssertCalled(t, "Notify", eventCreated, id, "", withFile, "")
+	})
+	t.Run("valid forwarded", func(t *testing.T) {
+		_, err := hooksHandler.Forwarded(id, "syr", "SYR-42")
+		assert.Nil(t, err)
+		notifier.AssertCalled(t, "Notify", eventForwarded, id, "syr", withFile, "")
+	})
+	t.Run("valid failed", func(t *testing.T) {
+		_, err := hooksHandler.Failed(id, "archive", 3, "disk is full")
+		assert.Nil(t, err)
+		notifier.AssertCalled(t, "Notify", eventFailed, id, "archive", withFile, "disk is full")
+	})
+	t.Run("valid terminated", func(t *testing.T) {
+		assert.Nil(t, hooksHandler.Terminate(id))
+		notifier.AssertCalled(t, "Notify", eventTerminated, id, "", withFile, "")
+	})
 }
@@ -0,0 +1,248 @@
+package interfaces
+
+import (
+	"bytes"
+	"encoding/hex"
+	"time"
+
+	"b.yadro.com/sys/ch-server/domain"
+	"github.com/pkg/errors"
+)
+
+// separator - split indexed value and SessionID in key of index
+const separator = 0
+
+// index - secondary index of Data, key of index is value of field
+// followed by separator and SessionID
+type index struct {
+	name  string
+	value func(data domain.Data) []byte
+}
+
+var (
+	indexSerialNumber = index{"serial", func(data domain.Data) []byte { return []byte(data.SerialNumber) }}
+	indexHostname     = index{"hostname", func(data domain.Data) []byte { return []byte(data.Hostname) }}
+	indexSystemType   = index{"system", func(data domain.Data) []byte { return []byte(data.SystemType) }}
+	indexState        = index{"state", func(data domain.Data) []byte { return []byte(data.State) }}
+	indexRemoteID     = index{"remote", func(data domain.Data) []byte { return []byte(data.RemoteID) }}
+	// indexTimestamp - keeps Data sorted by LogCollectionTimestamp,
+	// Data with invalid timestamp is at the beginning
+	indexTimestamp = index{"timestamp", func(data domain.Data) []byte { return itob(int(timestamp(data))) }}
+)
+
+var indexes = []index{
+	indexSerialNumber,
+	indexHostname,
+	indexSystemType,
+	indexState,
+	indexRemoteID,
+	indexTimestamp,
+}
+
+func timestamp(data domain.Data) int64 {
+	t, err := time.Parse(time.UnixDate, data.LogCollectionTimestamp)
+	if err != nil || t.Unix() < 0 {
+		return 0
+	}
+	return t.Unix()
+}
+
+func (repo *DbDataRepo) indexBucket(idx index) []byte {
+	return []byte(repo.bucket + ".idx." + idx.name)
+}
+
+func (idx index) key(data domain.Data) []byte {
+	return idx.keyOf(idx.value(data), data.SessionID)
+}
+
+func (idx index) keyOf(value []byte, id string) []byte {
+	key := make([]byte, 0, len(value)+1+len(id))
+	key = append(key, value...)
+	key = append(key, separator)
+	return append(key, id...)
+}
+
+// id - return SessionID from key of index
+func (idx index) id(key []byte) string {
+	return string(key[bytes.LastIndexByte(key, separator)+1:])
+}
+
+// updateIndexes - replace keys of old Data by keys of new Data in all indexes,
+// empty Data has no keys
+func (repo *DbDataRepo) updateIndexes(tx DbTx, old, data domain.Data) error {
+	for _, idx := range indexes {
+		var oldkey, newkey []byte
+		if old.SessionID != "" {
+			oldkey = idx.key(old)
+		}
+		if data.SessionID != "" {
+			newkey = idx.key(data)
+		}
+		if bytes.Equal(oldkey, newkey) {
+			continue
+		}
+		if oldkey != nil {
+			if err := tx.Delete(repo.indexBucket(idx), oldkey); err != nil {
+				return errors.Wrapf(err, "index %s", idx.name)
+			}
+		}
+		if newkey != nil {
+			if err := tx.Create(repo.indexBucket(idx), newkey, []byte{}); err != nil {
+				return errors.Wrapf(err, "index %s", idx.name)
+			}
+		}
+	}
+	return nil
+}
+
+// lookup - return set of SessionID with value of field in index
+func (repo *DbDataRepo) lookup(tx DbTx, idx index, value string) (map[string]bool, error) {
+	min := idx.keyOf([]byte(value), "")
+	max := append([]byte(value), separator+1)
+	keys, err := tx.Range(repo.indexBucket(idx), min, max)
+	if err != nil {
+		return nil, errors.Wrapf(err, "index %s", idx.name)
+	}
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[idx.id(key)] = true
+	}
+	return set, nil
+}
+
+// Query - find data by indexes, return page of data and cursor of next page,
+// cursor is empty on the last page
+func (repo *DbDataRepo) Query(query domain.Query) ([]domain.Data, string, error) {
+	var list []domain.Data
+	var next string
+	err := repo.dbHandler.View(func(tx DbTx) error {
+		var err error
+		list, next, err = repo.query(tx, query)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return list, next, nil
+}
+
+func (repo *DbDataRepo) query(tx DbTx, query domain.Query) ([]domain.Data, string, error) {
+	// Candidates are intersection of all equality conditions, nil is any
+	var candidates map[string]bool
+	conditions := []struct {
+		idx   index
+		value string
+	}{
+		{indexSerialNumber, query.SerialNumber},
+		{indexHostname, query.Hostname},
+		{indexSystemType, query.SystemType},
+		{indexState, string(query.State)},
+		{indexRemoteID, query.RemoteID},
+	}
+	for _, c := range conditions {
+		if c.value == "" {
+			continue
+		}
+		set, err := repo.lookup(tx, c.idx, c.value)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "[repositories] [query]")
+		}
+		if candidates != nil {
+			for id := range candidates {
+				if !set[id] {
+					delete(candidates, id)
+				}
+			}
+		} else {
+			candidates = set
+		}
+	}
+	// Order and range of timestamps are read from timestamp index
+	var min, max []byte
+	if !query.From.IsZero() {
+		min = itob(int(query.From.Unix()))
+	}
+	if !query.To.IsZero() {
+		max = itob(int(query.To.Unix() + 1))
+	}
+	keys, err := tx.Range(repo.indexBucket(indexTimestamp), min, max)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "[repositories] [query]")
+	}
+	if query.Descending {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	cursor, err := hex.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "[repositories] [query] bad cursor")
+	}
+	list := make([]domain.Data, 0, query.Limit)
+	for _, key := range keys {
+		if len(cursor) > 0 {
+			if c := bytes.Compare(key, cursor); (!query.Descending && c <= 0) || (query.Descending && c >= 0) {
+				continue
+			}
+		}
+		id := indexTimestamp.id(key)
+		if candidates != nil && !candidates[id] {
+			continue
+		}
+		data, err := repo.findById(tx, id)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "[repositories] [query]")
+		}
+		// Attributes aren't indexed, they are tested on found data
+		if !hasAttributes(data, query.Attributes) {
+			continue
+		}
+		if query.Limit > 0 && len(list) == query.Limit {
+			last := list[len(list)-1]
+			return list, hex.EncodeToString(indexTimestamp.key(last)), nil
+		}
+		list = append(list, data)
+	}
+	return list, "", nil
+}
+
+// hasAttributes - test that data has all attributes with the same values
+func hasAttributes(data domain.Data, attributes map[string]string) bool {
+	for k, v := range attributes {
+		if value, ok := data.Attributes[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Reindex - build indexes for data which is stored without them,
+// is invoked on start to upgrade database
+func (repo *DbDataRepo) Reindex() (int, error) {
+	count := 0
+	err := repo.dbHandler.Update(func(tx DbTx) error {
+		keys, err := tx.Keys([]byte(repo.bucket))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			data, err := repo.findById(tx, string(key))
+			if err != nil {
+				return err
+			}
+			// Data is stored under SessionID and under unique key, only the first is indexed
+			if data.SessionID != string(key) {
+				continue
+			}
+			if err := repo.updateIndexes(tx, domain.Data{}, data); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "[repositories] [reindex]")
+	}
+	return count, nil
+}
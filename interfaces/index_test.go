@@ -0,0 +1,220 @@
+package interfaces
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+	"time"
+
+	"b.yadro.com/sys/ch-server/domain"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// memDb - sorted in-memory database to test indexes
+type memDb map[string]map[string][]byte
+
+func (db memDb) Create(bucket []byte, key []byte, value []byte) error {
+	if db[string(bucket)] == nil {
+		db[string(bucket)] = make(map[string][]byte)
+	}
+	db[string(bucket)][string(key)] = value
+	return nil
+}
+
+func (db memDb) Get(bucket []byte, key []byte) ([]byte, error) {
+	v, ok := db[string(bucket)][string(key)]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return v, nil
+}
+
+func (db memDb) Delete(bucket []byte, key []byte) error {
+	delete(db[string(bucket)], string(key))
+	return nil
+}
+
+func (db memDb) Keys(bucket []byte) ([][]byte, error) {
+	return db.Range(bucket, nil, nil)
+}
+
+func (db memDb) Range(bucket []byte, min []byte, max []byte) ([][]byte, error) {
+	keys := make([]string, 0, len(db[string(bucket)]))
+	for k := range db[string(bucket)] {
+		if (min == nil || bytes.Compare([]byte(k), min) >= 0) &&
+			(max == nil || bytes.Compare([]byte(k), max) < 0) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	values := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, []byte(k))
+	}
+	return values, nil
+}
+
+func (db memDb) Update(fn func(tx DbTx) error) error {
+	return fn(db)
+}
+
+func (db memDb) View(fn func(tx DbTx) error) error {
+	return fn(db)
+}
+
+func (db memDb) Batch(fn func(tx DbTx) error) error {
+	return fn(db)
+}
+
+func newIndexedRepo(t *testing.T) (*DbDataRepo, memDb) {
+	db := make(memDb)
+	repo, err := NewDbDataRepo(db, new(InvokeHandlerMock), "root")
+	assert.Nil(t, err)
+	uploads := []domain.Data{
+		{SessionID: "1", SerialNumber: "A", Hostname: "node1", State: domain.StateComplete,
+			LogCollectionTimestamp: "Thu Aug 01 14:00:00 UTC 2019"},
+		{SessionID: "2", SerialNumber: "B", Hostname: "node1", State: domain.StateCreated,
+			LogCollectionTimestamp: "Fri Aug 02 14:00:00 UTC 2019", Attributes: map[string]string{"Firmware": "1.2"}},
+		{SessionID: "3", SerialNumber: "A", Hostname: "node2", State: domain.StateComplete,
+			LogCollectionTimestamp: "Sat Aug 03 14:00:00 UTC 2019", RemoteID: "SYR-42",
+			Attributes: map[string]string{"Firmware": "1.2", "Rack": "7"}},
+		{SessionID: "4", SerialNumber: "A", Hostname: "node1", State: domain.StateFailed,
+			LogCollectionTimestamp: "Sun Aug 04 14:00:00 UTC 2019", Attributes: map[string]string{"Firmware": "1.3"}},
+	}
+	for _, d := range uploads {
+		assert.Nil(t, repo.Store(d))
+	}
+	return repo, db
+}
+
+func ids(list []domain.Data) []string {
+	s := make([]string, 0, len(list))
+	for _, d := range list {
+		s = append(s, d.SessionID)
+	}
+	return s
+}
+
+func TestQuery(t *testing.T) {
+	repo, _ := newIndexedRepo(t)
+
+	t.Run("valid all", func(t *testing.T) {
+		list, next, err := repo.Query(domain.Query{})
+		assert.Nil(t, err)
+		assert.Equal(t, "", next)
+		assert.Equal(t, []string{"1", "2", "3", "4"}, ids(list))
+	})
+	t.Run("valid filters", func(t *testing.T) {
+		list, _, err := repo.Query(domain.Query{SerialNumber: "A", Hostname: "node1"})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1", "4"}, ids(list))
+		list, _, err = repo.Query(domain.Query{State: domain.StateComplete, Descending: true})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"3", "1"}, ids(list))
+		list, _, err = repo.Query(domain.Query{RemoteID: "SYR-42"})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"3"}, ids(list))
+	})
+	t.Run("valid range of timestamps", func(t *testing.T) {
+		from, _ := time.Parse(time.UnixDate, "Fri Aug 02 14:00:00 UTC 2019")
+		to, _ := time.Parse(time.UnixDate, "Sat Aug 03 14:00:00 UTC 2019")
+		list, _, err := repo.Query(domain.Query{From: from, To: to})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2", "3"}, ids(list))
+	})
+	t.Run("valid pages", func(t *testing.T) {
+		query := domain.Query{SerialNumber: "A", Descending: true, Limit: 2}
+		list, next, err := repo.Query(query)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"4", "3"}, ids(list))
+		assert.NotEqual(t, "", next)
+		query.Cursor = next
+		list, next, err = repo.Query(query)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1"}, ids(list))
+		assert.Equal(t, "", next)
+	})
+	t.Run("valid attributes", func(t *testing.T) {
+		list, _, err := repo.Query(domain.Query{Attributes: map[string]string{"Firmware": "1.2", "Rack": "7"}})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"3"}, ids(list))
+		// The last page of attributes doesn't return cursor
+		query := domain.Query{Attributes: map[string]string{"Firmware": "1.2"}, Limit: 1}
+		list, next, err := repo.Query(query)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2"}, ids(list))
+		query.Cursor = next
+		list, next, err = repo.Query(query)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"3"}, ids(list))
+		assert.Equal(t, "", next)
+		list, _, err = repo.Query(domain.Query{SerialNumber: "B", Attributes: map[string]string{"Firmware": "1.3"}})
+		assert.Nil(t, err)
+		assert.Empty(t, list)
+	})
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, _, err := repo.Query(domain.Query{Cursor: "not hex"})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestIndexesConsistent(t *testing.T) {
+	repo, db := newIndexedRepo(t)
+
+	t.Run("valid store replaces keys", func(t *testing.T) {
+		d, err := repo.FindById("2")
+		assert.Nil(t, err)
+		assert.Nil(t, d.Transit(domain.StateFailed, "fail"))
+		assert.Nil(t, repo.Store(d))
+		list, _, err := repo.Query(domain.Query{State: domain.StateCreated})
+		assert.Nil(t, err)
+		assert.Empty(t, list)
+		list, _, err = repo.Query(domain.Query{State: domain.StateFailed})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2", "4"}, ids(list))
+	})
+	t.Run("valid remove deletes keys", func(t *testing.T) {
+		invoke := repo.invokeHandler.(*InvokeHandlerMock)
+		invoke.On("Remove", "4").Return(nil)
+		assert.Nil(t, repo.Remove("4"))
+		for _, idx := range indexes {
+			keys, _ := db.Keys(repo.indexBucket(idx))
+			assert.Len(t, keys, 3, idx.name)
+		}
+	})
+	t.Run("valid store deletes changed unique key", func(t *testing.T) {
+		d, err := repo.FindById("3")
+		assert.Nil(t, err)
+		stale := d.SerialNumber + d.LogCollectionTimestamp
+		d.LogCollectionTimestamp = "Mon Aug 05 14:00:00 UTC 2019"
+		assert.Nil(t, repo.Store(d))
+		_, err = repo.FindById(stale)
+		assert.NotNil(t, err)
+		_, err = repo.FindById(d.SerialNumber + d.LogCollectionTimestamp)
+		assert.Nil(t, err)
+	})
+	t.Run("invalid remove of file restores data", func(t *testing.T) {
+		invoke := repo.invokeHandler.(*InvokeHandlerMock)
+		invoke.On("Remove", "1").Return(errors.New("fail"))
+		assert.NotNil(t, repo.Remove("1"))
+		d, err := repo.FindById("1")
+		assert.Nil(t, err)
+		_, err = repo.FindById(d.SerialNumber + d.LogCollectionTimestamp)
+		assert.Nil(t, err)
+		list, _, err := repo.Query(domain.Query{SerialNumber: "A"})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1", "3"}, ids(list))
+	})
+	t.Run("valid reindex", func(t *testing.T) {
+		for _, idx := range indexes {
+			delete(db, string(repo.indexBucket(idx)))
+		}
+		count, err := repo.Reindex()
+		assert.Nil(t, err)
+		assert.Equal(t, 3, count)
+		list, _, err := repo.Query(domain.Query{SerialNumber: "A"})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1", "3"}, ids(list))
+	})
+}
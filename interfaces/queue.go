@@ -0,0 +1,54 @@
+package interfaces
+
+import "github.com/pkg/errors"
+
+// DbQueue - implement interface queueHandler from infrastructure/clienthandler
+// to keep jobs of forwarding in a dedicated bucket of database
+type DbQueue struct {
+	dbHandler DbHandler
+	bucket    string
+}
+
+// NewDbQueue - create instance of DbQueue over the bucket
+func NewDbQueue(dbHandler DbHandler, bucket string) (*DbQueue, error) {
+	if dbHandler == nil || bucket == "" {
+		return nil, errors.New("[queue] [new] bad argument")
+	}
+	return &DbQueue{dbHandler, bucket}, nil
+}
+
+// Push - invoke db methods to save job in queue, job with the same id is replaced
+func (queue *DbQueue) Push(id string, job []byte) error {
+	if id == "" {
+		return errors.New("[queue] [push] bad id")
+	}
+	if err := queue.dbHandler.Create([]byte(queue.bucket), []byte(id), job); err != nil {
+		return errors.Wrap(err, "[queue] [push]")
+	}
+	return nil
+}
+
+// Remove - invoke db methods to delete job from queue
+func (queue *DbQueue) Remove(id string) error {
+	if err := queue.dbHandler.Delete([]byte(queue.bucket), []byte(id)); err != nil {
+		return errors.Wrap(err, "[queue] [remove]")
+	}
+	return nil
+}
+
+// ReadAll - invoke db methods to read all jobs from queue
+func (queue *DbQueue) ReadAll() ([][]byte, error) {
+	keys, err := queue.dbHandler.Keys([]byte(queue.bucket))
+	if err != nil {
+		return nil, errors.Wrap(err, "[queue] [readAll]")
+	}
+	jobs := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		job, err := queue.dbHandler.Get([]byte(queue.bucket), key)
+		if err != nil {
+			return nil, errors.Wrap(err, "[queue] [readAll]")
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
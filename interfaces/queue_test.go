@@ -0,0 +1,70 @@
+package interfaces
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewDbQueue(t *testing.T) {
+	t.Run("valid New", func(t *testing.T) {
+		q, err := NewDbQueue(new(DbHandlerMock), "outbox")
+		assert.NotNil(t, q)
+		assert.Nil(t, err)
+	})
+	t.Run("invalid DbHandler", func(t *testing.T) {
+		q, err := NewDbQueue(nil, "outbox")
+		assert.Nil(t, q)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalid bucket", func(t *testing.T) {
+		q, err := NewDbQueue(new(DbHandlerMock), "")
+		assert.Nil(t, q)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestQueuePush(t *testing.T) {
+	db := new(DbHandlerMock)
+	queue, _ := NewDbQueue(db, "outbox")
+	id := "0123456789"
+	job := []byte(`{"id":"0123456789"}`)
+	db.On("Create", []byte("outbox"), []byte(id), job).Return(nil)
+	err := queue.Push(id, job)
+	assert.Nil(t, err)
+	db.AssertCalled(t, "Create", []byte("outbox"), []byte(id), job)
+	err = queue.Push("", job)
+	assert.NotNil(t, err)
+}
+
+func TestQueueRemove(t *testing.T) {
+	db := new(DbHandlerMock)
+	queue, _ := NewDbQueue(db, "outbox")
+	id := "0123456789"
+	e := errors.New("fail")
+	db.On("Delete", []byte("outbox"), []byte(id)).Return(e)
+	err := queue.Remove(id)
+	assert.Equal(t, e, errors.Cause(err))
+}
+
+func TestQueueReadAll(t *testing.T) {
+	db := new(DbHandlerMock)
+	queue, _ := NewDbQueue(db, "outbox")
+	keys := [][]byte{[]byte("first"), []byte("second")}
+	db.On("Keys", []byte("outbox")).Return(keys, nil)
+	db.On("Get", []byte("outbox"), []byte("first")).Return([]byte("1"), nil)
+	db.On("Get", []byte("outbox"), []byte("second")).Return([]byte("2"), nil)
+	jobs, err := queue.ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2")}, jobs)
+
+	db = new(DbHandlerMock)
+	queue, _ = NewDbQueue(db, "outbox")
+	e := errors.New("fail")
+	db.On("Keys", []byte("outbox")).Return([][]byte{}, e)
+	_, err = queue.ReadAll()
+	assert.Equal(t, e, errors.Cause(err))
+	db.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+}
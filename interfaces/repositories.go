@@ -3,25 +3,38 @@ package interfaces
 import (
 	"encoding/binary"
 	"encoding/json"
+	"time"
 
 	"b.yadro.com/sys/ch-server/domain"
 	"github.com/pkg/errors"
 )
 
-const lengthOfKey = 32
-
-// DbHandler - implemented in struct BoltHandler from infrastructure/repository
-// to manage database
-type DbHandler interface {
+// DbTx - operations of database, inside transaction of DbHandler
+// they are applied together or not at all
+type DbTx interface {
 	Create(bucket []byte, key []byte, value []byte) error
 	Get(bucket []byte, key []byte) ([]byte, error)
 	Delete(bucket []byte, key []byte) error
 	Keys(bucket []byte) ([][]byte, error)
+	Range(bucket []byte, min []byte, max []byte) ([][]byte, error)
+}
+
+// DbHandler - implemented in struct BoltHandler from infrastructure/repository
+// to manage database, every operation out of transaction is applied alone
+type DbHandler interface {
+	DbTx
+	Update(fn func(tx DbTx) error) error
+	View(fn func(tx DbTx) error) error
+	Batch(fn func(tx DbTx) error) error
 }
 
-// InvokeHandler - implemented in infrastructure/tusdinvoke to delete files from tusd
+// InvokeHandler - implemented in infrastructure/tusdinvoke to manage files of tusd
 type InvokeHandler interface {
 	Remove(id string) error
+	Checksum(id string, algorithm string) (string, error)
+	Files() ([]string, error)
+	Modified(id string) (time.Time, error)
+	Quarantine(id string) error
 }
 
 // DbDataRepo - Implement interface DataRepository from domain data
@@ -41,7 +54,7 @@ func NewDbDataRepo(dbHandler DbHandler, invokeHandler InvokeHandler, bucket stri
 	return &DbDataRepo{dbHandler, invokeHandler, bucket}, nil
 }
 
-// Store - invoke db methods to store data in database
+// Store - invoke db methods to store data in database and update indexes
 func (repo *DbDataRepo) Store(data domain.Data) error {
 	b, err := json.Marshal(data)
 	if err != nil {
@@ -51,24 +64,42 @@ func (repo *DbDataRepo) Store(data domain.Data) error {
 	if key == "" {
 		return errors.New("[repositories] [store] bad data")
 	}
-	if err := repo.dbHandler.Create([]byte(repo.bucket), []byte(key), b); err != nil {
-		return errors.Wrap(err, "[repositories] [store]")
-	}
-	key = data.SerialNumber + data.LogCollectionTimestamp
-	if key == "" {
+	unique := data.SerialNumber + data.LogCollectionTimestamp
+	if unique == "" {
 		return errors.New("[repositories] [store] bad data")
 	}
-	if err := repo.dbHandler.Create([]byte(repo.bucket), []byte(key), b); err != nil {
-		return errors.Wrap(err, "[repositories] [store]")
-	}
-	return nil
+	// Both keys and indexes are written in one transaction
+	err = repo.dbHandler.Batch(func(tx DbTx) error {
+		// Previous version of data keeps keys of indexes to replace
+		old, err := repo.findById(tx, key)
+		if err != nil {
+			old = domain.Data{}
+		}
+		// Unique key of previous version is orphaned if it is changed
+		if stale := old.SerialNumber + old.LogCollectionTimestamp; old.SessionID != "" && stale != unique {
+			if err := tx.Delete([]byte(repo.bucket), []byte(stale)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create([]byte(repo.bucket), []byte(key), b); err != nil {
+			return err
+		}
+		if err := tx.Create([]byte(repo.bucket), []byte(unique), b); err != nil {
+			return err
+		}
+		return repo.updateIndexes(tx, old, data)
+	})
+	return errors.Wrap(err, "[repositories] [store]")
 }
 
 // FindById - invoke db methods to find data in database and return data
 func (repo *DbDataRepo) FindById(id string) (domain.Data, error) {
+	return repo.findById(repo.dbHandler, id)
+}
+
+func (repo *DbDataRepo) findById(tx DbTx, id string) (domain.Data, error) {
 	var data domain.Data
-	js := make([]byte, 0, 512)
-	js, err := repo.dbHandler.Get([]byte(repo.bucket), []byte(id))
+	js, err := tx.Get([]byte(repo.bucket), []byte(id))
 	if err != nil {
 		return data, errors.Wrap(err, "[repositories] [findById]")
 	}
@@ -79,7 +110,8 @@ func (repo *DbDataRepo) FindById(id string) (domain.Data, error) {
 	return data, nil
 }
 
-// Remove - invoke db methods to delete data from database
+// Remove - invoke db methods to delete data from database and tusd methods
+// to delete uploaded file
 func (repo *DbDataRepo) Remove(id string) error {
 	data, err := repo.FindById(id)
 	if err != nil {
@@ -89,29 +121,82 @@ func (repo *DbDataRepo) Remove(id string) error {
 		data.SessionID,
 		data.SerialNumber + data.LogCollectionTimestamp,
 	}
-	for _, key := range keys {
-		if err := repo.dbHandler.Delete([]byte(repo.bucket), []byte(key)); err != nil {
-			return errors.Wrap(err, "[repositories] [remove]")
+	// Both keys and indexes are deleted in one transaction
+	err = repo.dbHandler.Batch(func(tx DbTx) error {
+		for _, key := range keys {
+			if err := tx.Delete([]byte(repo.bucket), []byte(key)); err != nil {
+				return err
+			}
 		}
+		return repo.updateIndexes(tx, data, domain.Data{})
+	})
+	if err != nil {
+		return errors.Wrap(err, "[repositories] [remove]")
 	}
+	// Data is restored if file isn't deleted, so file is still reachable
+	// and Remove can be repeated
 	if err := repo.invokeHandler.Remove(data.SessionID); err != nil {
+		if e := repo.Store(data); e != nil {
+			return errors.Wrapf(err, "[repositories] [remove] data isn't restored: %v", e)
+		}
 		return errors.Wrap(err, "[repositories] [remove]")
 	}
 	return nil
 }
 
-// ReadAll - invoke db methods to read all id from database and return string of all id
-func (repo *DbDataRepo) ReadAll() ([]string, error) {
+// Purge - invoke tusd methods to delete uploaded file, data is kept in database
+func (repo *DbDataRepo) Purge(id string) error {
+	if err := repo.invokeHandler.Remove(id); err != nil {
+		return errors.Wrap(err, "[repositories] [purge]")
+	}
+	return nil
+}
+
+// Checksum - invoke tusd methods to hash uploaded file, return hex encoded digest
+func (repo *DbDataRepo) Checksum(id string, algorithm string) (string, error) {
+	digest, err := repo.invokeHandler.Checksum(id, algorithm)
+	if err != nil {
+		return "", errors.Wrap(err, "[repositories] [checksum]")
+	}
+	return digest, nil
+}
+
+// Files - invoke tusd methods to read id of all uploaded files
+func (repo *DbDataRepo) Files() ([]string, error) {
+	ids, err := repo.invokeHandler.Files()
+	if err != nil {
+		return nil, errors.Wrap(err, "[repositories] [files]")
+	}
+	return ids, nil
+}
 
-	keys, err := repo.dbHandler.Keys([]byte(repo.bucket))
+// Modified - invoke tusd methods to read time of last change of upload
+func (repo *DbDataRepo) Modified(id string) (time.Time, error) {
+	modified, err := repo.invokeHandler.Modified(id)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "[repositories] [modified]")
+	}
+	return modified, nil
+}
+
+// Quarantine - invoke tusd methods to move uploaded file out of filestore
+func (repo *DbDataRepo) Quarantine(id string) error {
+	if err := repo.invokeHandler.Quarantine(id); err != nil {
+		return errors.Wrap(err, "[repositories] [quarantine]")
+	}
+	return nil
+}
+
+// ReadAll - invoke db methods to read all id from index of timestamps
+// and return string of all id sorted by LogCollectionTimestamp
+func (repo *DbDataRepo) ReadAll() ([]string, error) {
+	keys, err := repo.dbHandler.Range(repo.indexBucket(indexTimestamp), nil, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "[repositories] [readAll]")
 	}
 	strings := make([]string, 0, len(keys))
 	for _, key := range keys {
-		if len(key) == lengthOfKey {
-			strings = append(strings, string(key))
-		}
+		strings = append(strings, indexTimestamp.id(key))
 	}
 	return strings, nil
 }
@@ -1,6 +1,8 @@
 package interfaces
 
 import (
+	"time"
+
 	"github.com/stretchr/testify/mock"
 )
 
@@ -13,6 +15,26 @@ func (m *InvokeHandlerMock) Remove(id string) error {
 	return args.Error(0)
 }
 
+func (m *InvokeHandlerMock) Checksum(id string, algorithm string) (string, error) {
+	args := m.Called(id, algorithm)
+	return args.String(0), args.Error(1)
+}
+
+func (m *InvokeHandlerMock) Files() ([]string, error) {
+	args := m.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *InvokeHandlerMock) Modified(id string) (time.Time, error) {
+	args := m.Called(id)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *InvokeHandlerMock) Quarantine(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
 type DbHandlerMock struct {
 	mock.Mock
 }
@@ -36,3 +58,23 @@ func (m *DbHandlerMock) Keys(bucket []byte) ([][]byte, error) {
 	args := m.Called(bucket)
 	return args.Get(0).([][]byte), args.Error(1)
 }
+
+func (m *DbHandlerMock) Range(bucket []byte, min []byte, max []byte) ([][]byte, error) {
+	args := m.Called(bucket, min, max)
+	return args.Get(0).([][]byte), args.Error(1)
+}
+
+// Update - run fn with mock as transaction
+func (m *DbHandlerMock) Update(fn func(tx DbTx) error) error {
+	return fn(m)
+}
+
+// View - run fn with mock as transaction
+func (m *DbHandlerMock) View(fn func(tx DbTx) error) error {
+	return fn(m)
+}
+
+// Batch - run fn with mock as transaction
+func (m *DbHandlerMock) Batch(fn func(tx DbTx) error) error {
+	return fn(m)
+}
@@ -65,6 +65,10 @@ func TestStoreValid(t *testing.T) {
 		[]byte(repo.bucket),
 		[]byte(meta.SerialNumber+meta.LogCollectionTimestamp),
 		mock.Anything).Return(nil)
+	db.On("Get",
+		[]byte(repo.bucket),
+		[]byte(meta.SessionID)).Return([]byte{}, errors.New("not found"))
+	db.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	err := repo.Store(meta)
 	assert.Nil(t, err)
 	db.AssertCalled(t, "Create",
@@ -99,6 +103,9 @@ func TestStoreDataInvalid(t *testing.T) {
 		[]byte(repo.bucket),
 		[]byte(meta.SessionID),
 		mock.Anything).Return(nil)
+	db.On("Get",
+		[]byte(repo.bucket),
+		[]byte(meta.SessionID)).Return([]byte{}, errors.New("not found"))
 	err = repo.Store(meta)
 	assert.NotNil(t, err)
 	db.AssertNotCalled(t, "Create",
@@ -127,6 +134,10 @@ func TestStoreFirstCreateInvalid(t *testing.T) {
 		[]byte(repo.bucket),
 		[]byte(meta.SerialNumber+meta.LogCollectionTimestamp),
 		mock.Anything).Return(nil)
+	db.On("Get",
+		[]byte(repo.bucket),
+		[]byte(meta.SessionID)).Return([]byte{}, errors.New("not found"))
+	db.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	err := repo.Store(meta)
 	assert.Equal(t, errors.Cause(err), e)
 	db.AssertCalled(t, "Create",
@@ -159,6 +170,10 @@ func TestStoreSecondCreateInvalid(t *testing.T) {
 		[]byte(repo.bucket),
 		[]byte(meta.SerialNumber+meta.LogCollectionTimestamp),
 		mock.Anything).Return(e)
+	db.On("Get",
+		[]byte(repo.bucket),
+		[]byte(meta.SessionID)).Return([]byte{}, errors.New("not found"))
+	db.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	err := repo.Store(meta)
 	assert.Equal(t, errors.Cause(err), e)
 	db.AssertCalled(t, "Create",
@@ -230,6 +245,7 @@ func TestRemoveValid(t *testing.T) {
 		[]byte(repo.bucket),
 		[]byte(meta.SerialNumber+meta.LogCollectionTimestamp)).Return(nil)
 	invoke.On("Remove", meta.SessionID).Return(nil)
+	db.On("Delete", mock.Anything, mock.Anything).Return(nil)
 	err := repo.Remove(meta.SessionID)
 	assert.Nil(t, err)
 	db.AssertCalled(t, "Get",
@@ -363,6 +379,8 @@ func TestRemoveRemoveInvalid(t *testing.T) {
 	db.On("Delete",
 		[]byte(repo.bucket),
 		[]byte(meta.SerialNumber+meta.LogCollectionTimestamp)).Return(nil)
+	db.On("Delete", mock.Anything, mock.Anything).Return(nil)
+	db.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 	invoke.On("Remove", meta.SessionID).Return(e)
 	err := repo.Remove(meta.SessionID)
 	assert.Equal(t, errors.Cause(err), e)
@@ -376,31 +394,35 @@ func TestRemoveRemoveInvalid(t *testing.T) {
 		[]byte(repo.bucket),
 		[]byte(meta.SerialNumber+meta.LogCollectionTimestamp))
 	invoke.AssertCalled(t, "Remove", meta.SessionID)
+	// data is restored when file isn't deleted
+	db.AssertCalled(t, "Create",
+		[]byte(repo.bucket),
+		[]byte(meta.SessionID),
+		mock.Anything)
+	db.AssertCalled(t, "Create",
+		[]byte(repo.bucket),
+		[]byte(meta.SerialNumber+meta.LogCollectionTimestamp),
+		mock.Anything)
 }
 func TestReadAllValid(t *testing.T) {
 	db := new(DbHandlerMock)
 	invoke := new(InvokeHandlerMock)
 	repo, _ := NewDbDataRepo(db, invoke, "root")
-	id := "01234567890123456789012345678901"
-	data := [][]byte{[]byte(id), []byte(id)}
-	strings := []string{id, id}
-	db.On("Keys", []byte(repo.bucket)).Return(data, nil)
+	meta := domain.Data{SessionID: "0123456789", LogCollectionTimestamp: "Thu Aug 17 14:00:06 MSK 2019"}
+	bucket := []byte("root.idx.timestamp")
+	data := [][]byte{indexTimestamp.key(domain.Data{SessionID: "9876543210"}), indexTimestamp.key(meta)}
+	db.On("Range", bucket, []byte(nil), []byte(nil)).Return(data, nil)
 	s, err := repo.ReadAll()
 	assert.Nil(t, err)
-	assert.Equal(t, strings, s)
-	db.AssertCalled(t, "Keys",
-		[]byte(repo.bucket))
+	assert.Equal(t, []string{"9876543210", "0123456789"}, s)
+	db.AssertCalled(t, "Range", bucket, []byte(nil), []byte(nil))
 }
 func TestReadAllInvalid(t *testing.T) {
 	db := new(DbHandlerMock)
 	invoke := new(InvokeHandlerMock)
 	repo, _ := NewDbDataRepo(db, invoke, "root")
-	id := "01234567890123456789012345678901"
-	data := [][]byte{[]byte(id), []byte(id)}
 	e := errors.New("fail")
-	db.On("Keys", []byte(repo.bucket)).Return(data, e)
+	db.On("Range", mock.Anything, mock.Anything, mock.Anything).Return([][]byte{}, e)
 	_, err := repo.ReadAll()
 	assert.Equal(t, errors.Cause(err), e)
-	db.AssertCalled(t, "Keys",
-		[]byte(repo.bucket))
-}
\ No newline at end of file
+}
@@ -0,0 +1,222 @@
+package interfaces
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Types of field of metadata in schema, empty type is any
+const (
+	fieldString  = "string"
+	fieldNumber  = "number"
+	fieldInteger = "integer"
+	fieldBoolean = "boolean"
+)
+
+// schemaFormats - layouts of time by format of field in schema
+var schemaFormats = map[string]string{
+	"unixdate": time.UnixDate,
+	"rfc3339":  time.RFC3339,
+	"rfc1123":  time.RFC1123,
+}
+
+// FieldSchema - rules of field of metadata: type (string, number, integer
+// or boolean), format of time (unixdate, rfc3339 or rfc1123), regular
+// expression, max length and allowed values
+type FieldSchema struct {
+	Name      string
+	Type      string
+	Required  bool
+	Format    string
+	Pattern   string
+	MaxLength int
+	Enum      []string
+	pattern   *regexp.Regexp
+}
+
+// MetadataSchema - versioned schemas of metadata of upload, version is
+// read from field of metadata, metadata without it uses default version
+type MetadataSchema struct {
+	field    string
+	fallback string
+	versions map[string][]FieldSchema
+}
+
+// NewMetadataSchema - create new instance of MetadataSchema, version of
+// metadata is in field, fallback is version of metadata without it
+func NewMetadataSchema(field string, fallback string) (*MetadataSchema, error) {
+	if field == "" || fallback == "" {
+		return nil, errors.New("[schema] [new] bad argument")
+	}
+	return &MetadataSchema{field: field, fallback: fallback, versions: make(map[string][]FieldSchema)}, nil
+}
+
+// AddVersion - add schema of version of metadata, fields are tested in order
+func (schema *MetadataSchema) AddVersion(version string, fields []FieldSchema) error {
+	if version == "" || schema.versions[version] != nil {
+		return errors.Errorf("[schema] [add version] bad version %q", version)
+	}
+	rules := make([]FieldSchema, 0, len(fields))
+	for _, f := range fields {
+		if f.Name == "" {
+			return errors.Errorf("[schema] [add version] %s: field without name", version)
+		}
+		switch f.Type {
+		case "", fieldString, fieldNumber, fieldInteger, fieldBoolean:
+		default:
+			return errors.Errorf("[schema] [add version] %s: %s: unknown type %q", version, f.Name, f.Type)
+		}
+		if _, ok := schemaFormats[f.Format]; f.Format != "" && !ok {
+			return errors.Errorf("[schema] [add version] %s: %s: unknown format %q", version, f.Name, f.Format)
+		}
+		if f.Pattern != "" {
+			pattern, err := regexp.Compile(f.Pattern)
+			if err != nil {
+				return errors.Wrapf(err, "[schema] [add version] %s: %s", version, f.Name)
+			}
+			f.pattern = pattern
+		}
+		rules = append(rules, f)
+	}
+	schema.versions[version] = rules
+	return nil
+}
+
+// defaultSchema - schema of metadata which is used without schema from config
+func defaultSchema() *MetadataSchema {
+	schema, _ := NewMetadataSchema("SchemaVersion", "1")
+	fields := []FieldSchema{
+		{Name: "SerialNumber", Type: fieldString, Required: true, MaxLength: maxLength},
+		{Name: "LogCollectionTimestamp", Type: fieldString, Required: true, Format: "unixdate", MaxLength: maxLength},
+		{Name: "ClientStartTimestamp", Type: fieldString, Required: true, Format: "unixdate", MaxLength: maxLength},
+	}
+	for _, name := range []string{"Service", "SystemType", "LogLevel", "Originator", "SessionID", "Checksum",
+		"Hostname", "NotificationManager", "Cancel", "StartTimestamp", "FinishTimestamp"} {
+		max := maxLength
+		if name == "Checksum" {
+			max = maxLengthChecksum
+		}
+		fields = append(fields, FieldSchema{Name: name, Type: fieldString, MaxLength: max})
+	}
+	schema.AddVersion("1", fields)
+	return schema
+}
+
+// validate - test metadata by schema of its version: mistyped fields are
+// rejected with status 400, invalid fields with status 422 and too long
+// fields only with status 413
+func (schema *MetadataSchema) validate(data string) error {
+	meta := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	if !json.Valid([]byte(data)) || decoder.Decode(&meta) != nil {
+		return newValidationError(http.StatusBadRequest, FieldError{"data", "is not valid JSON object"})
+	}
+	version := schema.version(meta)
+	fields, ok := schema.versions[version]
+	if !ok {
+		return newValidationError(http.StatusUnprocessableEntity,
+			FieldError{schema.field, fmt.Sprintf("has unknown version %q", version)})
+	}
+	var mistyped, invalid, long []FieldError
+	for _, f := range fields {
+		value, ok := meta[f.Name]
+		if !ok || value == nil || value == "" {
+			if f.Required {
+				invalid = append(invalid, FieldError{f.Name, "may not be empty"})
+			}
+			continue
+		}
+		if !f.typed(value) {
+			mistyped = append(mistyped, FieldError{f.Name, "must be " + f.Type})
+			continue
+		}
+		str := fmt.Sprint(value)
+		if layout, ok := schemaFormats[f.Format]; ok {
+			if _, err := time.Parse(layout, str); err != nil {
+				invalid = append(invalid, FieldError{f.Name, "is invalid format, expected " + layout})
+			}
+		}
+		if f.pattern != nil && !f.pattern.MatchString(str) {
+			invalid = append(invalid, FieldError{f.Name, "doesn't match " + f.Pattern})
+		}
+		if len(f.Enum) > 0 && !contains(f.Enum, str) {
+			invalid = append(invalid, FieldError{f.Name, fmt.Sprintf("must be one of %q", f.Enum)})
+		}
+		if f.MaxLength > 0 && len(str) > f.MaxLength {
+			long = append(long, FieldError{f.Name, fmt.Sprintf("exceeds max length %d", f.MaxLength)})
+		}
+	}
+	switch {
+	case len(mistyped) > 0:
+		return newValidationError(http.StatusBadRequest, mistyped...)
+	case len(invalid) > 0:
+		return newValidationError(http.StatusUnprocessableEntity, append(invalid, long...)...)
+	case len(long) > 0:
+		return newValidationError(http.StatusRequestEntityTooLarge, long...)
+	}
+	return nil
+}
+
+// version - return version of schema of metadata, metadata without field
+// of version uses fallback
+func (schema *MetadataSchema) version(meta map[string]interface{}) string {
+	if v, ok := meta[schema.field]; ok {
+		return fmt.Sprint(v)
+	}
+	return schema.fallback
+}
+
+// declared - return names of fields which are declared by schema of version
+// of metadata and name of field of version
+func (schema *MetadataSchema) declared(data string) map[string]bool {
+	meta := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	if decoder.Decode(&meta) != nil {
+		return nil
+	}
+	declared := map[string]bool{schema.field: true}
+	for _, f := range schema.versions[schema.version(meta)] {
+		declared[f.Name] = true
+	}
+	return declared
+}
+
+// typed - test type of value of field, numbers are json.Number
+func (f FieldSchema) typed(value interface{}) bool {
+	switch f.Type {
+	case fieldString:
+		_, ok := value.(string)
+		return ok
+	case fieldNumber:
+		_, ok := value.(json.Number)
+		return ok
+	case fieldInteger:
+		n, ok := value.(json.Number)
+		if ok {
+			_, err := n.Int64()
+			ok = err == nil
+		}
+		return ok
+	case fieldBoolean:
+		_, ok := value.(bool)
+		return ok
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
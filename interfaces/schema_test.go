@@ -0,0 +1,134 @@
+package interfaces
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"testing"
+
+	"b.yadro.com/sys/ch-server/domain"
+	"b.yadro.com/sys/ch-server/usecases"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSchema(t *testing.T) {
+	schema, err := NewMetadataSchema("SchemaVersion", "1")
+	assert.Nil(t, err)
+	assert.Nil(t, schema.AddVersion("1", []FieldSchema{
+		{Name: "SerialNumber", Type: "string", Required: true, MaxLength: 10},
+	}))
+	assert.Nil(t, schema.AddVersion("2", []FieldSchema{
+		{Name: "SerialNumber", Type: "string", Required: true, Pattern: "^[0-9]+$"},
+		{Name: "Collected", Format: "rfc3339"},
+		{Name: "LogLevel", Enum: []string{"info", "error"}},
+		{Name: "Attempt", Type: "integer"},
+		{Name: "Debug", Type: "boolean"},
+	}))
+	// validate - return status and offending fields of rejection
+	validate := func(data string) (int, []FieldError) {
+		err := schema.validate(data)
+		if err == nil {
+			return 0, nil
+		}
+		e, ok := err.(*ValidationError)
+		assert.True(t, ok, "schema: error isn't ValidationError: %v", err)
+		return e.StatusCode(), e.Fields
+	}
+
+	t.Run("valid default version", func(t *testing.T) {
+		status, _ := validate(`{"SerialNumber": "0123456789", "Extra": {"any": true}}`)
+		assert.Equal(t, 0, status)
+	})
+	t.Run("valid version of metadata", func(t *testing.T) {
+		status, _ := validate(`{
+			"SchemaVersion": 2,
+			"SerialNumber" : "0123456789012",
+			"Collected"    : "2019-08-17T14:00:06+03:00",
+			"LogLevel"     : "error",
+			"Attempt"      : 3,
+			"Debug"        : false
+			}`)
+		assert.Equal(t, 0, status)
+	})
+	t.Run("invalid fields of version", func(t *testing.T) {
+		status, fields := validate(`{
+			"SchemaVersion": "2",
+			"SerialNumber" : "SN-1",
+			"Collected"    : "Thu Aug 17 14:00:06 MSK 2019",
+			"LogLevel"     : "debug"
+			}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+		assert.Equal(t, []FieldError{
+			{"SerialNumber", "doesn't match ^[0-9]+$"},
+			{"Collected", "is invalid format, expected " + "2006-01-02T15:04:05Z07:00"},
+			{"LogLevel", `must be one of ["info" "error"]`},
+		}, fields)
+	})
+	t.Run("invalid types", func(t *testing.T) {
+		status, fields := validate(`{"SchemaVersion": "2", "SerialNumber": "42", "Attempt": 1.5, "Debug": "yes"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, []FieldError{{"Attempt", "must be integer"}, {"Debug", "must be boolean"}}, fields)
+	})
+	t.Run("invalid too long field", func(t *testing.T) {
+		status, fields := validate(`{"SerialNumber": "0123456789012"}`)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+		assert.Equal(t, []FieldError{{"SerialNumber", "exceeds max length 10"}}, fields)
+	})
+	t.Run("invalid unknown version", func(t *testing.T) {
+		status, fields := validate(`{"SchemaVersion": "3", "SerialNumber": "0123456789"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+		assert.Equal(t, "SchemaVersion", fields[0].Field)
+	})
+	t.Run("invalid json", func(t *testing.T) {
+		status, _ := validate(`{"SerialNumber": "0123456789"} {}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _ = validate(`["0123456789"]`)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+	t.Run("invalid schema", func(t *testing.T) {
+		_, err := NewMetadataSchema("", "1")
+		assert.Error(t, err)
+		assert.Error(t, schema.AddVersion("1", nil))
+		assert.Error(t, schema.AddVersion("3", []FieldSchema{{Name: "SerialNumber", Type: "text"}}))
+		assert.Error(t, schema.AddVersion("3", []FieldSchema{{Name: "SerialNumber", Format: "iso"}}))
+		assert.Error(t, schema.AddVersion("3", []FieldSchema{{Name: "SerialNumber", Pattern: "("}}))
+		assert.Error(t, schema.AddVersion("3", []FieldSchema{{Type: "string"}}))
+	})
+}
+
+func TestValidateSchema(t *testing.T) {
+	repo := new(usecases.DataRepositoryMock)
+	dataAgent, _ := usecases.NewDataAgent(repo, new(usecases.HttpClientMock))
+	hooksHandler, _ := NewHooksHandler(
+		dataAgent,
+		new(clientHooks),
+		log.New(os.Stdout, "[test] ", log.LstdFlags))
+	repo.On("FindById", mock.Anything).Return(domain.Data{}, errors.New("fail"))
+	schema, _ := NewMetadataSchema("SchemaVersion", "1")
+	assert.Nil(t, schema.AddVersion("1", []FieldSchema{
+		{Name: "SerialNumber", Type: "string", Required: true},
+		{Name: "Firmware", Type: "string", Required: true},
+	}))
+	assert.Error(t, hooksHandler.SetSchema(nil))
+	empty, _ := NewMetadataSchema("SchemaVersion", "1")
+	assert.Error(t, hooksHandler.SetSchema(empty))
+	assert.Nil(t, hooksHandler.SetSchema(schema))
+
+	t.Run("valid metadata without timestamps", func(t *testing.T) {
+		assert.Nil(t, hooksHandler.Validate("0123456789", `{"SerialNumber": "0123456789", "Firmware": "1.2"}`))
+	})
+	t.Run("invalid metadata without required field", func(t *testing.T) {
+		err := hooksHandler.Validate("0123456789", `{"SerialNumber": "0123456789"}`)
+		if assert.IsType(t, &ValidationError{}, err) {
+			assert.Equal(t, []FieldError{{"Firmware", "may not be empty"}}, err.(*ValidationError).Fields)
+		}
+		// Metadata of created file is stored anyway
+		repo.On("Store", mock.Anything).Return(nil)
+		assert.Nil(t, hooksHandler.Create("0123456789", `{"SerialNumber": "0123456789"}`, "logs.tar"))
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.SessionID == "0123456789" && d.SerialNumber == "0123456789"
+		}))
+	})
+}
@@ -0,0 +1,56 @@
+package interfaces
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FieldError - field of metadata which is rejected and the reason
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError - metadata of upload is rejected by client fault, it isn't
+// repeated by device without change. Status is 400 for malformed metadata,
+// 409 for duplicate upload, 413 for too long field and 422 for invalid field.
+// It implements tusd.HTTPError, so tusd answers by status and JSON body
+type ValidationError struct {
+	Status int          `json:"status"`
+	Reason string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// newValidationError - create ValidationError by status, reason is
+// text of status
+func newValidationError(status int, fields ...FieldError) *ValidationError {
+	return &ValidationError{
+		Status: status,
+		Reason: strings.ToLower(http.StatusText(status)),
+		Fields: fields,
+	}
+}
+
+func (err *ValidationError) Error() string {
+	reasons := make([]string, 0, len(err.Fields))
+	for _, f := range err.Fields {
+		reasons = append(reasons, fmt.Sprintf("%s %s", f.Field, f.Reason))
+	}
+	if len(reasons) == 0 {
+		return fmt.Sprintf("[hooks] [validate] %s", err.Reason)
+	}
+	return fmt.Sprintf("[hooks] [validate] %s: %s", err.Reason, strings.Join(reasons, "; "))
+}
+
+// StatusCode - http status of rejection
+func (err *ValidationError) StatusCode() int {
+	return err.Status
+}
+
+// Body - JSON with status and offending fields
+func (err *ValidationError) Body() []byte {
+	body, _ := json.Marshal(err)
+	return body
+}
@@ -0,0 +1,66 @@
+package usecases
+
+import (
+	"b.yadro.com/sys/ch-server/domain"
+	"github.com/pkg/errors"
+)
+
+// Delivery - forwarding of upload to one sink, protect Delivery from domain package
+type Delivery struct {
+	Required  bool
+	State     string
+	Attempts  int
+	LastError string
+	RemoteID  string
+	Timestamp string
+}
+
+// Dispatch - save sinks of upload in metadata, value of sink is true if it
+// is required. Return sinks which upload isn't forwarded to yet
+func (agent *dataAgent) Dispatch(id string, sinks map[string]bool) ([]string, error) {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	d, err := agent.DataRepository.FindById(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "[usedata] [dispatch]")
+	}
+	pending := d.Dispatch(sinks)
+	if err := agent.DataRepository.Store(d); err != nil {
+		return nil, errors.Wrap(err, "[usedata] [dispatch]")
+	}
+	return pending, nil
+}
+
+// Deliver - save result of forwarding to sink: state is complete while it
+// is retried, forwarded or failed. Upload is forwarded when all required
+// sinks have it and is failed when any of them fails. Remote is id of
+// upload returned by forwarded sink. Return true if file of upload isn't
+// needed by sinks anymore
+func (agent *dataAgent) Deliver(id string, sink string, state string, attempts int, reason string, remote string) (bool, error) {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	d, err := agent.DataRepository.FindById(id)
+	if err != nil {
+		return false, errors.Wrap(err, "[usedata] [deliver]")
+	}
+	if err := d.Deliver(sink, domain.State(state), attempts, reason, remote); err != nil {
+		return false, errors.Wrap(err, "[usedata] [deliver]")
+	}
+	if state != StateForwarded {
+		assignInt(attempts, &d.Attempts)
+		assignString(reason, &d.LastError)
+	}
+	switch {
+	case d.Delivered() && d.State != domain.StateForwarded:
+		err = d.Transit(domain.StateForwarded, "")
+	case d.Undelivered() && d.State != domain.StateFailed:
+		err = d.Transit(domain.StateFailed, reason)
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "[usedata] [deliver]")
+	}
+	if err := agent.DataRepository.Store(d); err != nil {
+		return false, errors.Wrap(err, "[usedata] [deliver]")
+	}
+	return d.Delivered() && d.Settled(), nil
+}
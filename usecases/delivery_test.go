@@ -0,0 +1,106 @@
+package usecases
+
+import (
+	"testing"
+
+	"b.yadro.com/sys/ch-server/domain"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDispatch(t *testing.T) {
+	repo := new(DataRepositoryMock)
+	dataAgent, _ := NewDataAgent(repo, new(HttpClientMock))
+	id := "0123456789"
+	data := domain.Data{SessionID: id, State: domain.StateComplete,
+		Deliveries: map[string]domain.Delivery{"syr": {Required: true, State: domain.StateForwarded}}}
+	repo.On("FindById", id).Return(data, nil)
+	repo.On("Store", mock.Anything).Return(nil)
+	pending, err := dataAgent.Dispatch(id, map[string]bool{"syr": true, "archive": false})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"archive"}, pending)
+	repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+		return len(d.Deliveries) == 2 && d.Deliveries["archive"].State == domain.StateComplete
+	}))
+}
+
+func TestDeliver(t *testing.T) {
+	id := "0123456789"
+	sinks := func() map[string]domain.Delivery {
+		return map[string]domain.Delivery{
+			"syr":     {Required: true, State: domain.StateComplete},
+			"dc2":     {Required: true, State: domain.StateForwarded},
+			"archive": {Required: false, State: domain.StateForwarded},
+		}
+	}
+	t.Run("valid forwarded to all required sinks", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		dataAgent, _ := NewDataAgent(repo, new(HttpClientMock))
+		repo.On("FindById", id).Return(domain.Data{SessionID: id, State: domain.StateComplete, Deliveries: sinks()}, nil)
+		repo.On("Store", mock.Anything).Return(nil)
+		purge, err := dataAgent.Deliver(id, "syr", StateForwarded, 1, "", "")
+		assert.Nil(t, err)
+		assert.True(t, purge)
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.State == domain.StateForwarded && d.Deliveries["syr"].State == domain.StateForwarded
+		}))
+	})
+	t.Run("valid retried sink", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		dataAgent, _ := NewDataAgent(repo, new(HttpClientMock))
+		repo.On("FindById", id).Return(domain.Data{SessionID: id, State: domain.StateComplete, Deliveries: sinks()}, nil)
+		repo.On("Store", mock.Anything).Return(nil)
+		purge, err := dataAgent.Deliver(id, "syr", StateComplete, 2, "status code = 503", "")
+		assert.Nil(t, err)
+		assert.False(t, purge)
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.State == domain.StateComplete && d.Attempts == 2 && d.LastError == "status code = 503" &&
+				d.Deliveries["syr"].Attempts == 2
+		}))
+	})
+	t.Run("valid failed required sink", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		dataAgent, _ := NewDataAgent(repo, new(HttpClientMock))
+		repo.On("FindById", id).Return(domain.Data{SessionID: id, State: domain.StateComplete, Deliveries: sinks()}, nil)
+		repo.On("Store", mock.Anything).Return(nil)
+		purge, err := dataAgent.Deliver(id, "syr", StateFailed, 5, "status code = 400", "")
+		assert.Nil(t, err)
+		assert.False(t, purge)
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.State == domain.StateFailed && d.History[0].Reason == "status code = 400"
+		}))
+	})
+	t.Run("valid failed optional sink", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		dataAgent, _ := NewDataAgent(repo, new(HttpClientMock))
+		deliveries := sinks()
+		deliveries["syr"] = domain.Delivery{Required: true, State: domain.StateForwarded}
+		deliveries["archive"] = domain.Delivery{Required: false, State: domain.StateComplete}
+		repo.On("FindById", id).Return(domain.Data{SessionID: id, State: domain.StateForwarded, Deliveries: deliveries}, nil)
+		repo.On("Store", mock.Anything).Return(nil)
+		purge, err := dataAgent.Deliver(id, "archive", StateFailed, 3, "disk is full", "")
+		assert.Nil(t, err)
+		assert.True(t, purge)
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.State == domain.StateForwarded && len(d.History) == 0
+		}))
+	})
+	t.Run("invalid transit", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		dataAgent, _ := NewDataAgent(repo, new(HttpClientMock))
+		repo.On("FindById", id).Return(domain.Data{SessionID: id, State: domain.StateExpired, Deliveries: sinks()}, nil)
+		purge, err := dataAgent.Deliver(id, "syr", StateForwarded, 1, "", "")
+		assert.Error(t, err)
+		assert.False(t, purge)
+		repo.AssertNotCalled(t, "Store", mock.Anything)
+	})
+	t.Run("invalid read", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		dataAgent, _ := NewDataAgent(repo, new(HttpClientMock))
+		e := errors.New("failed")
+		repo.On("FindById", id).Return(domain.Data{}, e)
+		_, err := dataAgent.Deliver(id, "syr", StateForwarded, 1, "", "")
+		assert.Equal(t, e, errors.Cause(err))
+	})
+}
@@ -0,0 +1,187 @@
+package usecases
+
+import (
+	"time"
+
+	"b.yadro.com/sys/ch-server/domain"
+	"github.com/pkg/errors"
+)
+
+// Policies of reconciliation for files without metadata
+const (
+	// OrphanKeep - orphaned file is only reported
+	OrphanKeep = "keep"
+	// OrphanRemove - orphaned file is deleted from filestore
+	OrphanRemove = "remove"
+	// OrphanQuarantine - orphaned file is moved out of filestore
+	OrphanQuarantine = "quarantine"
+)
+
+// DefaultOrphanGrace - age of file without metadata which is orphaned,
+// metadata of new upload is stored after its file is created
+const DefaultOrphanGrace = time.Hour
+
+// ValidOrphanPolicy - test policy of orphaned files on existence
+func ValidOrphanPolicy(policy string) bool {
+	switch policy {
+	case OrphanKeep, OrphanRemove, OrphanQuarantine:
+		return true
+	}
+	return false
+}
+
+// Report - summary of reconciliation between metadata and filestore
+type Report struct {
+	Timestamp string
+	Policy    string
+	// Uploads - number of checked metadata
+	Uploads int
+	// Missing - metadata of uploads without file, they are expired
+	Missing []string
+	// Orphans - files without metadata, they are handled by policy
+	Orphans []string
+	// Resent - complete uploads which are sent to extern server again
+	Resent []string
+	// Migrated - uploads stored before states of uploads, they get state
+	Migrated []string
+	Errors   []string
+}
+
+// unfinished - states of upload which still need its file
+var unfinished = map[string]bool{
+	StateCreated:  true,
+	StateComplete: true,
+	StateFailed:   true,
+}
+
+// SetOrphanGrace - set age of file without metadata which is orphaned,
+// younger files may be uploads which metadata isn't stored yet
+func (agent *dataAgent) SetOrphanGrace(grace time.Duration) error {
+	if grace <= 0 {
+		return errors.New("[usedata] [orphan grace] bad argument")
+	}
+	agent.grace = grace
+	return nil
+}
+
+func (agent *dataAgent) orphanGrace() time.Duration {
+	if agent.grace == 0 {
+		return DefaultOrphanGrace
+	}
+	return agent.grace
+}
+
+// migrate - set state of upload which is stored without state by previous
+// version of server: upload with FinishTimestamp is complete, other one is
+// created. Return new state
+func (agent *dataAgent) migrate(id string) (string, error) {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	d, err := agent.DataRepository.FindById(id)
+	if err != nil {
+		return "", errors.Wrap(err, "[usedata] [migrate]")
+	}
+	if d.State != "" {
+		return string(d.State), nil
+	}
+	if err := d.Transit(domain.StateCreated, "migrated"); err != nil {
+		return "", errors.Wrap(err, "[usedata] [migrate]")
+	}
+	if d.FinishTimestamp != "" {
+		if err := d.Transit(domain.StateComplete, "migrated"); err != nil {
+			return "", errors.Wrap(err, "[usedata] [migrate]")
+		}
+	}
+	if err := agent.DataRepository.Store(d); err != nil {
+		return "", errors.Wrap(err, "[usedata] [migrate]")
+	}
+	return string(d.State), nil
+}
+
+// Reconcile - compare metadata with files of filestore: upload without file
+// is expired, complete upload is sent again and orphaned file is handled by policy.
+// Upload without state, which is stored by previous version, is migrated first.
+// File younger than grace period isn't orphaned, server may be creating it.
+// Errors of single uploads are collected in report
+func (agent *dataAgent) Reconcile(policy string) (Report, error) {
+	report := Report{
+		Timestamp: time.Now().Format(time.UnixDate),
+		Policy:    policy,
+		Missing:   []string{},
+		Orphans:   []string{},
+		Resent:    []string{},
+		Migrated:  []string{},
+		Errors:    []string{},
+	}
+	if !ValidOrphanPolicy(policy) {
+		return report, errors.Errorf("[usedata] [reconcile] unknown policy %q", policy)
+	}
+	ids, err := agent.DataRepository.ReadAll()
+	if err != nil {
+		return report, errors.Wrap(err, "[usedata] [reconcile]")
+	}
+	files, err := agent.DataRepository.Files()
+	if err != nil {
+		return report, errors.Wrap(err, "[usedata] [reconcile]")
+	}
+	exist := make(map[string]bool, len(files))
+	for _, id := range files {
+		exist[id] = true
+	}
+	known := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+		data, err := agent.Read(id)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		report.Uploads++
+		if data.State == "" {
+			if data.State, err = agent.migrate(id); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			report.Migrated = append(report.Migrated, id)
+		}
+		switch {
+		case !exist[id] && unfinished[data.State]:
+			if err := agent.Transit(id, StateExpired, "file is missing"); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			report.Missing = append(report.Missing, id)
+		case exist[id] && data.State == StateComplete:
+			if err := agent.Send(id); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			report.Resent = append(report.Resent, id)
+		}
+	}
+	for _, id := range files {
+		if known[id] {
+			continue
+		}
+		modified, err := agent.DataRepository.Modified(id)
+		if err != nil {
+			report.Errors = append(report.Errors, errors.Wrap(err, "[usedata] [reconcile]").Error())
+			continue
+		}
+		if time.Since(modified) < agent.orphanGrace() {
+			continue
+		}
+		switch policy {
+		case OrphanRemove:
+			err = agent.DataRepository.Purge(id)
+		case OrphanQuarantine:
+			err = agent.DataRepository.Quarantine(id)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, errors.Wrap(err, "[usedata] [reconcile]").Error())
+			continue
+		}
+		report.Orphans = append(report.Orphans, id)
+	}
+	return report, nil
+}
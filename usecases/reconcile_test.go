@@ -0,0 +1,121 @@
+package usecases
+
+import (
+	"testing"
+	"time"
+
+	"b.yadro.com/sys/ch-server/domain"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReconcile(t *testing.T) {
+	uploads := map[string]domain.Data{
+		"missing":   {SessionID: "missing", SerialNumber: "1", FileName: "logs.tar", State: domain.StateComplete},
+		"complete":  {SessionID: "complete", SerialNumber: "2", FileName: "logs.tar", State: domain.StateComplete},
+		"forwarded": {SessionID: "forwarded", SerialNumber: "3", FileName: "logs.tar", State: domain.StateForwarded},
+	}
+	// legacy - uploads stored without state by previous version of server
+	legacy := map[string]domain.Data{
+		"unsent": {SessionID: "unsent", SerialNumber: "4", FileName: "logs.tar",
+			FinishTimestamp: "Thu Oct 17 14:00:06 MSK 2019"},
+		"lost": {SessionID: "lost", SerialNumber: "5", FileName: "logs.tar"},
+	}
+	newAgent := func() (*dataAgent, *DataRepositoryMock, *HttpClientMock) {
+		repo := new(DataRepositoryMock)
+		client := new(HttpClientMock)
+		agent, _ := NewDataAgent(repo, client)
+		repo.On("ReadAll").Return([]string{"missing", "complete", "forwarded"}, nil)
+		// Young file may be upload which metadata isn't stored yet
+		repo.On("Files").Return([]string{"complete", "orphan", "young"}, nil)
+		repo.On("Modified", "orphan").Return(time.Now().Add(-2*DefaultOrphanGrace), nil)
+		repo.On("Modified", "young").Return(time.Now(), nil)
+		for id, data := range uploads {
+			repo.On("FindById", id).Return(data, nil)
+		}
+		repo.On("Store", mock.Anything).Return(nil)
+		client.On("Send", "complete", mock.AnythingOfType("usecases.Data"), "").Return(nil)
+		return agent, repo, client
+	}
+
+	t.Run("valid keep", func(t *testing.T) {
+		agent, repo, client := newAgent()
+		report, err := agent.Reconcile(OrphanKeep)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, report.Uploads)
+		assert.Equal(t, []string{"missing"}, report.Missing)
+		assert.Equal(t, []string{"complete"}, report.Resent)
+		assert.Equal(t, []string{"orphan"}, report.Orphans)
+		assert.Empty(t, report.Errors)
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.SessionID == "missing" && d.State == domain.StateExpired
+		}))
+		client.AssertCalled(t, "Send", "complete", mock.AnythingOfType("usecases.Data"), "")
+		repo.AssertNotCalled(t, "Purge", "orphan")
+		repo.AssertNotCalled(t, "Quarantine", "orphan")
+	})
+	t.Run("valid remove", func(t *testing.T) {
+		agent, repo, _ := newAgent()
+		repo.On("Purge", "orphan").Return(nil)
+		report, err := agent.Reconcile(OrphanRemove)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"orphan"}, report.Orphans)
+		repo.AssertCalled(t, "Purge", "orphan")
+		repo.AssertNotCalled(t, "Purge", "young")
+	})
+	t.Run("valid grace", func(t *testing.T) {
+		agent, repo, _ := newAgent()
+		assert.Error(t, agent.SetOrphanGrace(0))
+		assert.Nil(t, agent.SetOrphanGrace(time.Nanosecond))
+		repo.On("Purge", mock.Anything).Return(nil)
+		report, err := agent.Reconcile(OrphanRemove)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"orphan", "young"}, report.Orphans)
+	})
+	t.Run("invalid quarantine", func(t *testing.T) {
+		agent, repo, _ := newAgent()
+		repo.On("Quarantine", "orphan").Return(errors.New("fail"))
+		report, err := agent.Reconcile(OrphanQuarantine)
+		assert.Nil(t, err)
+		assert.Empty(t, report.Orphans)
+		assert.Len(t, report.Errors, 1)
+	})
+	t.Run("valid migration of uploads without state", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		client := new(HttpClientMock)
+		agent, _ := NewDataAgent(repo, client)
+		repo.On("ReadAll").Return([]string{"unsent", "lost"}, nil)
+		repo.On("Files").Return([]string{"unsent"}, nil)
+		// stored state is read again after migration
+		for id, data := range legacy {
+			d := data
+			repo.On("FindById", id).Return(d, nil).Twice()
+			d.State = domain.StateCreated
+			if d.FinishTimestamp != "" {
+				d.State = domain.StateComplete
+			}
+			repo.On("FindById", id).Return(d, nil)
+		}
+		repo.On("Store", mock.Anything).Return(nil)
+		client.On("Send", "unsent", mock.AnythingOfType("usecases.Data"), "").Return(nil)
+		report, err := agent.Reconcile(OrphanKeep)
+		assert.Nil(t, err)
+		assert.Empty(t, report.Errors)
+		assert.Equal(t, []string{"unsent", "lost"}, report.Migrated)
+		assert.Equal(t, []string{"unsent"}, report.Resent)
+		assert.Equal(t, []string{"lost"}, report.Missing)
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.SessionID == "unsent" && d.State == domain.StateComplete
+		}))
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.SessionID == "lost" && d.State == domain.StateExpired
+		}))
+		client.AssertCalled(t, "Send", "unsent", mock.AnythingOfType("usecases.Data"), "")
+	})
+	t.Run("invalid policy", func(t *testing.T) {
+		agent, _, _ := newAgent()
+		_, err := agent.Reconcile("drop")
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,53 @@
+package usecases
+
+import (
+	"b.yadro.com/sys/ch-server/domain"
+	"github.com/pkg/errors"
+)
+
+// Route - rule to choose destination of upload by metadata, protect Route
+// from domain package
+type Route struct {
+	Name         string
+	SystemType   string
+	Service      string
+	LogLevel     string
+	Originator   string
+	SerialPrefix string
+}
+
+// SetRoutes - set rules to choose destination of uploads, the first matched
+// route is used. Unmatched upload goes to default destination or is
+// rejected if reject is set
+func (agent *dataAgent) SetRoutes(routes []Route, reject bool) error {
+	names := make(map[string]bool, len(routes))
+	rules := make([]domain.Route, 0, len(routes))
+	for _, r := range routes {
+		if r.Name == "" || names[r.Name] {
+			return errors.Errorf("[usedata] [routes] bad name of route %q", r.Name)
+		}
+		names[r.Name] = true
+		rules = append(rules, domain.Route(r))
+	}
+	agent.routes = rules
+	agent.reject = reject
+	return nil
+}
+
+// ErrNoRoute - upload matches no route and is rejected
+var ErrNoRoute = errors.New("[usedata] [route] no route matches upload")
+
+// Route - return name of route of upload, empty name is default destination
+func (agent *dataAgent) Route(data Data) (string, error) {
+	name, ok := domain.MatchRoute(agent.routes, domain.Data{
+		SerialNumber: data.SerialNumber,
+		SystemType:   data.SystemType,
+		Service:      data.Service,
+		LogLevel:     data.LogLevel,
+		Originator:   data.Originator,
+	})
+	if !ok && agent.reject {
+		return "", ErrNoRoute
+	}
+	return name, nil
+}
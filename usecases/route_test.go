@@ -0,0 +1,55 @@
+package usecases
+
+import (
+	"testing"
+
+	"b.yadro.com/sys/ch-server/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSetRoutes(t *testing.T) {
+	dataAgent, _ := NewDataAgent(new(DataRepositoryMock), new(HttpClientMock))
+	assert.Error(t, dataAgent.SetRoutes([]Route{{SystemType: "storage"}}, false))
+	assert.Error(t, dataAgent.SetRoutes([]Route{{Name: "a"}, {Name: "a"}}, false))
+	assert.Nil(t, dataAgent.SetRoutes([]Route{{Name: "a"}, {Name: "b"}}, true))
+}
+
+func TestRoute(t *testing.T) {
+	routes := []Route{
+		{Name: "storage", SystemType: "storage"},
+		{Name: "debug", LogLevel: "debug", SerialPrefix: "YD"},
+	}
+	meta := Data{SerialNumber: "YD0123456789", SystemType: "server", LogLevel: "debug"}
+	t.Run("valid matched route", func(t *testing.T) {
+		dataAgent, _ := NewDataAgent(new(DataRepositoryMock), new(HttpClientMock))
+		assert.Nil(t, dataAgent.SetRoutes(routes, true))
+		route, err := dataAgent.Route(meta)
+		assert.Nil(t, err)
+		assert.Equal(t, "debug", route)
+	})
+	t.Run("valid default route", func(t *testing.T) {
+		dataAgent, _ := NewDataAgent(new(DataRepositoryMock), new(HttpClientMock))
+		assert.Nil(t, dataAgent.SetRoutes(routes, false))
+		route, err := dataAgent.Route(Data{SerialNumber: "XX01"})
+		assert.Nil(t, err)
+		assert.Equal(t, "", route)
+	})
+	t.Run("invalid unmatched upload is rejected", func(t *testing.T) {
+		dataAgent, _ := NewDataAgent(new(DataRepositoryMock), new(HttpClientMock))
+		assert.Nil(t, dataAgent.SetRoutes(routes, true))
+		_, err := dataAgent.Route(Data{SerialNumber: "XX01"})
+		assert.Error(t, err)
+	})
+	t.Run("valid send by route", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		client := new(HttpClientMock)
+		dataAgent, _ := NewDataAgent(repo, client)
+		assert.Nil(t, dataAgent.SetRoutes(routes, true))
+		id := "0123456789"
+		repo.On("FindById", id).Return(domain.Data{SessionID: id, SerialNumber: "YD01", FileName: "logs.tar", SystemType: "storage"}, nil)
+		client.On("Send", id, mock.MatchedBy(func(d Data) bool { return d.FileName == "logs.tar" && d.SerialNumber == "YD01" }), "storage").Return(nil)
+		assert.Nil(t, dataAgent.Send(id))
+		client.AssertCalled(t, "Send", id, mock.Anything, "storage")
+	})
+}
@@ -1,10 +1,27 @@
 package usecases
 
 import (
+	"sync"
+	"time"
+
 	"b.yadro.com/sys/ch-server/domain"
 	"github.com/pkg/errors"
 )
 
+// States of upload lifecycle, see domain.State
+const (
+	StateCreated   = string(domain.StateCreated)
+	StateComplete  = string(domain.StateComplete)
+	StateForwarded = string(domain.StateForwarded)
+	StateFailed    = string(domain.StateFailed)
+	StateExpired   = string(domain.StateExpired)
+	StateCorrupted = string(domain.StateCorrupted)
+)
+
+// ErrNotUnique - upload with the same SerialNumber and LogCollectionTimestamp
+// is already stored
+var ErrNotUnique = errors.New("[usedata] [unique] metadata is not unique")
+
 // Data - struct for use in usecases, protect Data from domain package
 type Data struct {
 	ID                     int
@@ -17,16 +34,53 @@ type Data struct {
 	Originator             string
 	SessionID              string
 	Checksum               string
+	ChecksumResult         string
+	ComputedChecksum       string
 	Hostname               string
 	NotificationManager    string
 	Cancel                 string
 	StartTimestamp         string
 	FinishTimestamp        string
 	FileName               string
+	Attempts               int
+	LastError              string
+	State                  string
+	History                []Transition
+	Deliveries             map[string]Delivery
+	RemoteID               string
+	Attributes             map[string]string
+}
+
+// AttributePrefix - prefix of attributes in metadata of upload which is
+// given to handlers of forwarding, e.g. "Attributes.FirmwareVersion"
+const AttributePrefix = "Attributes."
+
+// Transition - change of upload state, protect Transition from domain package
+type Transition struct {
+	State     string
+	Timestamp string
+	Reason    string
+}
+
+// Filter - conditions to list uploads, empty field matches any upload.
+// From and To bound LogCollectionTimestamp, uploads are sorted by it,
+// Cursor is returned with previous page.
+type Filter struct {
+	SerialNumber string
+	Hostname     string
+	SystemType   string
+	State        string
+	RemoteID     string
+	Attributes   map[string]string
+	From         time.Time
+	To           time.Time
+	Descending   bool
+	Cursor       string
+	Limit        int
 }
 
 type httpClient interface {
-	Send(id string, name string, system string) error
+	Send(id string, data Data, route string) error
 	// TODO: - define Download or Send func
 }
 
@@ -34,12 +88,21 @@ type httpClient interface {
 type dataAgent struct {
 	DataRepository domain.DataRepository
 	DataClient     httpClient
+	// mu - serialize changes of metadata, it is read, changed and stored
+	// by hooks, sinks and admin concurrently
+	mu sync.Mutex
+	// routes - rules to choose destination, see SetRoutes
+	routes []domain.Route
+	reject bool
+	// grace - age of file without metadata which is orphaned, zero is
+	// DefaultOrphanGrace
+	grace time.Duration
 }
 
 func (agent *dataAgent) IsUnique(data Data) error {
 	unique := data.SerialNumber + data.LogCollectionTimestamp
 	if _, err := agent.DataRepository.FindById(unique); err == nil {
-		return errors.New("MetaData is not unique") // TODO: convert error to [usedata] [unique] for all file
+		return ErrNotUnique
 	}
 	return nil
 }
@@ -61,6 +124,10 @@ func (agent *dataAgent) Create(data Data) error {
 		StartTimestamp:         data.StartTimestamp,
 		FinishTimestamp:        data.FinishTimestamp,
 		FileName:               data.FileName,
+		Attributes:             data.Attributes,
+	}
+	if err := d.Transit(domain.StateCreated, ""); err != nil {
+		return errors.Wrap(err, "Create data")
 	}
 	if err := d.Validate(); err != nil {
 		return errors.Wrap(err, "Create data")
@@ -76,6 +143,10 @@ func (agent *dataAgent) Read(id string) (Data, error) {
 	if err != nil {
 		return Data{}, errors.Wrap(err, "Read data")
 	}
+	return fromDomain(d), nil
+}
+
+func fromDomain(d domain.Data) Data {
 	data := Data{
 		ID:                     d.ID,
 		Service:                d.Service,
@@ -87,17 +158,43 @@ func (agent *dataAgent) Read(id string) (Data, error) {
 		Originator:             d.Originator,
 		SessionID:              d.SessionID,
 		Checksum:               d.Checksum,
+		ChecksumResult:         d.ChecksumResult,
+		ComputedChecksum:       d.ComputedChecksum,
 		Hostname:               d.Hostname,
 		NotificationManager:    d.NotificationManager,
 		Cancel:                 d.Cancel,
 		StartTimestamp:         d.StartTimestamp,
 		FinishTimestamp:        d.FinishTimestamp,
 		FileName:               d.FileName,
+		Attempts:               d.Attempts,
+		LastError:              d.LastError,
+		State:                  string(d.State),
+		History:                make([]Transition, 0, len(d.History)),
+		RemoteID:               d.RemoteID,
+		Attributes:             d.Attributes,
 	}
-	return data, nil
+	for _, h := range d.History {
+		data.History = append(data.History, Transition{string(h.State), h.Timestamp, h.Reason})
+	}
+	if len(d.Deliveries) > 0 {
+		data.Deliveries = make(map[string]Delivery, len(d.Deliveries))
+		for sink, delivery := range d.Deliveries {
+			data.Deliveries[sink] = Delivery{
+				delivery.Required,
+				string(delivery.State),
+				delivery.Attempts,
+				delivery.LastError,
+				delivery.RemoteID,
+				delivery.Timestamp,
+			}
+		}
+	}
+	return data
 }
 
 func (agent *dataAgent) Update(id string, data Data) error {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
 	d, err := agent.DataRepository.FindById(id)
 	if err != nil {
 		return errors.Wrap(err, "Update data")
@@ -118,6 +215,8 @@ func (agent *dataAgent) Update(id string, data Data) error {
 	assignString(data.StartTimestamp, &d.StartTimestamp)
 	assignString(data.FinishTimestamp, &d.FinishTimestamp)
 	assignString(data.FileName, &d.FileName)
+	assignInt(data.Attempts, &d.Attempts)
+	assignString(data.LastError, &d.LastError)
 
 	if err := d.Validate(); err != nil {
 		return errors.Wrap(err, "Update data")
@@ -128,7 +227,88 @@ func (agent *dataAgent) Update(id string, data Data) error {
 	return nil
 }
 
+// Transit - change state of upload, the change is validated in domain
+func (agent *dataAgent) Transit(id string, state string, reason string) error {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	d, err := agent.DataRepository.FindById(id)
+	if err != nil {
+		return errors.Wrap(err, "[usedata] [transit]")
+	}
+	if err := d.Transit(domain.State(state), reason); err != nil {
+		return errors.Wrap(err, "[usedata] [transit]")
+	}
+	if err := agent.DataRepository.Store(d); err != nil {
+		return errors.Wrap(err, "[usedata] [transit]")
+	}
+	return nil
+}
+
+// Verify - compare checksum of uploaded file with checksum from metadata
+// and save result, upload with mismatched checksum is corrupted and
+// false is returned
+func (agent *dataAgent) Verify(id string) (bool, error) {
+	d, err := agent.DataRepository.FindById(id)
+	if err != nil {
+		return false, errors.Wrap(err, "[usedata] [verify]")
+	}
+	// File is hashed without lock, hashing of large file doesn't hold
+	// changes of other uploads
+	checksum := d.Checksum
+	algorithm, digest, parseErr := domain.ParseChecksum(checksum)
+	var computed string
+	if checksum != "" && parseErr == nil {
+		if computed, err = agent.DataRepository.Checksum(id, algorithm); err != nil {
+			return false, errors.Wrap(err, "[usedata] [verify]")
+		}
+	}
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	if d, err = agent.DataRepository.FindById(id); err != nil {
+		return false, errors.Wrap(err, "[usedata] [verify]")
+	}
+	if d.Checksum != checksum {
+		return false, errors.New("[usedata] [verify] checksum is changed while file is hashed")
+	}
+	valid := true
+	if checksum == "" {
+		d.ChecksumResult = domain.ChecksumMissing
+	} else if parseErr != nil {
+		valid = false
+		d.ChecksumResult = domain.ChecksumMismatch
+		if err := d.Transit(domain.StateCorrupted, parseErr.Error()); err != nil {
+			return false, errors.Wrap(err, "[usedata] [verify]")
+		}
+	} else {
+		d.ComputedChecksum = algorithm + ":" + computed
+		d.ChecksumResult = domain.ChecksumMatch
+		if computed != digest {
+			valid = false
+			d.ChecksumResult = domain.ChecksumMismatch
+			if err := d.Transit(domain.StateCorrupted, "checksum mismatch"); err != nil {
+				return false, errors.Wrap(err, "[usedata] [verify]")
+			}
+		}
+	}
+	if err := agent.DataRepository.Store(d); err != nil {
+		return false, errors.Wrap(err, "[usedata] [verify]")
+	}
+	return valid, nil
+}
+
+// Purge - delete uploaded file and keep metadata
+func (agent *dataAgent) Purge(id string) error {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	err := agent.DataRepository.Purge(id)
+	return errors.Wrap(err, "[usedata] [purge]")
+}
+
+// Delete - delete metadata and uploaded file, metadata isn't changed
+// meanwhile, so deleted upload isn't stored again by Deliver
 func (agent *dataAgent) Delete(id string) error {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
 	err := agent.DataRepository.Remove(id)
 	return errors.Wrap(err, "Delete data")
 }
@@ -138,10 +318,58 @@ func (agent *dataAgent) ReadAll() ([]string, error) {
 	return strings, errors.Wrap(err, "Delete data")
 }
 
+// List - return page of uploads matched by filter and cursor of next page,
+// cursor is empty on the last page
+func (agent *dataAgent) List(filter Filter) ([]Data, string, error) {
+	query := domain.Query{
+		SerialNumber: filter.SerialNumber,
+		Hostname:     filter.Hostname,
+		SystemType:   filter.SystemType,
+		State:        domain.State(filter.State),
+		RemoteID:     filter.RemoteID,
+		Attributes:   filter.Attributes,
+		From:         filter.From,
+		To:           filter.To,
+		Descending:   filter.Descending,
+		Cursor:       filter.Cursor,
+		Limit:        filter.Limit,
+	}
+	found, next, err := agent.DataRepository.Query(query)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "[usedata] [list]")
+	}
+	list := make([]Data, 0, len(found))
+	for _, d := range found {
+		list = append(list, fromDomain(d))
+	}
+	return list, next, nil
+}
+
+// Resend - send upload to extern server again, failed upload is complete again
+func (agent *dataAgent) Resend(id string) error {
+	meta, err := agent.Read(id)
+	if err != nil {
+		return errors.Wrap(err, "[usedata] [resend]")
+	}
+	switch meta.State {
+	case StateComplete:
+	case StateFailed:
+		if err := agent.Transit(id, StateComplete, "resend"); err != nil {
+			return errors.Wrap(err, "[usedata] [resend]")
+		}
+	default:
+		return errors.Errorf("[usedata] [resend] upload in state %q can't be sent", meta.State)
+	}
+	return errors.Wrap(agent.Send(id), "[usedata] [resend]")
+}
+
 func (agent *dataAgent) Send(id string) error {
 	meta, err := agent.Read(id)
 	if err == nil {
-		err = agent.DataClient.Send(id, meta.FileName, meta.SerialNumber)
+		var route string
+		if route, err = agent.Route(meta); err == nil {
+			err = agent.DataClient.Send(id, meta, route)
+		}
 	}
 	return errors.Wrap(err, "[usedata] [send]")
 }
@@ -151,7 +379,7 @@ func NewDataAgent(repo domain.DataRepository, client httpClient) (*dataAgent, er
 	if repo == nil || client == nil {
 		return nil, errors.New("[usedata] [new] bad argument")
 	}
-	return &dataAgent{repo, client}, nil
+	return &dataAgent{DataRepository: repo, DataClient: client}, nil
 }
 
 func assignInt(src int, dst *int) {
@@ -1,6 +1,8 @@
 package usecases
 
 import (
+	"time"
+
 	"b.yadro.com/sys/ch-server/domain"
 	"github.com/stretchr/testify/mock"
 )
@@ -9,8 +11,8 @@ type HttpClientMock struct {
 	mock.Mock
 }
 
-func (m *HttpClientMock) Send(id string, name string, system string) error {
-	args := m.Called(id, name, system)
+func (m *HttpClientMock) Send(id string, data Data, route string) error {
+	args := m.Called(id, data, route)
 	return args.Error(0)
 }
 
@@ -34,3 +36,27 @@ func (m *DataRepositoryMock) ReadAll() ([]string, error) {
 	args := m.Called()
 	return args.Get(0).([]string), args.Error(1)
 }
+func (m *DataRepositoryMock) Purge(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+func (m *DataRepositoryMock) Query(query domain.Query) ([]domain.Data, string, error) {
+	args := m.Called(query)
+	return args.Get(0).([]domain.Data), args.Get(1).(string), args.Error(2)
+}
+func (m *DataRepositoryMock) Checksum(id string, algorithm string) (string, error) {
+	args := m.Called(id, algorithm)
+	return args.String(0), args.Error(1)
+}
+func (m *DataRepositoryMock) Files() ([]string, error) {
+	args := m.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+func (m *DataRepositoryMock) Modified(id string) (time.Time, error) {
+	args := m.Called(id)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+func (m *DataRepositoryMock) Quarantine(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
@@ -2,6 +2,7 @@ package usecases
 
 import (
 	"testing"
+	"time"
 
 	"b.yadro.com/sys/ch-server/domain"
 	"github.com/stretchr/testify/assert"
@@ -206,6 +207,25 @@ func TestDeleteValid(t *testing.T) {
 	repo.AssertCalled(t, "Remove", id)
 	assert.Nil(t, err, "Delete need to be valid")
 }
+func TestDeleteWaitsChange(t *testing.T) {
+	repo := new(DataRepositoryMock)
+	client := new(HttpClientMock)
+	dataAgent, _ := NewDataAgent(
+		repo,
+		client)
+	assert.NotNil(t, dataAgent)
+	id := "0123456789"
+	repo.On("Remove", id).Return(nil)
+	// metadata is changed by Deliver, Delete waits until it is stored
+	dataAgent.mu.Lock()
+	done := make(chan error)
+	go func() { done <- dataAgent.Delete(id) }()
+	time.Sleep(20 * time.Millisecond)
+	repo.AssertNotCalled(t, "Remove", id)
+	dataAgent.mu.Unlock()
+	assert.Nil(t, <-done, "Delete need to be valid")
+	repo.AssertCalled(t, "Remove", id)
+}
 func TestReadAllInvalid(t *testing.T) {
 	repo := new(DataRepositoryMock)
 	client := new(HttpClientMock)
@@ -256,10 +276,10 @@ func TestSendInvalid(t *testing.T) {
 	id := "0123456789"
 	e := errors.New("failed")
 	repo.On("FindById", id).Return(domain.Data{}, nil)
-	client.On("Send", id, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(e)
+	client.On("Send", id, mock.AnythingOfType("usecases.Data"), "").Return(e)
 	err := dataAgent.Send(id)
 	repo.AssertCalled(t, "FindById", id)
-	client.AssertCalled(t, "Send", id, mock.AnythingOfType("string"), mock.AnythingOfType("string"))
+	client.AssertCalled(t, "Send", id, mock.AnythingOfType("usecases.Data"), "")
 	assert.Equal(t, errors.Cause(err), e)
 }
 func TestSendValid(t *testing.T) {
@@ -273,16 +293,51 @@ func TestSendValid(t *testing.T) {
 	repo.On("FindById", id).Return(domain.Data{}, nil)
 	client.On("Send", 
 				id, 
-				mock.AnythingOfType("string"), 
-				mock.AnythingOfType("string")).Return(nil)
+				mock.AnythingOfType("usecases.Data"),
+				"").Return(nil)
 	err := dataAgent.Send(id)
 	repo.AssertCalled(t, "FindById", id)
 	client.AssertCalled(t, 
 						"Send", 
 						id, 
-						mock.AnythingOfType("string"), 
-						mock.AnythingOfType("string"))
+						mock.AnythingOfType("usecases.Data"),
+						"")
+	assert.Nil(t, err)
+}
+
+func TestTransitValid(t *testing.T) {
+	repo := new(DataRepositoryMock)
+	client := new(HttpClientMock)
+	dataAgent, _ := NewDataAgent(
+		repo,
+		client)
+	id := "0123456789"
+	data := domain.Data{}
+	data.SessionID = id
+	data.State = domain.StateComplete
+	repo.On("FindById", id).Return(data, nil)
+	repo.On("Store", mock.Anything).Return(nil)
+	err := dataAgent.Transit(id, StateForwarded, "")
 	assert.Nil(t, err)
+	repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+		return d.State == domain.StateForwarded
+	}))
+}
+func TestTransitInvalid(t *testing.T) {
+	repo := new(DataRepositoryMock)
+	client := new(HttpClientMock)
+	dataAgent, _ := NewDataAgent(
+		repo,
+		client)
+	id := "0123456789"
+	data := domain.Data{}
+	data.SessionID = id
+	data.State = domain.StateCreated
+	repo.On("FindById", id).Return(data, nil)
+	repo.On("Store", mock.Anything).Return(nil)
+	err := dataAgent.Transit(id, StateForwarded, "")
+	assert.Error(t, err)
+	repo.AssertNotCalled(t, "Store", mock.Anything)
 }
 
 func TestNewDataAgentClientNil(t *testing.T) {
@@ -308,7 +363,7 @@ func TestNewDataAgent(t *testing.T) {
 	d, err := NewDataAgent(
 		repo,
 		client)
-	assert.Equal(t, d, &dataAgent{repo, client})
+	assert.Equal(t, d, &dataAgent{DataRepository: repo, DataClient: client})
 	assert.Nil(t, err)
 }
 func TestAssignInt(t *testing.T) {
@@ -324,4 +379,115 @@ func TestAssignString(t *testing.T) {
 	assert.Equal(t, "55", test)
 	assignString("77", &test)
 	assert.Equal(t, "77", test)
-}
\ No newline at end of file
+}
+func TestList(t *testing.T) {
+	repo := new(DataRepositoryMock)
+	client := new(HttpClientMock)
+	agent, _ := NewDataAgent(repo, client)
+	from, _ := time.Parse(time.RFC3339, "2019-08-01T00:00:00Z")
+	query := domain.Query{
+		SerialNumber: "0123456789",
+		State:        domain.StateComplete,
+		From:         from,
+		Descending:   true,
+		Cursor:       "00",
+		Limit:        10,
+	}
+	t.Run("valid list", func(t *testing.T) {
+		found := []domain.Data{{SessionID: "1", SerialNumber: "0123456789", State: domain.StateComplete}}
+		repo.On("Query", query).Return(found, "01", nil).Once()
+		list, next, err := agent.List(Filter{
+			SerialNumber: "0123456789",
+			State:        StateComplete,
+			From:         from,
+			Descending:   true,
+			Cursor:       "00",
+			Limit:        10,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "01", next)
+		assert.Len(t, list, 1)
+		assert.Equal(t, "1", list[0].SessionID)
+		assert.Equal(t, StateComplete, list[0].State)
+	})
+	t.Run("invalid query", func(t *testing.T) {
+		repo.On("Query", domain.Query{}).Return([]domain.Data(nil), "", errors.New("fail")).Once()
+		_, _, err := agent.List(Filter{})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	id := "0123456789"
+	meta := domain.Data{SessionID: id, SerialNumber: id, FileName: "logs.tar", State: domain.StateComplete}
+
+	t.Run("valid missing checksum", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		agent, _ := NewDataAgent(repo, new(HttpClientMock))
+		repo.On("FindById", id).Return(meta, nil)
+		repo.On("Store", mock.Anything).Return(nil)
+		valid, err := agent.Verify(id)
+		assert.Nil(t, err)
+		assert.True(t, valid)
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.ChecksumResult == domain.ChecksumMissing && d.State == domain.StateComplete
+		}))
+		repo.AssertNotCalled(t, "Checksum", id, mock.Anything)
+	})
+	t.Run("valid match", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		agent, _ := NewDataAgent(repo, new(HttpClientMock))
+		d := meta
+		d.Checksum = "SHA1:DA39A3EE5E6B4B0D3255BFEF95601890AFD80709"
+		repo.On("FindById", id).Return(d, nil)
+		repo.On("Checksum", id, "sha1").Return("da39a3ee5e6b4b0d3255bfef95601890afd80709", nil)
+		repo.On("Store", mock.Anything).Return(nil)
+		valid, err := agent.Verify(id)
+		assert.Nil(t, err)
+		assert.True(t, valid)
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.ChecksumResult == domain.ChecksumMatch &&
+				d.ComputedChecksum == "sha1:da39a3ee5e6b4b0d3255bfef95601890afd80709"
+		}))
+	})
+	t.Run("invalid mismatch", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		agent, _ := NewDataAgent(repo, new(HttpClientMock))
+		d := meta
+		d.Checksum = "d41d8cd98f00b204e9800998ecf8427e"
+		repo.On("FindById", id).Return(d, nil)
+		repo.On("Checksum", id, "md5").Return("00000000000000000000000000000000", nil)
+		repo.On("Store", mock.Anything).Return(nil)
+		valid, err := agent.Verify(id)
+		assert.Nil(t, err)
+		assert.False(t, valid)
+		repo.AssertCalled(t, "Store", mock.MatchedBy(func(d domain.Data) bool {
+			return d.ChecksumResult == domain.ChecksumMismatch && d.State == domain.StateCorrupted
+		}))
+	})
+	t.Run("invalid checksum of file", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		agent, _ := NewDataAgent(repo, new(HttpClientMock))
+		d := meta
+		d.Checksum = "d41d8cd98f00b204e9800998ecf8427e"
+		repo.On("FindById", id).Return(d, nil)
+		repo.On("Checksum", id, "md5").Return("", errors.New("fail"))
+		_, err := agent.Verify(id)
+		assert.NotNil(t, err)
+		repo.AssertNotCalled(t, "Store", mock.Anything)
+	})
+	t.Run("invalid checksum is changed while file is hashed", func(t *testing.T) {
+		repo := new(DataRepositoryMock)
+		agent, _ := NewDataAgent(repo, new(HttpClientMock))
+		d := meta
+		d.Checksum = "d41d8cd98f00b204e9800998ecf8427e"
+		changed := d
+		changed.Checksum = "sha1:da39a3ee5e6b4b0d3255bfef95601890afd80709"
+		repo.On("FindById", id).Return(d, nil).Once()
+		repo.On("FindById", id).Return(changed, nil).Once()
+		repo.On("Checksum", id, "md5").Return("d41d8cd98f00b204e9800998ecf8427e", nil)
+		_, err := agent.Verify(id)
+		assert.NotNil(t, err)
+		repo.AssertNotCalled(t, "Store", mock.Anything)
+	})
+}